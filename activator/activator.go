@@ -0,0 +1,274 @@
+// Package activator implements the component which receives the requests of
+// the functions scaled to zero: it holds the requests, scales the functions
+// up and forwards the requests once a replica is ready.
+package activator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	clientset "github.com/damnever/useless/pkg/generated/clientset/versioned"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions/useless/v1"
+	listers "github.com/damnever/useless/pkg/generated/listers/useless/v1"
+	"github.com/damnever/useless/pkg/podutil"
+)
+
+const (
+	// FunctionHeader tells the activator which function the request is
+	// for, in the form of <namespace>/<function>, the Host header is used
+	// if it is absent.
+	FunctionHeader = "X-Useless-Function"
+	// ColdStartHeader is the response header which reports how long the
+	// request has been held by the activator.
+	ColdStartHeader = "X-Useless-Cold-Start"
+)
+
+// Activator is the http.Handler which buffers the requests.
+type Activator struct {
+	uselessclientset clientset.Interface
+
+	funcsLister listers.FunctionLister
+	funcsSynced cache.InformerSynced
+	podsLister  corelistersv1.PodLister
+	podsSynced  cache.InformerSynced
+
+	timeout time.Duration
+	// port is the port of the function pods.
+	port int
+
+	mu sync.Mutex
+	// waiters are closed once the pods of the function changed, the key
+	// is namespace/function.
+	waiters map[string]chan struct{}
+}
+
+// New returns a new Activator, the requests are held for timeout at most.
+func New(uselessclientset clientset.Interface,
+	podInformer coreinformersv1.PodInformer,
+	funcInformer informers.FunctionInformer,
+	timeout time.Duration) *Activator {
+	a := &Activator{
+		uselessclientset: uselessclientset,
+		funcsLister:      funcInformer.Lister(),
+		funcsSynced:      funcInformer.Informer().HasSynced,
+		podsLister:       podInformer.Lister(),
+		podsSynced:       podInformer.Informer().HasSynced,
+		timeout:          timeout,
+		port:             uselessv1.FunctionPort,
+		waiters:          map[string]chan struct{}{},
+	}
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: a.handlePod,
+		UpdateFunc: func(old, new interface{}) {
+			a.handlePod(new)
+		},
+	})
+	return a
+}
+
+// Run serves the requests on laddr until stopCh is closed.
+func (a *Activator) Run(laddr string, stopCh <-chan struct{}) error {
+	klog.Info("Waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(stopCh, a.funcsSynced, a.podsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	server := &http.Server{Addr: laddr, Handler: a}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+	klog.Infof("Activator listening on %s", laddr)
+	select {
+	case err := <-errc:
+		return err
+	case <-stopCh:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+func (a *Activator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	function, err := a.resolve(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.timeout)
+	defer cancel()
+	pod, err := a.activate(ctx, function)
+	if err != nil {
+		klog.Warningf("activating %s/%s failed: %v", function.Namespace, function.Name, err)
+		http.Error(w, fmt.Sprintf("function is not available: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	coldStart := time.Since(start)
+	klog.Infof("%s %s for %s/%s held %v", r.Method, r.URL.Path, function.Namespace, function.Name, coldStart)
+	w.Header().Set(ColdStartHeader, coldStart.String())
+
+	target := net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(a.port))
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = target
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// resolve finds the function by the FunctionHeader or the Host header, the
+// latter is one of <function>, <function>.<namespace>,
+// <function>.<namespace>.svc and <function>.<namespace>.svc.<cluster-domain>.
+func (a *Activator) resolve(r *http.Request) (*uselessv1.Function, error) {
+	var namespace, name string
+	if value := r.Header.Get(FunctionHeader); value != "" {
+		parts := strings.SplitN(value, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s header: %q", FunctionHeader, value)
+		}
+		namespace, name = parts[0], parts[1]
+	} else {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		parts := strings.Split(host, ".")
+		name = parts[0]
+		if len(parts) == 2 || (len(parts) > 2 && parts[2] == "svc") {
+			namespace = parts[1]
+		}
+	}
+
+	functions, err := a.funcsLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var found *uselessv1.Function
+	for _, function := range functions {
+		if function.Spec.FuncName != name || (namespace != "" && function.Namespace != namespace) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("function %q is ambiguous, namespace is required", name)
+		}
+		found = function
+	}
+	if found == nil {
+		return nil, fmt.Errorf("function %q not found", name)
+	}
+	return found, nil
+}
+
+// activate waits until there is a ready pod of the function, and scales the
+// function up if it is scaled to zero.
+func (a *Activator) activate(ctx context.Context, function *uselessv1.Function) (*corev1.Pod, error) {
+	key := function.Namespace + "/" + function.Spec.FuncName
+	for {
+		// Take the waiter before checking, so no change can be missed.
+		waiter := a.waiter(key)
+		pod, err := a.readyPod(function)
+		if err != nil {
+			return nil, err
+		}
+		if pod != nil {
+			return pod, nil
+		}
+		if err := a.scaleUp(function); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-waiter:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (a *Activator) readyPod(function *uselessv1.Function) (*corev1.Pod, error) {
+	pods, err := a.podsLister.Pods(function.Namespace).List(labels.SelectorFromSet(function.PodLabels()))
+	if err != nil {
+		return nil, err
+	}
+	ready := make([]*corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if podutil.IsPodReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	if len(ready) == 0 {
+		return nil, nil
+	}
+	return ready[rand.Intn(len(ready))], nil
+}
+
+func (a *Activator) scaleUp(function *uselessv1.Function) error {
+	// The cached one may be outdated.
+	function, err := a.funcsLister.Functions(function.Namespace).Get(function.Name)
+	if err != nil {
+		return err
+	}
+	if function.Spec.Replicas == nil || *function.Spec.Replicas > 0 {
+		return nil
+	}
+	replicas, _ := function.ReplicasRange()
+	if replicas < 1 {
+		replicas = 1
+	}
+	klog.Infof("scaling %s/%s from zero to %d", function.Namespace, function.Name, replicas)
+	patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas)
+	_, err = a.uselessclientset.UselessV1().Functions(function.Namespace).Patch(
+		context.TODO(), function.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+func (a *Activator) waiter(key string) <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	waiter, ok := a.waiters[key]
+	if !ok {
+		waiter = make(chan struct{})
+		a.waiters[key] = waiter
+	}
+	return waiter
+}
+
+func (a *Activator) handlePod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	name, ok := pod.Labels["function"]
+	if !ok {
+		return
+	}
+	key := pod.Namespace + "/" + name
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if waiter, ok := a.waiters[key]; ok {
+		close(waiter)
+		delete(a.waiters, key)
+	}
+}
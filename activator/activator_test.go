@@ -0,0 +1,232 @@
+package activator
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/generated/clientset/versioned/fake"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions"
+)
+
+type fixture struct {
+	t *testing.T
+
+	client    *fake.Clientset
+	activator *Activator
+	k8sI      kubeinformers.SharedInformerFactory
+	i         informers.SharedInformerFactory
+}
+
+func newFixture(t *testing.T, timeout time.Duration, functions ...*uselessv1.Function) *fixture {
+	f := &fixture{t: t}
+	f.client = fake.NewSimpleClientset()
+	for _, function := range functions {
+		f.client.Tracker().Add(function)
+	}
+	f.i = informers.NewSharedInformerFactory(f.client, 0)
+	f.k8sI = kubeinformers.NewSharedInformerFactory(k8sfake.NewSimpleClientset(), 0)
+	f.activator = New(f.client, f.k8sI.Core().V1().Pods(), f.i.Useless().V1().Functions(), timeout)
+	for _, function := range functions {
+		f.i.Useless().V1().Functions().Informer().GetIndexer().Add(function)
+	}
+	return f
+}
+
+// addReadyPod adds a ready pod of the function which is served by backend.
+func (f *fixture) addReadyPod(function *uselessv1.Function, backend *httptest.Server) {
+	host, port, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.activator.port, _ = strconv.Atoi(port)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: function.Namespace,
+			Name:      function.Name + "-abcde",
+			Labels:    function.PodLabels(),
+		},
+		Status: corev1.PodStatus{
+			PodIP:      host,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	f.k8sI.Core().V1().Pods().Informer().GetIndexer().Add(pod)
+	f.activator.handlePod(pod)
+}
+
+func newFunction(name string, replicas int32) *uselessv1.Function {
+	return &uselessv1.Function{
+		TypeMeta: metav1.TypeMeta{APIVersion: uselessv1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: uselessv1.FunctionSpec{
+			FuncName: name,
+			Image:    "useless/" + name + ":latest",
+			Replicas: &replicas,
+		},
+	}
+}
+
+func newBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func patchActions(client *fake.Clientset) []core.PatchAction {
+	actions := []core.PatchAction{}
+	for _, action := range client.Actions() {
+		if patch, ok := action.(core.PatchAction); ok {
+			actions = append(actions, patch)
+		}
+	}
+	return actions
+}
+
+func TestForwardsToReadyPod(t *testing.T) {
+	function := newFunction("test", 1)
+	f := newFixture(t, time.Second, function)
+	f.addReadyPod(function, newBackend(t))
+
+	req := httptest.NewRequest(http.MethodGet, "/hi", nil)
+	req.Host = "test.default.svc.cluster.local"
+	w := httptest.NewRecorder()
+	f.activator.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "hello from /hi" {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Body.String())
+	}
+	if actions := patchActions(f.client); len(actions) != 0 {
+		t.Errorf("expected no scaling, got %+v", actions)
+	}
+}
+
+func TestScalesFromZero(t *testing.T) {
+	function := newFunction("test", 0)
+	function.Spec.Autoscaling = &uselessv1.AutoscalingSpec{
+		Class:       uselessv1.ConcurrencyAutoscaling,
+		MinReplicas: func(i int32) *int32 { return &i }(0),
+		ScaleToZero: &uselessv1.ScaleToZeroSpec{},
+	}
+	f := newFixture(t, 5*time.Second, function)
+	backend := newBackend(t)
+
+	done := make(chan *httptest.ResponseRecorder, 2)
+	for i := 0; i < cap(done); i++ {
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/hi", nil)
+			req.Header.Set(FunctionHeader, "default/test")
+			w := httptest.NewRecorder()
+			f.activator.ServeHTTP(w, req)
+			done <- w
+		}()
+	}
+
+	// The requests are held until the scaled pod is ready.
+	deadline := time.After(5 * time.Second)
+	for len(patchActions(f.client)) == 0 {
+		select {
+		case w := <-done:
+			t.Fatalf("request is not held: %d %q", w.Code, w.Body.String())
+		case <-deadline:
+			t.Fatal("function is not scaled up")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	patch := patchActions(f.client)[0]
+	if name, expected := patch.GetName(), "test"; name != expected {
+		t.Errorf("expected patch of %s, got %s", expected, name)
+	}
+	if body, expected := string(patch.GetPatch()), `{"spec":{"replicas":1}}`; body != expected {
+		t.Errorf("expected patch %s, got %s", expected, body)
+	}
+
+	f.addReadyPod(function, backend)
+	for i := 0; i < cap(done); i++ {
+		w := <-done
+		if w.Code != http.StatusOK || w.Body.String() != "hello from /hi" {
+			t.Fatalf("unexpected response: %d %q", w.Code, w.Body.String())
+		}
+		if w.Header().Get(ColdStartHeader) == "" {
+			t.Errorf("expected the %s header", ColdStartHeader)
+		}
+	}
+}
+
+func TestTimesOut(t *testing.T) {
+	function := newFunction("test", 0)
+	f := newFixture(t, 50*time.Millisecond, function)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(FunctionHeader, "default/test")
+	w := httptest.NewRecorder()
+	f.activator.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	test := newFunction("test", 1)
+	other := newFunction("other", 1)
+	otherInKube := newFunction("other", 1)
+	otherInKube.Namespace = "kube-system"
+
+	testCases := []struct {
+		name     string
+		host     string
+		header   string
+		expected *uselessv1.Function
+	}{
+		{name: "name", host: "test", expected: test},
+		{name: "port", host: "test:8080", expected: test},
+		{name: "namespace", host: "other.kube-system", expected: otherInKube},
+		{name: "svc", host: "other.default.svc", expected: other},
+		{name: "cluster domain", host: "other.default.svc.cluster.local", expected: other},
+		{name: "header", host: "activator", header: "kube-system/other", expected: otherInKube},
+		{name: "ambiguous", host: "other"},
+		{name: "not found", host: "nope.default"},
+		{name: "invalid header", host: "test", header: "test"},
+	}
+	f := newFixture(t, time.Second, test, other, otherInKube)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tc.host
+			if tc.header != "" {
+				req.Header.Set(FunctionHeader, tc.header)
+			}
+			function, err := f.activator.resolve(req)
+			if tc.expected == nil {
+				if err == nil {
+					t.Errorf("expected error, got %s/%s", function.Namespace, function.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if function.Namespace != tc.expected.Namespace || function.Name != tc.expected.Name {
+				t.Errorf("expected %s/%s, got %s/%s", tc.expected.Namespace, tc.expected.Name,
+					function.Namespace, function.Name)
+			}
+		})
+	}
+}
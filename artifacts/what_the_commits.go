@@ -1,18 +1,21 @@
-package main
+package artifacts
 
 import (
 	"context"
-	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"sync"
+
+	uselessruntime "github.com/damnever/useless/runtime"
 )
 
-func WhatTheCommits(ctx context.Context, input string) (output string, err error) {
-	var in struct {
-		Count int `json:"count"`
-	}
-	if err = json.Unmarshal([]byte(input), &in); err != nil {
+type WhatTheCommitsInput struct {
+	Count int `json:"count"`
+}
+
+func WhatTheCommits(ctx context.Context, in WhatTheCommitsInput) (commits []string, err error) {
+	if in.Count <= 0 {
+		err = uselessruntime.Errorf(uselessruntime.CodeInvalidInput, "count must be positive, got %d", in.Count)
 		return
 	}
 
@@ -60,20 +63,17 @@ func WhatTheCommits(ctx context.Context, input string) (output string, err error
 		close(commitc)
 	}()
 
-	commits := []string{}
+	commits = []string{}
 	for commit := range commitc {
 		if err = commit.err; err != nil {
+			err = uselessruntime.Errorf(uselessruntime.CodeUnavailable, "fetching commit: %v", err)
 			return
 		}
 		commits = append(commits, commit.commit)
 	}
 	if len(commits) != in.Count {
-		err = ctx.Err() // Context cancelled, maybe check to ensure it.
+		commits, err = nil, ctx.Err() // Context cancelled, maybe check to ensure it.
 		return
 	}
-	var data []byte
-	if data, err = json.Marshal(commits); err == nil {
-		output = string(data)
-	}
 	return
 }
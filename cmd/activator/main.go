@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog/v2"
+
+	"github.com/damnever/useless/activator"
+	clientset "github.com/damnever/useless/pkg/generated/clientset/versioned"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions"
+)
+
+func main() {
+	var (
+		flagMasterURL  string
+		flagKubeConfig string
+		flagListenAddr string
+		flagTimeout    time.Duration
+	)
+	flag.StringVar(&flagMasterURL, "master", "",
+		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
+	if _, err := os.Stat(kubeconfig); err == nil {
+		flag.StringVar(&flagKubeConfig, "kubeconfig", kubeconfig,
+			"Absolute path to the kubeconfig file. Only required if out-of-cluster.")
+	} else {
+		flag.StringVar(&flagKubeConfig, "kubeconfig", "",
+			"Absolute path to the kubeconfig file. Only required if out-of-cluster.")
+	}
+	flag.StringVar(&flagListenAddr, "laddr", ":8080", "The listen address.")
+	flag.DurationVar(&flagTimeout, "timeout", 2*time.Minute,
+		"How long a request can be held while the function is scaling up.")
+	flag.Parse()
+	klog.SetOutput(os.Stdout)
+
+	config, err := clientcmd.BuildConfigFromFlags(flagMasterURL, flagKubeConfig)
+	if err != nil {
+		klog.Fatalf("Error building kubeconfig: %s", err)
+	}
+	kubeClient := kubernetes.NewForConfigOrDie(config)
+	uselessClient := clientset.NewForConfigOrDie(config)
+
+	stopCh := make(chan struct{})
+	watchStopSignals(stopCh)
+
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
+	uselessInformerFactory := informers.NewSharedInformerFactory(uselessClient, time.Second*30)
+	activator := activator.New(uselessClient,
+		kubeInformerFactory.Core().V1().Pods(),
+		uselessInformerFactory.Useless().V1().Functions(),
+		flagTimeout)
+
+	kubeInformerFactory.Start(stopCh)
+	uselessInformerFactory.Start(stopCh)
+	if err := activator.Run(flagListenAddr, stopCh); err != nil {
+		klog.Fatalf("Error running activator: %s", err.Error())
+	}
+}
+
+func watchStopSignals(stopCh chan struct{}) {
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		close(stopCh)
+		<-sigc
+		os.Exit(1)
+	}()
+}
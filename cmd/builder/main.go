@@ -0,0 +1,145 @@
+// The builder builds the image of a Function from the source stored in it,
+// it runs in the build Jobs created by the controller. The image pinned by
+// the digest is written to the termination log once it is pushed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/damnever/useless/pkg/builder"
+	clientset "github.com/damnever/useless/pkg/generated/clientset/versioned"
+	"github.com/damnever/useless/pkg/oci"
+)
+
+func main() {
+	var (
+		flagMasterURL      string
+		flagKubeConfig     string
+		flagNamespace      string
+		flagFunction       string
+		flagSourceHash     string
+		flagRegistry       string
+		flagPlainHTTP      bool
+		flagPlatforms      string
+		flagRepoDir        string
+		flagTerminationLog string
+	)
+	flag.StringVar(&flagMasterURL, "master", "",
+		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&flagKubeConfig, "kubeconfig", "",
+		"Absolute path to the kubeconfig file. Only required if out-of-cluster.")
+	flag.StringVar(&flagNamespace, "namespace", "useless", "The namespace of the function.")
+	flag.StringVar(&flagFunction, "function", "", "The name of the function.")
+	flag.StringVar(&flagSourceHash, "source-hash", "",
+		"The hash of the source to build, the build fails if the source has changed since.")
+	flag.StringVar(&flagRegistry, "registry", "", "The registry which the image is pushed to.")
+	flag.BoolVar(&flagPlainHTTP, "plain-http", false, "Push the image by http.")
+	flag.StringVar(&flagPlatforms, "platforms", builder.GOOS+"/"+builder.DefaultGOARCH,
+		"The comma separated platforms of the image.")
+	flag.StringVar(&flagRepoDir, "repo", ".", "The root of the useless repository, the runtime is built from it.")
+	flag.StringVar(&flagTerminationLog, "termination-log", "/dev/termination-log",
+		"The file which the result is written to.")
+	flag.Parse()
+
+	image, err := build(flagMasterURL, flagKubeConfig, flagNamespace, flagFunction, flagSourceHash,
+		flagRegistry, flagPlainHTTP, flagPlatforms, flagRepoDir)
+	if err != nil {
+		// The termination message falls back to the tail of the logs, which
+		// has the output of the compiler as well.
+		fmt.Fprintf(os.Stderr, "Build failed: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(flagTerminationLog, []byte(image), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Write termination log failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Image pushed: %s\n", image)
+}
+
+// build builds and pushes the image of the function, it returns the image
+// name with the pushed digest.
+func build(masterURL, kubeConfig, namespace, name, sourceHash, registry string,
+	plainHTTP bool, platformList, repoDir string) (string, error) {
+
+	if name == "" || sourceHash == "" || registry == "" {
+		return "", fmt.Errorf("-function, -source-hash and -registry are required")
+	}
+	platforms, err := builder.ParsePlatforms(platformList)
+	if err != nil {
+		return "", fmt.Errorf("invalid -platforms: %v", err)
+	}
+	if repoDir, err = filepath.Abs(repoDir); err != nil {
+		return "", err
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeConfig)
+	if err != nil {
+		return "", fmt.Errorf("build config failed: %v", err)
+	}
+	uselessClient, err := clientset.NewForConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("create clientset failed: %v", err)
+	}
+	function, err := uselessClient.UselessV1().Functions(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get function failed: %v", err)
+	}
+	if hash := function.SourceHash(); hash != sourceHash {
+		return "", fmt.Errorf("the source has changed since the build started: %s != %s", hash, sourceHash)
+	}
+
+	workDir, err := ioutil.TempDir("", "useless-build-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+	source, err := builder.SourceFromSpec(&function.Spec, filepath.Join(workDir, "src"))
+	if err != nil {
+		return "", fmt.Errorf("invalid function: %v", err)
+	}
+	files, err := builder.ModuleFiles(source)
+	if err != nil {
+		return "", err
+	}
+	b := &builder.Builder{
+		RepoDir:   repoDir,
+		BuildDir:  filepath.Join(workDir, "bin", "func-main"),
+		CreatedBy: "useless-builder",
+	}
+	tag, err := b.ImageTag(files, platforms)
+	if err != nil {
+		return "", err
+	}
+	image := builder.ImageRepo(source.Sig.Name, registry) + ":" + tag
+	ref, err := oci.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	layout, err := oci.OpenLayout(filepath.Join(workDir, "oci-layout"))
+	if err != nil {
+		return "", fmt.Errorf("open OCI layout: %v", err)
+	}
+	client := oci.NewClient()
+	client.PlainHTTP = plainHTTP
+	if err := b.Prepare(files); err != nil {
+		return "", err
+	}
+	desc, err := b.BuildOCI(source, layout, client, platforms, image)
+	if err != nil {
+		return "", err
+	}
+	digest, err := client.Push(layout, desc, ref)
+	if err != nil {
+		return "", fmt.Errorf("push %s: %v", image, err)
+	}
+	return image + "@" + string(digest), nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/builder"
+	"github.com/damnever/useless/pkg/generated/clientset/versioned"
+)
+
+// maxDiffValueLen is the length above which the values are summarised in
+// the diff, e.g. the source files.
+const maxDiffValueLen = 60
+
+func runApply(fs *flag.FlagSet, args []string) error {
+	var flags deployFlags
+	flags.register(fs)
+	file := fs.String("f", defaultManifest, "the manifest file")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if err := flags.buildFlags.validate(); err != nil {
+		return err
+	}
+	m, source, current, funcclientset, err := loadApplied(&flags.kubeFlags, *file)
+	if err != nil {
+		return err
+	}
+
+	image, rebuild, err := m.image(source, current, flags.buildFlags)
+	if err != nil {
+		return err
+	}
+	if rebuild || (flags.build && m.Image == "" && m.Build != clusterBuild && m.Mode != uselessv1.InterpretedMode) {
+		buildFlags := flags.buildFlags
+		buildFlags.dockerReg = m.registry(flags.dockerReg)
+		if image, err = build(source, buildFlags, true); err != nil {
+			return err
+		}
+	}
+	desired := m.function(m.namespace(flags.namespace), source, image, current)
+
+	functions := funcclientset.UselessV1().Functions(desired.Namespace)
+	if current == nil {
+		if _, err := functions.Create(context.TODO(), desired, metav1.CreateOptions{}); err != nil {
+			return apiErrorf(err, "create function failed")
+		}
+		fmt.Printf("Function created: %s\n", desired.Name)
+		return nil
+	}
+	if len(diffFunctions(current, desired)) == 0 {
+		fmt.Printf("Function unchanged: %s\n", desired.Name)
+		return nil
+	}
+	function := current.DeepCopy()
+	if function.Annotations == nil {
+		function.Annotations = map[string]string{}
+	}
+	for key, value := range desired.Annotations {
+		function.Annotations[key] = value
+	}
+	function.Spec = desired.Spec
+	if _, err := functions.Update(context.TODO(), function, metav1.UpdateOptions{}); err != nil {
+		return apiErrorf(err, "update function failed")
+	}
+	fmt.Printf("Function updated: %s\n", desired.Name)
+	return nil
+}
+
+func runDiff(fs *flag.FlagSet, args []string) error {
+	var (
+		kube       kubeFlags
+		imageFlags buildFlags
+	)
+	kube.register(fs)
+	imageFlags.register(fs)
+	file := fs.String("f", defaultManifest, "the manifest file")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if err := imageFlags.validate(); err != nil {
+		return err
+	}
+	m, source, current, _, err := loadApplied(&kube, *file)
+	if err != nil {
+		return err
+	}
+
+	image, _, err := m.image(source, current, imageFlags)
+	if err != nil {
+		return err
+	}
+	desired := m.function(m.namespace(kube.namespace), source, image, current)
+	if current == nil {
+		fmt.Printf("Function %s/%s does not exist, it will be created.\n", desired.Namespace, desired.Name)
+	}
+	diffs := diffFunctions(current, desired)
+	if len(diffs) == 0 {
+		fmt.Println("No differences.")
+		return nil
+	}
+	printDiffs(os.Stdout, diffs)
+	return nil
+}
+
+// loadApplied loads the manifest and the function source, and gets the
+// function from the cluster, the function is nil if it does not exist.
+func loadApplied(flags *kubeFlags, file string) (*manifest, *builder.Source, *uselessv1.Function, versioned.Interface, error) {
+	m, err := loadManifest(file)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	source, err := m.loadSource()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if m.Mode == uselessv1.InterpretedMode {
+		if err := source.CheckInterpretable(); err != nil {
+			return nil, nil, nil, nil, userErrorf("the function can not be interpreted: %v", err)
+		}
+	}
+	_, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	current, err := funcclientset.UselessV1().Functions(m.namespace(flags.namespace)).Get(context.TODO(), m.name(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return m, source, nil, funcclientset, nil
+	}
+	if err != nil {
+		return nil, nil, nil, nil, apiErrorf(err, "get function failed")
+	}
+	return m, source, current, funcclientset, nil
+}
+
+// image returns the image of the function, and whether it must be built
+// since the image of the sources is not the one in use, the image is empty
+// if it is built in the cluster or the function is interpreted.
+func (m *manifest) image(source *builder.Source, current *uselessv1.Function, flags buildFlags) (string, bool, error) {
+	if m.Image != "" || m.Build == clusterBuild || m.Mode == uselessv1.InterpretedMode {
+		return m.Image, false, nil
+	}
+	flags.dockerReg = m.registry(flags.dockerReg)
+	image, err := imageName(source, flags)
+	if err != nil {
+		return "", false, err
+	}
+	if current != nil && imageWithoutDigest(current.Spec.Image) == image {
+		return current.Spec.Image, false, nil
+	}
+	return image, true, nil
+}
+
+// fieldDiff is the difference of a field, the values are JSON encoded, the
+// old or the new one is empty if the field is added or removed.
+type fieldDiff struct {
+	path     string
+	old, new string
+}
+
+// diffFunctions compares the annotations managed by the cli and the specs,
+// current can be nil.
+func diffFunctions(current, desired *uselessv1.Function) []fieldDiff {
+	oldFields, newFields := map[string]string{}, map[string]string{}
+	if current != nil {
+		flattenJSON(oldFields, "", appliedFields(current, desired))
+	}
+	flattenJSON(newFields, "", appliedFields(desired, desired))
+
+	paths := []string{}
+	for path := range oldFields {
+		paths = append(paths, path)
+	}
+	for path := range newFields {
+		if _, ok := oldFields[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	diffs := []fieldDiff{}
+	for _, path := range paths {
+		if oldFields[path] != newFields[path] {
+			diffs = append(diffs, fieldDiff{path: path, old: oldFields[path], new: newFields[path]})
+		}
+	}
+	return diffs
+}
+
+// appliedFields returns the JSON form of the fields of the function which
+// are declared by the manifest.
+func appliedFields(function, desired *uselessv1.Function) interface{} {
+	annotations := map[string]string{}
+	for key := range desired.Annotations {
+		if value, ok := function.Annotations[key]; ok {
+			annotations[key] = value
+		}
+	}
+	fields := map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+		"spec":     function.Spec,
+	}
+	// Round trip through JSON, so the values are compared in the same form
+	// as they are stored.
+	data, err := json.Marshal(fields)
+	if err != nil {
+		panic(err) // The types are always encodable.
+	}
+	var obj interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// flattenJSON flattens obj into the leaf values keyed by the paths like
+// spec.env[0].name, the keys which are not identifiers are quoted, e.g.
+// spec.source.files["main.go"].
+func flattenJSON(fields map[string]string, path string, obj interface{}) {
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if identPattern.MatchString(key) {
+				key = "." + key
+				if path == "" {
+					key = key[1:]
+				}
+			} else {
+				key = fmt.Sprintf("[%q]", key)
+			}
+			flattenJSON(fields, path+key, value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			flattenJSON(fields, fmt.Sprintf("%s[%d]", path, i), value)
+		}
+	case nil:
+		// The null and missing fields are the same.
+	default:
+		data, _ := json.Marshal(v)
+		fields[path] = string(data)
+	}
+}
+
+func printDiffs(w io.Writer, diffs []fieldDiff) {
+	for _, diff := range diffs {
+		switch {
+		case diff.old == "":
+			fmt.Fprintf(w, "+ %s: %s\n", diff.path, diffValue(diff.new))
+		case diff.new == "":
+			fmt.Fprintf(w, "- %s: %s\n", diff.path, diffValue(diff.old))
+		case isLongValue(diff.old) || isLongValue(diff.new):
+			fmt.Fprintf(w, "~ %s: (changed, %d -> %d bytes)\n", diff.path, valueLen(diff.old), valueLen(diff.new))
+		default:
+			fmt.Fprintf(w, "~ %s: %s -> %s\n", diff.path, diff.old, diff.new)
+		}
+	}
+}
+
+func diffValue(value string) string {
+	if isLongValue(value) {
+		return fmt.Sprintf("(%d bytes)", valueLen(value))
+	}
+	return value
+}
+
+func isLongValue(value string) bool {
+	return len(value) > maxDiffValueLen || strings.Contains(value, `\n`)
+}
+
+// valueLen returns the length of the decoded value.
+func valueLen(value string) int {
+	var s string
+	if err := json.Unmarshal([]byte(value), &s); err == nil {
+		return len(s)
+	}
+	return len(value)
+}
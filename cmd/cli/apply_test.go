@@ -0,0 +1,283 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/builder"
+)
+
+const helloSource = `package hello
+
+func Hello(input string) (string, error) {
+	return "hello " + input, nil
+}
+`
+
+func int32Ptr(i int32) *int32 { return &i }
+
+// writeManifest writes the manifest and the source of the function Hello
+// in a temporary directory, and returns the path of the manifest.
+func writeManifest(t *testing.T, manifest string) string {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello.go"), []byte(helloSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(dir, defaultManifest)
+	if err := ioutil.WriteFile(fpath, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fpath
+}
+
+func TestLoadManifest(t *testing.T) {
+	testCases := []struct {
+		name        string
+		manifest    string
+		expectError bool
+	}{
+		{name: "valid", manifest: "source: {path: hello.go, function: Hello}\nreplicas: 2\n"},
+		{name: "unknown field", manifest: "source: {path: hello.go, function: Hello}\nreplica: 2\n", expectError: true},
+		{name: "no source", manifest: "name: hello\n", expectError: true},
+		{name: "invalid name", manifest: "name: Hello\nsource: {path: hello.go, function: Hello}\n", expectError: true},
+		{name: "cluster build", manifest: "source: {path: hello.go, function: Hello}\nbuild: cluster\n"},
+		{
+			name:        "cluster build with image",
+			manifest:    "source: {path: hello.go, function: Hello}\nbuild: cluster\nimage: hello:v1\n",
+			expectError: true,
+		},
+		{name: "interpreted", manifest: "source: {path: hello.go, function: Hello}\nmode: interpreted\n"},
+		{
+			name:        "interpreted with image",
+			manifest:    "source: {path: hello.go, function: Hello}\nmode: interpreted\nimage: hello:v1\n",
+			expectError: true,
+		},
+		{
+			name:        "interpreted with build",
+			manifest:    "source: {path: hello.go, function: Hello}\nmode: interpreted\nbuild: cluster\n",
+			expectError: true,
+		},
+		{name: "unknown mode", manifest: "source: {path: hello.go, function: Hello}\nmode: jit\n", expectError: true},
+		{name: "wasm", manifest: "source: {path: hello.go, function: Hello}\nruntime: wasm\nsandbox: {memoryLimitMiB: 16}\n"},
+		{
+			name:        "sandbox without wasm",
+			manifest:    "source: {path: hello.go, function: Hello}\nsandbox: {timeoutSeconds: 1}\n",
+			expectError: true,
+		},
+		{
+			name:        "interpreted wasm",
+			manifest:    "source: {path: hello.go, function: Hello}\nmode: interpreted\nruntime: wasm\n",
+			expectError: true,
+		},
+		{name: "unknown runtime", manifest: "source: {path: hello.go, function: Hello}\nruntime: jvm\n", expectError: true},
+		{name: "unknown build", manifest: "source: {path: hello.go, function: Hello}\nbuild: remote\n", expectError: true},
+		{name: "negative replicas", manifest: "source: {path: hello.go, function: Hello}\nreplicas: -1\n", expectError: true},
+		{
+			name:        "duplicate triggers",
+			manifest:    "source: {path: hello.go, function: Hello}\ntriggers: [{name: a, schedule: '@daily'}, {name: a, schedule: '@hourly'}]\n",
+			expectError: true,
+		},
+		{
+			name:        "long CronJob name",
+			manifest:    "source: {path: hello.go, function: Hello}\ntriggers: [{name: " + strings.Repeat("a", 47) + ", schedule: '@daily'}]\n",
+			expectError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadManifest(writeManifest(t, tc.manifest))
+			if !tc.expectError {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if code := exitCode(err); code != exitUser {
+				t.Errorf("expected exit code %d, got %d: %v", exitUser, code, err)
+			}
+		})
+	}
+}
+
+func TestManifestImage(t *testing.T) {
+	m, err := loadManifest(writeManifest(t, "source: {path: hello.go, function: Hello}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := m.loadSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := testBuildFlags(t, "linux/amd64")
+	image, err := imageName(source, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := image + "@sha256:0123456789abcdef"
+	built := m.function(defaultNamespace, source, pinned, nil)
+	outdated := m.function(defaultNamespace, source, "registry/hello:0123456789abcdef0123@sha256:0123", nil)
+
+	testCases := []struct {
+		name            string
+		image           string
+		build           string
+		mode            uselessv1.FunctionMode
+		current         *uselessv1.Function
+		expectedImage   string
+		expectedRebuild bool
+	}{
+		{name: "new", expectedImage: image, expectedRebuild: true},
+		{name: "unchanged", current: built, expectedImage: pinned},
+		{name: "sources changed", current: outdated, expectedImage: image, expectedRebuild: true},
+		{name: "prebuilt", image: "prebuilt/hello:v2", current: outdated, expectedImage: "prebuilt/hello:v2"},
+		{name: "cluster build", build: clusterBuild, current: outdated},
+		{name: "interpreted", mode: uselessv1.InterpretedMode, current: outdated},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m.Image, m.Build, m.Mode = tc.image, tc.build, tc.mode
+			image, rebuild, err := m.image(source, tc.current, flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image != tc.expectedImage || rebuild != tc.expectedRebuild {
+				t.Errorf("expected %s and rebuild %v, got %s and %v", tc.expectedImage, tc.expectedRebuild, image, rebuild)
+			}
+		})
+	}
+}
+
+func TestManifestReplicas(t *testing.T) {
+	m, err := loadManifest(writeManifest(t, "source: {path: hello.go, function: Hello}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := m.loadSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := m.function(defaultNamespace, source, "hello", nil)
+	current.Spec.Replicas = int32Ptr(3)
+	disabled := &uselessv1.AutoscalingSpec{Disabled: true}
+
+	testCases := []struct {
+		name        string
+		replicas    *int32
+		autoscaling *uselessv1.AutoscalingSpec
+		current     *uselessv1.Function
+		expected    int32
+	}{
+		{name: "new", expected: 1},
+		{name: "declared", replicas: int32Ptr(2), autoscaling: disabled, current: current, expected: 2},
+		{name: "not declared", autoscaling: disabled, current: current, expected: 3},
+		{name: "autoscaled", replicas: int32Ptr(2), current: current, expected: 3},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m.Replicas, m.Autoscaling = tc.replicas, tc.autoscaling
+			function := m.function(defaultNamespace, source, "hello", tc.current)
+			if replicas := *function.Spec.Replicas; replicas != tc.expected {
+				t.Errorf("expected replicas %d, got %d", tc.expected, replicas)
+			}
+		})
+	}
+}
+
+func TestDiffFunctions(t *testing.T) {
+	current := &uselessv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "hello",
+			Annotations: map[string]string{signatureAnnotation: string(builder.StringFunc), "other": "kept"},
+		},
+		Spec: uselessv1.FunctionSpec{
+			FuncName:    "hello",
+			FuncContent: "package hello\n",
+			Image:       "useless/hello:v1",
+			Replicas:    int32Ptr(1),
+			Env:         []corev1.EnvVar{{Name: "A", Value: "a"}},
+		},
+	}
+	desired := current.DeepCopy()
+	desired.Annotations = map[string]string{signatureAnnotation: string(builder.TypedFunc)}
+	desired.Spec.FuncContent = strings.Repeat("// hello\n", 10)
+	desired.Spec.Image = "useless/hello:v2"
+	desired.Spec.Env = nil
+	desired.Spec.Triggers = []uselessv1.FunctionTrigger{{Name: "hourly", Schedule: "@hourly"}}
+
+	if diffs := diffFunctions(current, current); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %+v", diffs)
+	}
+
+	var out strings.Builder
+	printDiffs(&out, diffFunctions(current, desired))
+	expected := `~ metadata.annotations["alphabetical.useless/signature"]: "string" -> "typed JSON"
+- spec.env[0].name: "A"
+- spec.env[0].value: "a"
+~ spec.funcContent: (changed, 14 -> 90 bytes)
+~ spec.image: "useless/hello:v1" -> "useless/hello:v2"
++ spec.triggers[0].name: "hourly"
++ spec.triggers[0].schedule: "@hourly"
+`
+	if out.String() != expected {
+		t.Errorf("expected diff:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	printDiffs(&out, diffFunctions(nil, desired))
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) == 0 || !strings.HasPrefix(lines[0], "+ ") {
+		t.Errorf("expected the fields added, got:\n%s", out.String())
+	}
+}
+
+func TestApply(t *testing.T) {
+	kubeConfig := newKubeConfig(t)
+
+	testCases := []struct {
+		name     string
+		manifest string
+		command  string
+		expected string
+	}{
+		{
+			name:     "created",
+			manifest: "name: hello-404\nimage: useless/hello:v2\nsource: {path: hello.go, function: Hello}\n",
+			command:  "apply",
+			expected: "Function created: hello-404\n",
+		},
+		{
+			name:     "updated",
+			manifest: "name: hello\nimage: useless/hello:v2\nsource: {path: hello.go, function: Hello}\n",
+			command:  "apply",
+			expected: "Function updated: hello\n",
+		},
+		{
+			name:     "diff",
+			manifest: "name: hello\nimage: useless/hello:v2\nsource: {path: hello.go, function: Hello}\n",
+			command:  "diff",
+			expected: `~ spec.image: "useless/hello:latest" -> "useless/hello:v2"`,
+		},
+		{
+			name:     "diff of new",
+			manifest: "name: hello-404\nimage: useless/hello:v2\nsource: {path: hello.go, function: Hello}\n",
+			command:  "diff",
+			expected: "Function useless/hello-404 does not exist, it will be created.\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, out := runCLI(t, tc.command, "-kubeconfig", kubeConfig, "-f", writeManifest(t, tc.manifest))
+			if code != exitOK {
+				t.Fatalf("expected exit code %d, got %d", exitOK, code)
+			}
+			if !strings.Contains(out, tc.expected) {
+				t.Errorf("expected %q in:\n%s", tc.expected, out)
+			}
+		})
+	}
+}
@@ -1,54 +1,289 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/builder"
+	"github.com/damnever/useless/pkg/oci"
 )
 
+const defaultDockerRegistry = "registry.cn-hangzhou.aliyuncs.com/useless"
+
 const (
-	functpl = `package main
+	// dockerBuilder builds the images by the Docker daemon.
+	dockerBuilder = "docker"
+	// ociBuilder assembles the images in the OCI layout without the Docker
+	// daemon, and pushes them by the distribution API.
+	ociBuilder = "oci"
 
-{{ .FuncBody }}`
-	maintpl = `package main
+	// defaultOCILayout caches the base images and keeps the built images.
+	defaultOCILayout = "./bin/oci-layout"
+	// buildDir is where the build module of the function is generated.
+	buildDir = "./bin/func-main"
+)
 
-import (
-	"flag"
-	"fmt"
-	"os"
+// buildFlags are the flags shared by the commands which build the images.
+type buildFlags struct {
+	dockerReg string
+	builder   string
+	// output is the OCI layout directory, or the tarball if it ends with .tar.
+	output    string
+	plainHTTP bool
+	platforms string
 
-	uselessruntime "github.com/damnever/useless/runtime"
-)
+	// platformList is parsed from platforms by validate.
+	platformList []oci.Platform
+}
 
-func main() {
-	laddr := flag.String("laddr", ":8080", "the listen address")
-	flag.Parse()
+func (f *buildFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dockerReg, "docker-registry", defaultDockerRegistry, "docker registry")
+	fs.StringVar(&f.builder, "builder", dockerBuilder, "the image builder, one of: docker|oci")
+	fs.StringVar(&f.output, "output", defaultOCILayout, "the OCI layout directory, or the tarball if it ends with .tar, for -builder=oci only")
+	fs.BoolVar(&f.plainHTTP, "plain-http", false, "push to the registry by http, for -builder=oci only")
+	fs.StringVar(&f.platforms, "platforms", builder.GOOS+"/"+builder.DefaultGOARCH,
+		"the comma separated platforms of the image, e.g. linux/amd64,linux/arm64, more than one requires -builder=oci")
+}
 
-	useless := uselessruntime.NewSupervisor("{{ .FuncName }}", {{ .FuncName }})
-	defer useless.Close()
-	if err := useless.Run(*laddr); err != nil {
-		fmt.Fprintf(os.Stderr, "Launch function supervisor failed: %v", err)
-		os.Exit(1)
+func (f *buildFlags) validate() error {
+	switch f.builder {
+	case dockerBuilder, ociBuilder:
+	default:
+		return userErrorf("unknown builder %q, want one of: docker|oci", f.builder)
 	}
-}`
-)
+	platforms, err := builder.ParsePlatforms(f.platforms)
+	if err != nil {
+		return userErrorf("invalid -platforms: %v", err)
+	}
+	if len(platforms) > 1 && f.builder != ociBuilder {
+		return userErrorf("the docker builder builds one platform only, use -builder=oci for %s", f.platforms)
+	}
+	f.platformList = platforms
+	return nil
+}
 
-func build(content, name, dockerReg string) {
-	err := os.RemoveAll("./bin/func-main")
-	assert(err == nil || os.IsNotExist(err), "rm -rf bin/func-main: %v", err)
-	err = os.Mkdir("./bin/func-main", 0755)
-	assert(err == nil || os.IsExist(err), "mkdir bin/func-main: %v", err)
-	writeTemplate("./bin/func-main/main.go", maintpl, struct {
-		FuncName string
-	}{
-		FuncName: name,
-	})
-	writeTemplate("./bin/func-main/func.go", functpl, struct {
-		FuncBody string
-	}{
-		FuncBody: content,
-	})
-	execCmd("go build -o ./bin/function ./bin/func-main/", "GOOS=linux", "GOARCH=amd64", "GO111MODULE=on")
-	imageName := imageName(name, dockerReg)
-	execCmd(fmt.Sprintf("docker build -t %s -f ./docker/supervisor.Dockerfile --build-arg listen_addr=:80 .", imageName))
-	execCmd(fmt.Sprintf("docker push %s", imageName))
+// layoutDir returns the OCI layout directory, the tarball is written from
+// the default one.
+func (f *buildFlags) layoutDir() string {
+	if strings.HasSuffix(f.output, ".tar") {
+		return defaultOCILayout
+	}
+	return f.output
+}
+
+// builtImage returns the image pinned by its digest, the image must have
+// been built by the builder.
+func (f *buildFlags) builtImage(image string) (string, error) {
+	notBuilt := userErrorf("image %s of the function is not built, run build first or pass -build", image)
+	if f.builder == ociBuilder {
+		layout, err := oci.OpenLayout(f.layoutDir())
+		if err != nil {
+			return "", buildErrorf("open OCI layout: %v", err)
+		}
+		desc, err := layout.Resolve(image, nil)
+		if err == oci.ErrNotFound {
+			return "", notBuilt
+		}
+		if err != nil {
+			return "", buildErrorf("%v", err)
+		}
+		// The pushed manifest is the same as the one in the layout.
+		return image + "@" + string(desc.Digest), nil
+	}
+	if !localImageExists(image) {
+		return "", notBuilt
+	}
+	return pushedImage(image)
+}
+
+func runBuild(fs *flag.FlagSet, args []string) error {
+	var flags buildFlags
+	flags.register(fs)
+	push := fs.Bool("push", true, "push the image to the registry")
+	runtimeFlag := fs.String("runtime", string(uselessv1.NativeRuntime), "where the function runs, one of: native|wasm")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if err := flags.validate(); err != nil {
+		return err
+	}
+	runtime, err := parseRuntime(*runtimeFlag)
+	if err != nil {
+		return err
+	}
+	source, err := readFunc(args[0])
+	if err != nil {
+		return err
+	}
+	source.Runtime = runtime
+	image, err := build(source, flags, *push)
+	if err != nil {
+		return err
+	}
+	if *push {
+		fmt.Printf("Image pushed: %s\n", image)
+	} else {
+		fmt.Printf("Image built: %s\n", image)
+	}
+	return nil
+}
+
+// build builds and pushes the function image, the build is skipped if the
+// image of the same sources exists locally, it returns the image name with
+// the pushed digest.
+func build(source *builder.Source, flags buildFlags, push bool) (string, error) {
+	files, err := builder.ModuleFiles(source)
+	if err != nil {
+		return "", err
+	}
+	b, err := newBuilder()
+	if err != nil {
+		return "", err
+	}
+	tag, err := b.ImageTag(files, flags.platformList)
+	if err != nil {
+		return "", buildErrorf("%v", err)
+	}
+	image := builder.ImageRepo(source.Sig.Name, flags.dockerReg) + ":" + tag
+	if flags.builder == ociBuilder {
+		return buildOCI(b, source, files, image, flags, push)
+	}
+
+	platform := flags.platformList[0]
+	if localImageExists(image) {
+		fmt.Fprintf(os.Stderr, "Image exists, skip building: %s\n", image)
+	} else {
+		if err := b.Prepare(files); err != nil {
+			return "", buildErrorf("%v", err)
+		}
+		if err := b.Compile(source, platform, "./bin/function"); err != nil {
+			return "", buildErrorf("%v", err)
+		}
+		dockerfile, err := b.Dockerfile()
+		if err != nil {
+			return "", buildErrorf("%v", err)
+		}
+		args := []string{"build", "-t", image, "-f", dockerfile,
+			"--build-arg", "listen_addr=:80", "."}
+		if platform.String() != builder.GOOS+"/"+builder.DefaultGOARCH {
+			args = append([]string{"build", "--platform", platform.String()}, args[1:]...)
+		}
+		if err := execCmd("docker", args...); err != nil {
+			return "", buildErrorf("%v", err)
+		}
+	}
+	if !push {
+		return image, nil
+	}
+	if err := execCmd("docker", "push", image); err != nil {
+		return "", buildErrorf("%v", err)
+	}
+	return pushedImage(image)
+}
+
+// buildOCI assembles the image in the OCI layout, and then pushes it by the
+// distribution API.
+func buildOCI(b *builder.Builder, source *builder.Source, files map[string][]byte, image string,
+	flags buildFlags, push bool) (string, error) {
+
+	tarball := strings.HasSuffix(flags.output, ".tar")
+	layout, err := oci.OpenLayout(flags.layoutDir())
+	if err != nil {
+		return "", buildErrorf("open OCI layout: %v", err)
+	}
+	client := oci.NewClient()
+	client.PlainHTTP = flags.plainHTTP
+
+	desc, err := layout.Resolve(image, nil)
+	switch {
+	case err == nil:
+		fmt.Fprintf(os.Stderr, "Image exists, skip building: %s\n", image)
+	case err == oci.ErrNotFound:
+		if err := b.Prepare(files); err != nil {
+			return "", buildErrorf("%v", err)
+		}
+		if desc, err = b.BuildOCI(source, layout, client, flags.platformList, image); err != nil {
+			return "", buildErrorf("%v", err)
+		}
+	default:
+		return "", buildErrorf("%v", err)
+	}
+
+	if tarball {
+		if err := writeOCITar(layout, image, flags.output); err != nil {
+			return "", err
+		}
+	}
+	if !push {
+		return image, nil
+	}
+	ref, err := oci.ParseReference(image)
+	if err != nil {
+		return "", userErrorf("%v", err)
+	}
+	digest, err := client.Push(layout, desc, ref)
+	if err != nil {
+		return "", buildErrorf("push %s: %v", image, err)
+	}
+	return image + "@" + string(digest), nil
+}
+
+func writeOCITar(layout *oci.Layout, image, fpath string) error {
+	f, err := os.Create(fpath)
+	if err != nil {
+		return buildErrorf("%v", err)
+	}
+	if err := layout.WriteTar(f, image); err != nil {
+		f.Close()
+		return buildErrorf("write %s: %v", fpath, err)
+	}
+	if err := f.Close(); err != nil {
+		return buildErrorf("%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Image written: %s\n", fpath)
+	return nil
+}
+
+// newBuilder returns the builder of the functions, which require the version
+// of the runtime the cli is built from, e.g. by
+// go install github.com/damnever/useless/cmd/cli@v0.3.0. The runtime is
+// replaced by the repository only if the cli runs inside it, which is for
+// developing the runtime.
+func newBuilder() (*builder.Builder, error) {
+	b := &builder.Builder{BuildDir: buildDir, CreatedBy: "useless-cli build"}
+	if out, err := exec.Command("go", "env", "GOMOD").Output(); err == nil {
+		gomod := strings.TrimSpace(string(out))
+		if content, err := ioutil.ReadFile(gomod); err == nil && builder.ModulePath(content) == builder.UselessModule {
+			b.RepoDir = filepath.Dir(gomod)
+			return b, nil
+		}
+	}
+	version, err := runtimeVersion(debug.ReadBuildInfo())
+	if err != nil {
+		return nil, buildErrorf("%v", err)
+	}
+	b.RuntimeVersion = version
+	return b, nil
+}
+
+// runtimeVersion returns the version of the runtime module in the build
+// information of the cli.
+func runtimeVersion(info *debug.BuildInfo, ok bool) (string, error) {
+	if ok && info.Main.Path == builder.UselessModule {
+		// The pseudo versions of the local changes, e.g. +dirty, can not
+		// be required.
+		if version := info.Main.Version; version != "" && version != "(devel)" && !strings.Contains(version, "+") {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("the cli is not built from a released version of %s, "+
+		"install one by go install %s/cmd/cli@latest, or run it inside the repository", builder.UselessModule, builder.UselessModule)
 }
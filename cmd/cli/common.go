@@ -1,62 +1,149 @@
 package main
 
 import (
-	"bytes"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
-	"text/template"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/damnever/useless/pkg/builder"
+	"github.com/damnever/useless/pkg/generated/clientset/versioned"
 )
 
+const defaultNamespace = "useless"
+
+// The exit codes tell the scripts what went wrong.
 const (
-	dirtyImageVersion = "latest"
+	exitOK = iota
+	// exitInternal is for the unexpected errors.
+	exitInternal
+	// exitUser is for the bad usages, flags, arguments or function sources.
+	exitUser
+	// exitCluster is for the errors from the Kubernetes cluster.
+	exitCluster
+	// exitBuild is for the failures of building or pushing the images.
+	exitBuild
+	// exitFunction is for the errors returned by the invoked functions.
+	exitFunction
 )
 
-func imageName(funcName, dockerReg string) string {
-	return fmt.Sprintf("%s/%s:%s", dockerReg, strings.ToLower(funcName), dirtyImageVersion)
+// cliError carries the exit code up to main.
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string {
+	return e.err.Error()
 }
 
-func assert(ok bool, format string, a ...interface{}) {
-	if !ok {
-		fmt.Fprintf(os.Stderr, format, a...)
-		os.Exit(1)
+func (e *cliError) Unwrap() error {
+	return e.err
+}
+
+func userErrorf(format string, a ...interface{}) error {
+	return &cliError{code: exitUser, err: fmt.Errorf(format, a...)}
+}
+
+func clusterErrorf(format string, a ...interface{}) error {
+	return &cliError{code: exitCluster, err: fmt.Errorf(format, a...)}
+}
+
+func buildErrorf(format string, a ...interface{}) error {
+	return &cliError{code: exitBuild, err: fmt.Errorf(format, a...)}
+}
+
+func functionErrorf(format string, a ...interface{}) error {
+	return &cliError{code: exitFunction, err: fmt.Errorf(format, a...)}
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
 	}
+	var e *cliError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitInternal
+}
+
+// kubeFlags are the flags shared by the commands which talk to the cluster.
+type kubeFlags struct {
+	kubeConfig string
+	namespace  string
 }
 
-func readFunc(pathFunc string) (string, string) {
+func (f *kubeFlags) register(fs *flag.FlagSet) {
+	if home := homedir.HomeDir(); home != "" {
+		fs.StringVar(&f.kubeConfig, "kubeconfig", filepath.Join(home, ".kube", "config"),
+			"(optional) absolute path to the kubeconfig file")
+	} else {
+		fs.StringVar(&f.kubeConfig, "kubeconfig", "", "absolute path to the kubeconfig file")
+	}
+	fs.StringVar(&f.namespace, "namespace", defaultNamespace, "the namespace of the functions")
+}
+
+func (f *kubeFlags) clientsets() (kubernetes.Interface, versioned.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", f.kubeConfig)
+	if err != nil {
+		return nil, nil, userErrorf("build config failed: %v", err)
+	}
+	kubeclientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, userErrorf("create kubernetes clientset failed: %v", err)
+	}
+	funcclientset, err := versioned.NewForConfig(config)
+	if err != nil {
+		return nil, nil, userErrorf("create clientset failed: %v", err)
+	}
+	return kubeclientset, funcclientset, nil
+}
+
+func readFunc(pathFunc string) (*builder.Source, error) {
 	parts := strings.SplitN(pathFunc, "::", 2)
-	assert(len(parts) == 2, "format like this: <file-path>::<func-name>")
-	path, name := parts[0], parts[1]
-	content, err := ioutil.ReadFile(path)
-	assert(err == nil, "read function failed: %v", err)
-	newcontent := []byte{}
-	for _, line := range bytes.Split(content, []byte{'\n'}) {
-		if bytes.HasPrefix(bytes.TrimPrefix(line, []byte(" ")), []byte("package")) {
-			continue
-		}
-		newcontent = append(newcontent, line...)
-		newcontent = append(newcontent, '\n')
-	}
-	return string(newcontent), name
-}
-
-func writeTemplate(fpath, tplstr string, args interface{}) {
-	tpl := template.Must(template.New("TODO").Parse(tplstr))
-	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY, 0644)
-	assert(err == nil, "open file: %v", err)
-	defer f.Close()
-	err = tpl.Execute(f, args)
-	assert(err == nil, "generate code: %v", err)
-}
-
-func execCmd(command string, envs ...string) {
-	parts := strings.Split(command, " ")
-	cmd := exec.Command(parts[0], parts[1:]...)
+	if len(parts) != 2 {
+		return nil, userErrorf("format like this: <path>::<func-name>")
+	}
+	source, err := builder.LoadSource(parts[0], parts[1])
+	if err != nil {
+		return nil, userErrorf("invalid function: %v", err)
+	}
+	return source, nil
+}
+
+// execCmd runs the command, the arguments are passed as they are, so the
+// paths may contain spaces.
+func execCmd(name string, args ...string) error {
+	return execCmdIn("", nil, name, args...)
+}
+
+func execCmdIn(dir string, envs []string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
 	cmd.Env = append(os.Environ(), envs...)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = os.Stderr // Keep stdout for the results.
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	assert(err == nil, "%s: %v", command, err)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// execOutput runs the command and returns its stdout.
+func execOutput(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return out, nil
 }
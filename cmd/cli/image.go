@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/damnever/useless/pkg/builder"
+)
+
+// imageName returns the image of the function tagged by the hash of its
+// build, the image may not exist.
+func imageName(source *builder.Source, flags buildFlags) (string, error) {
+	files, err := builder.ModuleFiles(source)
+	if err != nil {
+		return "", err
+	}
+	b, err := newBuilder()
+	if err != nil {
+		return "", err
+	}
+	tag, err := b.ImageTag(files, flags.platformList)
+	if err != nil {
+		return "", buildErrorf("%v", err)
+	}
+	return builder.ImageRepo(source.Sig.Name, flags.dockerReg) + ":" + tag, nil
+}
+
+func localImageExists(image string) bool {
+	cmd := exec.Command("docker", "image", "inspect", image)
+	return cmd.Run() == nil
+}
+
+// pushedImage pins the pushed image by its digest, e.g.
+// registry/name:tag@sha256:..., the tag is kept for the humans.
+func pushedImage(image string) (string, error) {
+	out, err := execOutput("docker", "image", "inspect", "--format", "{{json .RepoDigests}}", image)
+	if err != nil {
+		return "", buildErrorf("%v", err)
+	}
+	var digests []string
+	if err := json.Unmarshal(out, &digests); err != nil {
+		return "", buildErrorf("parse the digests of %s: %v", image, err)
+	}
+	repo := image[:strings.LastIndex(image, ":")]
+	for _, digest := range digests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return image + digest[len(repo):], nil
+		}
+	}
+	return image, nil
+}
+
+// imageWithoutDigest strips the digest from the image name.
+func imageWithoutDigest(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i]
+	}
+	return image
+}
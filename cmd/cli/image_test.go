@@ -0,0 +1,226 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/builder"
+	"github.com/damnever/useless/pkg/oci"
+)
+
+func TestImageName(t *testing.T) {
+	dir := filepath.Dir(writeManifest(t, ""))
+	source, err := builder.LoadSource(filepath.Join(dir, "hello.go"), "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := testBuildFlags(t, "linux/amd64")
+	image, err := imageName(source, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(image, "registry/hello:") || len(image) == len("registry/hello:") {
+		t.Errorf("unexpected image %s", image)
+	}
+	if again, _ := imageName(source, flags); again != image {
+		t.Errorf("expected the same image %s, got %s", image, again)
+	}
+	multi, _ := imageName(source, testBuildFlags(t, "linux/arm64,linux/amd64"))
+	if multi == image {
+		t.Errorf("expected a new tag for the platforms, got %s", multi)
+	}
+	if reordered, _ := imageName(source, testBuildFlags(t, "linux/amd64,linux/arm64")); reordered != multi {
+		t.Errorf("expected the same image %s regardless of the order of platforms, got %s", multi, reordered)
+	}
+
+	source.Files["hello.go"] = append(source.Files["hello.go"], "\n// changed\n"...)
+	if changed, _ := imageName(source, flags); changed == image {
+		t.Errorf("expected a new tag once the sources changed, got %s", changed)
+	}
+}
+
+func testBuildFlags(t *testing.T, platforms string) buildFlags {
+	flags := buildFlags{dockerReg: "registry", builder: ociBuilder, platforms: platforms}
+	if err := flags.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestValidateBuildFlags(t *testing.T) {
+	flags := buildFlags{builder: dockerBuilder, platforms: "linux/amd64,linux/arm64"}
+	if code := exitCode(flags.validate()); code != exitUser {
+		t.Errorf("expected exit code %d for the docker builder of platforms, got %d", exitUser, code)
+	}
+	flags.builder = ociBuilder
+	if err := flags.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageWithoutDigest(t *testing.T) {
+	testCases := []struct {
+		image    string
+		expected string
+	}{
+		{image: "registry/hello:0123", expected: "registry/hello:0123"},
+		{image: "registry/hello:0123@sha256:abcd", expected: "registry/hello:0123"},
+		{image: "localhost:5000/hello:0123@sha256:abcd", expected: "localhost:5000/hello:0123"},
+	}
+	for _, tc := range testCases {
+		if actual := imageWithoutDigest(tc.image); actual != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.image, tc.expected, actual)
+		}
+	}
+}
+
+func TestDeployRequiresBuiltImage(t *testing.T) {
+	// The image of the sources can not exist locally, it is never built.
+	dir := filepath.Dir(writeManifest(t, ""))
+	code, _ := runCLI(t, "deploy", "-kubeconfig", newKubeConfig(t), filepath.Join(dir, "hello.go")+"::Hello")
+	if code != exitUser {
+		t.Errorf("expected exit code %d, got %d", exitUser, code)
+	}
+}
+
+func TestDeployInClusterExclusive(t *testing.T) {
+	dir := filepath.Dir(writeManifest(t, ""))
+	code, _ := runCLI(t, "deploy", "-kubeconfig", newKubeConfig(t), "-build", "-in-cluster",
+		filepath.Join(dir, "hello.go")+"::Hello")
+	if code != exitUser {
+		t.Errorf("expected exit code %d, got %d", exitUser, code)
+	}
+}
+
+func TestDeployInterpretedExclusive(t *testing.T) {
+	dir := filepath.Dir(writeManifest(t, ""))
+	for _, flag := range []string{"-build", "-in-cluster", "-runtime=wasm"} {
+		code, _ := runCLI(t, "deploy", "-kubeconfig", newKubeConfig(t), "-interpreted", flag,
+			filepath.Join(dir, "hello.go")+"::Hello")
+		if code != exitUser {
+			t.Errorf("%s: expected exit code %d, got %d", flag, exitUser, code)
+		}
+	}
+}
+
+func TestParseRuntime(t *testing.T) {
+	testCases := []struct {
+		runtime     string
+		expected    uselessv1.FunctionRuntime
+		expectError bool
+	}{
+		{runtime: "", expected: ""},
+		{runtime: "native", expected: ""},
+		{runtime: "wasm", expected: uselessv1.WasmRuntime},
+		{runtime: "jvm", expectError: true},
+	}
+	for _, tc := range testCases {
+		runtime, err := parseRuntime(tc.runtime)
+		if tc.expectError {
+			if code := exitCode(err); code != exitUser {
+				t.Errorf("%q: expected exit code %d, got %d: %v", tc.runtime, exitUser, code, err)
+			}
+			continue
+		}
+		if err != nil || runtime != tc.expected {
+			t.Errorf("%q: expected %q, got %q, %v", tc.runtime, tc.expected, runtime, err)
+		}
+	}
+}
+
+func TestBuiltImage(t *testing.T) {
+	layoutDir := t.TempDir()
+	layout, err := oci.OpenLayout(layoutDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := layout.WriteBlob(oci.MediaTypeImageManifest, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.Tag(desc, "registry/hello:built"); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		flags    buildFlags
+		image    string
+		expected string
+	}{
+		{name: "docker", flags: buildFlags{builder: dockerBuilder}, image: "registry/hello:nope"},
+		{name: "oci", flags: buildFlags{builder: ociBuilder, output: layoutDir}, image: "registry/hello:nope"},
+		{
+			name:     "oci built",
+			flags:    buildFlags{builder: ociBuilder, output: layoutDir},
+			image:    "registry/hello:built",
+			expected: "registry/hello:built@" + string(desc.Digest),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			image, err := tc.flags.builtImage(tc.image)
+			if tc.expected == "" {
+				if code := exitCode(err); code != exitUser {
+					t.Errorf("expected exit code %d, got %d: %v", exitUser, code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, image)
+			}
+		})
+	}
+}
+
+func TestRuntimeVersion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		version  string
+		ok       bool
+		expected string
+	}{
+		{name: "released", path: builder.UselessModule, version: "v0.3.0", ok: true, expected: "v0.3.0"},
+		{
+			name: "pseudo version", path: builder.UselessModule, ok: true,
+			version: "v0.0.0-20261018000000-0123456789ab", expected: "v0.0.0-20261018000000-0123456789ab",
+		},
+		{name: "local changes", path: builder.UselessModule, version: "v0.3.1-0.20261018000000-0123456789ab+dirty", ok: true},
+		{name: "devel", path: builder.UselessModule, version: "(devel)", ok: true},
+		{name: "other module", path: "example.com/m", version: "v1.0.0", ok: true},
+		{name: "no build info"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := &debug.BuildInfo{Main: debug.Module{Path: tc.path, Version: tc.version}}
+			version, err := runtimeVersion(info, tc.ok)
+			if tc.expected == "" {
+				if err == nil {
+					t.Errorf("expected error, got %s", version)
+				}
+				return
+			}
+			if err != nil || version != tc.expected {
+				t.Errorf("expected %s, got %s, %v", tc.expected, version, err)
+			}
+		})
+	}
+}
+
+func TestNewBuilderInRepo(t *testing.T) {
+	// The tests run inside the repository.
+	b, err := newBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.RepoDir == "" || b.RuntimeVersion != "" {
+		t.Errorf("expected the runtime of the repository, got %+v", b)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/builder"
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+// signatureAnnotation records the kind of the function signature, so the
+// input can be sent in the right shape.
+const signatureAnnotation = "alphabetical.useless/signature"
+
+func runInvoke(fs *flag.FlagSet, args []string) error {
+	var flags kubeFlags
+	flags.register(fs)
+	file := fs.String("f", "", "read the input from the file, - for stdin")
+	raw := fs.Bool("raw", false, "print the response body as it is")
+	timeout := fs.Duration("timeout", time.Minute, "the timeout of the invocation")
+	args, err := parseArgs(fs, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	input, err := readInput(args[1:], *file)
+	if err != nil {
+		return err
+	}
+	kubeclientset, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+	function, err := funcclientset.UselessV1().Functions(flags.namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+	if err != nil {
+		return apiErrorf(err, "get function failed")
+	}
+
+	kind := builder.Kind(function.Annotations[signatureAnnotation])
+	contentType := "application/json"
+	switch kind {
+	case builder.StringFunc:
+		if input, err = json.Marshal(map[string]string{"input": string(input)}); err != nil {
+			return err
+		}
+	case builder.StreamFunc:
+		contentType = "application/octet-stream"
+	}
+
+	// Reach the Service through the API server proxy, the Service is named
+	// after the function. The port-forward is not used, it requires the
+	// SPDY streams which are not available to the cli.
+	start := time.Now()
+	statusCode := 0
+	body, err := kubeclientset.CoreV1().RESTClient().Post().
+		Namespace(function.Namespace).
+		Resource("services").
+		Name("http:"+function.Spec.FuncName+":"+strconv.Itoa(uselessv1.FunctionPort)).
+		SubResource("proxy").
+		Timeout(*timeout).
+		SetHeader("Content-Type", contentType).
+		Body(input).
+		Do(context.TODO()).
+		StatusCode(&statusCode).
+		Raw()
+	elapsed := time.Since(start)
+	if err != nil && len(body) == 0 {
+		return clusterErrorf("invoke function failed: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Status: %d %s, took %s\n", statusCode, httpStatusText(statusCode),
+		elapsed.Round(time.Millisecond))
+
+	if *raw || kind == builder.StreamFunc {
+		os.Stdout.Write(body)
+		if len(body) > 0 && body[len(body)-1] != '\n' {
+			fmt.Println()
+		}
+		if err != nil {
+			return functionErrorf("function failed with status %d", statusCode)
+		}
+		return nil
+	}
+	return printInvocation(body, kind, err)
+}
+
+// readInput reads the input from the argument, the file or stdin.
+func readInput(args []string, file string) ([]byte, error) {
+	switch {
+	case len(args) > 0 && file != "":
+		return nil, userErrorf("the input and -f are mutually exclusive")
+	case len(args) > 0:
+		return []byte(args[0]), nil
+	case file == "-":
+		return readAll(os.Stdin, "stdin")
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, userErrorf("open input: %v", err)
+		}
+		defer f.Close()
+		return readAll(f, file)
+	default:
+		return nil, nil
+	}
+}
+
+func readAll(r io.Reader, name string) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, userErrorf("read input from %s: %v", name, err)
+	}
+	return data, nil
+}
+
+// printInvocation prints the output of the function to stdout, or the
+// structured error to stderr. The output of a string function is wrapped
+// as {"output": output}, the one of a typed function is the body as is.
+func printInvocation(body []byte, kind builder.Kind, callErr error) error {
+	if callErr != nil {
+		var resp struct {
+			Error *uselessruntime.Error `json:"error"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil {
+			// Not from the supervisor, e.g. the API server or the activator.
+			return clusterErrorf("invoke function failed: %v", callErr)
+		}
+		e := resp.Error
+		fmt.Fprintf(os.Stderr, "Code: %s\nMessage: %s\nRetryable: %t\n", e.Code, e.Message, e.Retryable)
+		if len(e.Details) > 0 {
+			details, _ := json.MarshalIndent(e.Details, "", "  ")
+			fmt.Fprintf(os.Stderr, "Details: %s\n", details)
+		}
+		return functionErrorf("function failed: %s", e.Code)
+	}
+
+	if kind == builder.StringFunc {
+		var resp struct {
+			Output *string `json:"output"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil || resp.Output == nil {
+			return functionErrorf("unexpected response: %s", bytes.TrimSpace(body))
+		}
+		fmt.Println(*resp.Output)
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		buf.Reset()
+		buf.Write(bytes.TrimSpace(body))
+	}
+	fmt.Println(buf.String())
+	return nil
+}
+
+func httpStatusText(code int) string {
+	if text := http.StatusText(code); text != "" {
+		return text
+	}
+	return "Unknown"
+}
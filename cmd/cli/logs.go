@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// restreamInterval is how long to wait before streaming the logs of a pod
+// again once the stream ends while the pod is still running.
+const restreamInterval = time.Second
+
+func runLogs(fs *flag.FlagSet, args []string) error {
+	var flags kubeFlags
+	flags.register(fs)
+	follow := fs.Bool("follow", false, "stream the logs, including the pods started later")
+	since := fs.Duration("since", 0, "only the logs newer than the duration, e.g. 5m, all the logs if 0")
+	tail := fs.Int64("tail", -1, "the number of the recent lines of each pod, all the lines if negative")
+	timestamps := fs.Bool("timestamps", false, "print the timestamps")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	kubeclientset, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+	function, err := funcclientset.UselessV1().Functions(flags.namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+	if err != nil {
+		return apiErrorf(err, "get function failed")
+	}
+
+	s := &logStreamer{
+		kubeclientset: kubeclientset,
+		container:     function.Spec.FuncName,
+		since:         *since,
+		tail:          *tail,
+		timestamps:    *timestamps,
+		out:           os.Stdout,
+		started:       time.Now(),
+		streaming:     map[string]bool{},
+		cursors:       map[string]logCursor{},
+	}
+	selector := labels.SelectorFromSet(function.PodLabels())
+	if !*follow {
+		pods, err := kubeclientset.CoreV1().Pods(function.Namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+		if err != nil {
+			return apiErrorf(err, "list pods failed")
+		}
+		return s.dump(pods.Items)
+	}
+
+	stopCh := make(chan struct{})
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		close(stopCh)
+	}()
+	return s.follow(function.Namespace, selector, stopCh)
+}
+
+// logLine is a line of the logs with the timestamp from the kubelet.
+type logLine struct {
+	pod  string
+	time time.Time
+	text string
+}
+
+// logStreamer prints the logs of the function pods, every line is prefixed
+// with the pod name, the lines from the pods are interleaved.
+type logStreamer struct {
+	kubeclientset kubernetes.Interface
+	container     string
+	since         time.Duration
+	tail          int64
+	timestamps    bool
+	out           io.Writer
+	// started is used to tell the new pods, whose logs are printed from the
+	// beginning regardless of since and tail.
+	started time.Time
+
+	mu        sync.Mutex
+	streaming map[string]bool
+	cursors   map[string]logCursor
+}
+
+// logCursor is where the printed logs of a pod end: the time of the last
+// line, and how many of the printed lines have that time.
+type logCursor struct {
+	time  time.Time
+	count int
+}
+
+func (s *logStreamer) options(pod *corev1.Pod, follow bool) *corev1.PodLogOptions {
+	opts := &corev1.PodLogOptions{Container: s.container, Follow: follow, Timestamps: true}
+	s.mu.Lock()
+	cursor, seen := s.cursors[pod.Name]
+	s.mu.Unlock()
+	switch {
+	case seen:
+		opts.SinceTime = &metav1.Time{Time: cursor.time}
+	case pod.CreationTimestamp.Time.After(s.started):
+		// A new pod, all of its logs are wanted.
+	default:
+		if s.since > 0 {
+			seconds := int64(s.since.Seconds())
+			opts.SinceSeconds = &seconds
+		}
+		if s.tail >= 0 {
+			opts.TailLines = &s.tail
+		}
+	}
+	return opts
+}
+
+// dump prints the current logs of the pods ordered by the timestamps.
+func (s *logStreamer) dump(pods []corev1.Pod) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		lines []logLine
+		errs  []string
+	)
+	for i := range pods {
+		pod := &pods[i]
+		if !hasContainerStarted(pod, s.container) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var podLines []logLine
+			err := s.read(pod, false, func(line logLine) {
+				podLines = append(podLines, line)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, podLines...)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("pod %s: %v", pod.Name, err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].time.Before(lines[j].time)
+	})
+	for _, line := range lines {
+		s.print(line)
+	}
+	if len(errs) > 0 {
+		return clusterErrorf("get logs failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// follow streams the logs of the running pods and the pods started later,
+// until stopCh is closed.
+func (s *logStreamer) follow(namespace string, selector labels.Selector, stopCh <-chan struct{}) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.kubeclientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector.String()
+		}))
+	podInformer := factory.Core().V1().Pods()
+	podsLister := podInformer.Lister()
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.ensureStream(obj.(*corev1.Pod), podsLister, stopCh)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			s.ensureStream(obj.(*corev1.Pod), podsLister, stopCh)
+		},
+	})
+	factory.Start(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, podInformer.Informer().HasSynced); !ok {
+		return clusterErrorf("failed to wait for caches to sync")
+	}
+	<-stopCh
+	return nil
+}
+
+// ensureStream starts streaming the logs of the pod if it is not yet.
+func (s *logStreamer) ensureStream(pod *corev1.Pod, podsLister corelistersv1.PodLister, stopCh <-chan struct{}) {
+	if !hasContainerStarted(pod, s.container) {
+		return
+	}
+	s.mu.Lock()
+	if s.streaming[pod.Name] {
+		s.mu.Unlock()
+		return
+	}
+	s.streaming[pod.Name] = true
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			err := s.read(pod, true, s.print)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Streaming the logs of pod %s: %v\n", pod.Name, err)
+			}
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(restreamInterval):
+			}
+			// The stream may end because of the timeouts, continue if the
+			// pod is still there, or the restarted container will trigger
+			// the stream again.
+			current, err := podsLister.Pods(pod.Namespace).Get(pod.Name)
+			if err != nil || current.UID != pod.UID || !hasContainerRunning(current, s.container) {
+				s.mu.Lock()
+				s.streaming[pod.Name] = false
+				s.mu.Unlock()
+				return
+			}
+			pod = current
+		}
+	}()
+}
+
+// read streams the logs of the pod from the cursor to fn.
+func (s *logStreamer) read(pod *corev1.Pod, follow bool, fn func(logLine)) error {
+	stream, err := s.kubeclientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, s.options(pod, follow)).Stream(context.TODO())
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return s.scan(pod.Name, stream, fn)
+}
+
+// scan reads the logs of the pod line by line, the lines printed before are
+// skipped since SinceTime is only accurate to the second: the earlier lines
+// and as many lines at the time of the cursor as have been printed. The
+// lines without timestamps are never skipped.
+func (s *logStreamer) scan(pod string, r io.Reader, fn func(logLine)) error {
+	s.mu.Lock()
+	start, seen := s.cursors[pod]
+	s.mu.Unlock()
+	replayed := 0 // The lines at the time of the start skipped so far.
+	reader := bufio.NewReader(r)
+	for {
+		text, err := reader.ReadString('\n')
+		if text != "" {
+			line := parseLogLine(pod, strings.TrimSuffix(text, "\n"))
+			fresh := true
+			if !line.time.IsZero() {
+				if seen && line.time.Before(start.time) {
+					fresh = false
+				} else if seen && line.time.Equal(start.time) && replayed < start.count {
+					replayed++
+					fresh = false
+				}
+				if fresh {
+					s.advance(pod, line.time)
+				}
+			}
+			if fresh {
+				fn(line)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// advance moves the cursor of the pod to the printed line at t.
+func (s *logStreamer) advance(pod string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cursor := s.cursors[pod]
+	if cursor.time.Equal(t) {
+		cursor.count++
+	} else {
+		cursor = logCursor{time: t, count: 1}
+	}
+	s.cursors[pod] = cursor
+}
+
+func (s *logStreamer) print(line logLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timestamps && !line.time.IsZero() {
+		fmt.Fprintf(s.out, "[%s] %s %s\n", line.pod, line.time.Format(time.RFC3339Nano), line.text)
+		return
+	}
+	fmt.Fprintf(s.out, "[%s] %s\n", line.pod, line.text)
+}
+
+// parseLogLine splits the timestamp added by the kubelet from the line.
+func parseLogLine(pod, text string) logLine {
+	line := logLine{pod: pod, text: text}
+	parts := strings.SplitN(text, " ", 2)
+	if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+		line.time = t
+		line.text = ""
+		if len(parts) == 2 {
+			line.text = parts[1]
+		}
+	}
+	return line
+}
+
+func hasContainerStarted(pod *corev1.Pod, container string) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			return status.State.Running != nil || status.State.Terminated != nil ||
+				status.LastTerminationState.Terminated != nil
+		}
+	}
+	return false
+}
+
+func hasContainerRunning(pod *corev1.Pod, container string) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			return status.State.Running != nil
+		}
+	}
+	return false
+}
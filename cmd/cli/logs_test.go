@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogCursor(t *testing.T) {
+	// The streams of the same pod, every one of them starts from the second
+	// of the cursor as SinceTime does.
+	streams := []struct {
+		name     string
+		lines    []string
+		expected []string
+	}{
+		{
+			name: "first",
+			lines: []string{
+				"2021-01-02T03:04:05.100000000Z a",
+				"2021-01-02T03:04:06.100000000Z b",
+				"2021-01-02T03:04:06.100000000Z c",
+			},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name: "restreamed",
+			lines: []string{
+				"2021-01-02T03:04:06.000000000Z before",
+				"2021-01-02T03:04:06.100000000Z b",
+				"2021-01-02T03:04:06.100000000Z c",
+				"2021-01-02T03:04:06.100000000Z d",
+				"2021-01-02T03:04:07.000000000Z e",
+			},
+			expected: []string{"d", "e"},
+		},
+		{
+			name: "without timestamps",
+			lines: []string{
+				"2021-01-02T03:04:07.000000000Z e",
+				"unable to retrieve container logs",
+				"2021-01-02T03:04:08.000000000Z f",
+			},
+			expected: []string{"unable to retrieve container logs", "f"},
+		},
+		{
+			name:  "nothing new",
+			lines: []string{"2021-01-02T03:04:08.000000000Z f"},
+		},
+	}
+
+	s := &logStreamer{cursors: map[string]logCursor{}}
+	for _, stream := range streams {
+		t.Run(stream.name, func(t *testing.T) {
+			var printed []string
+			err := s.scan("pod", strings.NewReader(strings.Join(stream.lines, "\n")), func(line logLine) {
+				printed = append(printed, line.text)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(printed, "\n") != strings.Join(stream.expected, "\n") {
+				t.Errorf("expected %q, got %q", stream.expected, printed)
+			}
+		})
+	}
+}
+
+func TestLogPrefix(t *testing.T) {
+	var out bytes.Buffer
+	s := &logStreamer{out: &out}
+	s.print(parseLogLine("test-abcde", "2021-01-02T03:04:05.1Z hello world"))
+	s.timestamps = true
+	s.print(parseLogLine("test-abcde", "2021-01-02T03:04:05.1Z hello world"))
+	s.print(parseLogLine("test-fghij", "no timestamp"))
+
+	expected := "[test-abcde] hello world\n" +
+		"[test-abcde] 2021-01-02T03:04:05.1Z hello world\n" +
+		"[test-fghij] no timestamp\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
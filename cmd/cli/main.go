@@ -2,41 +2,144 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
-
-	"k8s.io/client-go/util/homedir"
+	"sort"
 )
 
+// command is a subcommand of the cli, run parses the flags by itself.
+type command struct {
+	usage string
+	short string
+	run   func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"build": {
+		usage: "build [flags] <path>::<func-name>",
+		short: "Build and push the function image, the path is a Go file or a directory",
+		run:   runBuild,
+	},
+	"deploy": {
+		usage: "deploy [flags] <path>::<func-name>",
+		short: "Create the function",
+		run:   runDeploy,
+	},
+	"update": {
+		usage: "update [flags] <path>::<func-name>",
+		short: "Update the source and the image of the function",
+		run:   runUpdate,
+	},
+	"apply": {
+		usage: "apply [flags]",
+		short: "Create or update the function declared by the manifest, the source is built if it has changed",
+		run:   runApply,
+	},
+	"diff": {
+		usage: "diff [flags]",
+		short: "Show the differences between the manifest and the function in the cluster",
+		run:   runDiff,
+	},
+	"delete": {
+		usage: "delete [flags] <name>",
+		short: "Delete the function",
+		run:   runDelete,
+	},
+	"get": {
+		usage: "get [flags] <name>",
+		short: "Show the function",
+		run:   runGet,
+	},
+	"list": {
+		usage: "list [flags]",
+		short: "List the functions",
+		run:   runList,
+	},
+	"describe": {
+		usage: "describe [flags] <name>",
+		short: "Show the details of the function, including the conditions and the events",
+		run:   runDescribe,
+	},
+	"invoke": {
+		usage: "invoke [flags] <name> [input]",
+		short: "Invoke the function with the input from the argument, -f file or stdin",
+		run:   runInvoke,
+	},
+	"logs": {
+		usage: "logs [flags] <name>",
+		short: "Print the logs of all the function pods, -follow to stream them",
+		run:   runLogs,
+	},
+}
+
+var progName = filepath.Base(os.Args[0])
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", progName)
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of the command.\n", progName)
+}
+
 func main() {
-	var (
-		flagBuild      string
-		flagCreate     string
-		flagDelete     string
-		flagDockerReg  string
-		flagKubeConfig string
-	)
-	flag.StringVar(&flagBuild, "build", "", "build function image by <file-path>::<func-name>")
-	flag.StringVar(&flagCreate, "create", "", "create and deploy function by <file-path>::<func-name>")
-	flag.StringVar(&flagDelete, "delete", "", "delete function by meta name")
-	flag.StringVar(&flagDockerReg, "docker-registry", "registry.cn-hangzhou.aliyuncs.com/useless", "docker registry")
-	if home := homedir.HomeDir(); home != "" {
-		flag.StringVar(&flagKubeConfig, "kubeconfig", filepath.Join(home, ".kube", "config"),
-			"(optional) absolute path to the kubeconfig file")
-	} else {
-		flag.StringVar(&flagKubeConfig, "kubeconfig", "", "absolute path to the kubeconfig file")
-	}
-	flag.Parse()
-
-	switch {
-	case flagBuild != "":
-		content, name := readFunc(flagBuild)
-		build(content, name, flagDockerReg)
-	case flagCreate != "":
-		content, name := readFunc(flagCreate)
-		createFunction(content, name, flagDockerReg, flagKubeConfig)
-	case flagDelete != "":
-		deleteFunction(flagDelete, flagKubeConfig)
-	default:
-		assert(false, "None of -build/-create/-delete supplied.")
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command in args and returns the exit code.
+func run(args []string) int {
+	if len(args) < 1 {
+		usage()
+		return exitUser
+	}
+	name := args[0]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		return exitOK
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+		usage()
+		return exitUser
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Usage = func() {
+		fs.SetOutput(os.Stderr)
+		fmt.Fprintf(os.Stderr, "Usage: %s %s\n\n%s.\n\nFlags:\n", progName, cmd.usage, cmd.short)
+		fs.PrintDefaults()
+	}
+	err := cmd.run(fs, args[1:])
+	if err == flag.ErrHelp { // The usage has been printed by the FlagSet.
+		return exitOK
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// parseArgs parses the flags and checks the number of the positional
+// arguments is in [min, max].
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		return nil, userErrorf("%v", err)
+	}
+	if n := fs.NArg(); n < min || n > max {
+		return nil, userErrorf("%s: unexpected arguments %q, see '%s %s -h'", fs.Name(), fs.Args(), progName, fs.Name())
 	}
+	return fs.Args(), nil
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+func newFunction(name string) *uselessv1.Function {
+	return &uselessv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         defaultNamespace,
+			Name:              name,
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec: uselessv1.FunctionSpec{
+			FuncName: name,
+			Image:    "useless/" + name + ":latest",
+		},
+	}
+}
+
+// newKubeConfig starts a fake API server which serves the function hello,
+// its events and its Service, hello-404 is not found and hello-500 fails,
+// the functions are created and updated as they are. It returns the path
+// of the kubeconfig pointing to the server.
+func newKubeConfig(t *testing.T) string {
+	prefix := fmt.Sprintf("/apis/%s/namespaces/%s/functions", uselessv1.SchemeGroupVersion, defaultNamespace)
+	resource := schema.GroupResource{Group: uselessv1.SchemeGroupVersion.Group, Resource: "functions"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix) && (r.Method == http.MethodPost || r.Method == http.MethodPut) {
+			// Created or updated as it is.
+			w.Header().Set("Content-Type", "application/json")
+			data, _ := ioutil.ReadAll(r.Body)
+			w.Write(data)
+			return
+		}
+		var obj interface{}
+		status := http.StatusOK
+		switch r.URL.Path {
+		case prefix:
+			obj = &uselessv1.FunctionList{Items: []uselessv1.Function{*newFunction("hello")}}
+		case prefix + "/hello":
+			obj = newFunction("hello")
+		case "/api/v1/namespaces/" + defaultNamespace + "/events":
+			obj = &corev1.EventList{Items: []corev1.Event{{Type: corev1.EventTypeNormal, Reason: "Deployed", Message: "deployed\n"}}}
+		case fmt.Sprintf("/api/v1/namespaces/%s/services/http:hello:%d/proxy", defaultNamespace, uselessv1.FunctionPort):
+			// Echo the input like a typed function, or fail.
+			input, _ := ioutil.ReadAll(r.Body)
+			if string(input) != "fail" {
+				w.Write(input)
+				return
+			}
+			status, obj = http.StatusBadRequest, map[string]interface{}{
+				"error": uselessruntime.Errorf(uselessruntime.CodeInvalidInput, "fail"),
+			}
+		case prefix + "/hello-404":
+			status, obj = http.StatusNotFound, &apierrors.NewNotFound(resource, "hello-404").ErrStatus
+		default:
+			status, obj = http.StatusInternalServerError, &apierrors.NewInternalError(fmt.Errorf("oops")).ErrStatus
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(obj)
+	}))
+	t.Cleanup(server.Close)
+
+	kubeConfig := filepath.Join(t.TempDir(), "config")
+	data := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`, server.URL)
+	if err := ioutil.WriteFile(kubeConfig, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return kubeConfig
+}
+
+// runCLI runs the cli with args and returns the exit code and the stdout.
+func runCLI(t *testing.T, args ...string) (int, string) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, devNull
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- string(data)
+	}()
+	code := run(args)
+	w.Close()
+	return code, <-out
+}
+
+func TestExitCodes(t *testing.T) {
+	kubeConfig := newKubeConfig(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	testCases := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{name: "ok", args: []string{"get", "-kubeconfig", kubeConfig, "hello"}, expected: exitOK},
+		{name: "help", args: []string{"get", "-h"}, expected: exitOK},
+		{name: "no command", args: []string{}, expected: exitUser},
+		{name: "unknown command", args: []string{"nope"}, expected: exitUser},
+		{name: "unknown flag", args: []string{"get", "-nope", "hello"}, expected: exitUser},
+		{name: "missing argument", args: []string{"get", "-kubeconfig", kubeConfig}, expected: exitUser},
+		{name: "extra argument", args: []string{"delete", "-kubeconfig", kubeConfig, "a", "b"}, expected: exitUser},
+		{name: "unknown output", args: []string{"list", "-kubeconfig", kubeConfig, "-o", "xml"}, expected: exitUser},
+		{name: "bad function", args: []string{"build", "hello.go"}, expected: exitUser},
+		{name: "no kubeconfig", args: []string{"get", "-kubeconfig", missing, "hello"}, expected: exitUser},
+		{name: "not found", args: []string{"get", "-kubeconfig", kubeConfig, "hello-404"}, expected: exitUser},
+		{name: "cluster error", args: []string{"get", "-kubeconfig", kubeConfig, "hello-500"}, expected: exitCluster},
+		{name: "invoke", args: []string{"invoke", "-kubeconfig", kubeConfig, "hello", "{}"}, expected: exitOK},
+		{name: "invoke failed", args: []string{"invoke", "-kubeconfig", kubeConfig, "hello", "fail"}, expected: exitFunction},
+		{name: "invoke not found", args: []string{"invoke", "-kubeconfig", kubeConfig, "hello-404"}, expected: exitUser},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, _ := runCLI(t, tc.args...); code != tc.expected {
+				t.Errorf("expected exit code %d, got %d", tc.expected, code)
+			}
+		})
+	}
+}
+
+func TestExitCodeWrapped(t *testing.T) {
+	err := fmt.Errorf("deploying hello: %w", buildErrorf("no builder"))
+	if code := exitCode(err); code != exitBuild {
+		t.Errorf("expected exit code %d, got %d", exitBuild, code)
+	}
+	if code := exitCode(fmt.Errorf("oops")); code != exitInternal {
+		t.Errorf("expected exit code %d, got %d", exitInternal, code)
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	kubeConfig := newKubeConfig(t)
+
+	testCases := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, out string)
+	}{
+		{
+			name: "get table",
+			args: []string{"get", "-kubeconfig", kubeConfig, "hello"},
+			check: func(t *testing.T, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				if len(lines) != 2 || !strings.HasPrefix(lines[0], "NAME") || !strings.HasPrefix(lines[1], "hello ") {
+					t.Errorf("unexpected table:\n%s", out)
+				}
+			},
+		},
+		{
+			name: "get json",
+			args: []string{"get", "-kubeconfig", kubeConfig, "-o", "json", "hello"},
+			check: func(t *testing.T, out string) {
+				function := &uselessv1.Function{}
+				if err := json.Unmarshal([]byte(out), function); err != nil {
+					t.Fatal(err)
+				}
+				if function.Kind != "Function" || function.Name != "hello" {
+					t.Errorf("unexpected function: %+v", function)
+				}
+			},
+		},
+		{
+			name: "describe table",
+			args: []string{"describe", "-kubeconfig", kubeConfig, "hello"},
+			check: func(t *testing.T, out string) {
+				for _, expected := range []string{"Name:", "Conditions:", "Events:", "Normal  Deployed"} {
+					if !strings.Contains(out, expected) {
+						t.Errorf("expected %q in:\n%s", expected, out)
+					}
+				}
+			},
+		},
+		{
+			name: "invoke",
+			args: []string{"invoke", "-kubeconfig", kubeConfig, "hello", `{"a":1}`},
+			check: func(t *testing.T, out string) {
+				if expected := "{\n  \"a\": 1\n}\n"; out != expected {
+					t.Errorf("expected %q, got %q", expected, out)
+				}
+			},
+		},
+		{
+			name: "list yaml",
+			args: []string{"list", "-kubeconfig", kubeConfig, "-o", "yaml"},
+			check: func(t *testing.T, out string) {
+				list := &uselessv1.FunctionList{}
+				if err := yaml.Unmarshal([]byte(out), list); err != nil {
+					t.Fatal(err)
+				}
+				if list.Kind != "FunctionList" || len(list.Items) != 1 || list.Items[0].Kind != "Function" {
+					t.Errorf("unexpected list: %+v", list)
+				}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, out := runCLI(t, tc.args...)
+			if code != exitOK {
+				t.Fatalf("expected exit code %d, got %d", exitOK, code)
+			}
+			tc.check(t, out)
+		})
+	}
+}
@@ -1,53 +1,305 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
 	"strings"
 
-	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
-	"github.com/damnever/useless/pkg/generated/clientset/versioned"
-
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/tools/clientcmd"
-)
+	"k8s.io/apimachinery/pkg/fields"
 
-const (
-	defaultNamespace = "useless"
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/builder"
 )
 
-func createFunction(content, name, dockerReg, kubeConfig string) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
-	assert(err == nil, "build config failed: %v", err)
+// apiErrorf classifies the errors from the API server, the missing or
+// invalid resources are the user errors.
+func apiErrorf(err error, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if apierrors.IsNotFound(err) || apierrors.IsAlreadyExists(err) || apierrors.IsInvalid(err) {
+		return userErrorf("%s: %v", msg, err)
+	}
+	return clusterErrorf("%s: %v", msg, err)
+}
+
+// deployFlags are the flags shared by deploy, update and apply.
+type deployFlags struct {
+	kubeFlags
+	buildFlags
+	build       bool
+	inCluster   bool
+	interpreted bool
+	runtime     string
+}
+
+func (f *deployFlags) register(fs *flag.FlagSet) {
+	f.kubeFlags.register(fs)
+	f.buildFlags.register(fs)
+	fs.BoolVar(&f.build, "build", false, "build and push the image first")
+	fs.BoolVar(&f.inCluster, "in-cluster", false, "leave the image to the controller, which builds it from the source")
+	fs.BoolVar(&f.interpreted, "interpreted", false, "run the source by the interpreter, no image is built")
+	fs.StringVar(&f.runtime, "runtime", string(uselessv1.NativeRuntime),
+		"where the compiled function runs, one of: native|wasm, wasm runs it in a sandbox per invocation")
+}
+
+func (f *deployFlags) mode() uselessv1.FunctionMode {
+	if f.interpreted {
+		return uselessv1.InterpretedMode
+	}
+	return ""
+}
 
-	defaultReplicas := int32(1)
-	funcclientset, err := versioned.NewForConfig(config)
-	assert(err == nil, "create clientset failed: %v", err)
-	name = strings.ToLower(name) // XXX(damnever): to lower, fuck..
-	function, err := funcclientset.UselessV1().Functions(defaultNamespace).Create(
+// parseRuntime parses the runtime of the compiled function, the native one
+// is left empty as the default.
+func parseRuntime(s string) (uselessv1.FunctionRuntime, error) {
+	switch runtime := uselessv1.FunctionRuntime(s); runtime {
+	case "", uselessv1.NativeRuntime:
+		return "", nil
+	case uselessv1.WasmRuntime:
+		return runtime, nil
+	default:
+		return "", userErrorf("unknown runtime %q, want one of: %s|%s", s, uselessv1.NativeRuntime, uselessv1.WasmRuntime)
+	}
+}
+
+// prepare loads the function source and builds the image if required, it
+// returns the source and the image name, which is empty if the image is
+// built in the cluster or the function is interpreted.
+func (f *deployFlags) prepare(pathFunc string) (*builder.Source, string, error) {
+	if err := f.buildFlags.validate(); err != nil {
+		return nil, "", err
+	}
+	if f.build && f.inCluster {
+		return nil, "", userErrorf("-build and -in-cluster are mutually exclusive")
+	}
+	if f.interpreted && (f.build || f.inCluster) {
+		return nil, "", userErrorf("-interpreted can not be used with -build or -in-cluster")
+	}
+	runtime, err := parseRuntime(f.runtime)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.interpreted && runtime == uselessv1.WasmRuntime {
+		return nil, "", userErrorf("-interpreted can not be used with -runtime=%s", runtime)
+	}
+	source, err := readFunc(pathFunc)
+	if err != nil {
+		return nil, "", err
+	}
+	source.Runtime = runtime
+	if f.interpreted {
+		if err := source.CheckInterpretable(); err != nil {
+			return nil, "", userErrorf("the function can not be interpreted: %v", err)
+		}
+		return source, "", nil
+	}
+	if f.inCluster {
+		return source, "", nil
+	}
+	if !f.build {
+		image, err := imageName(source, f.buildFlags)
+		if err != nil {
+			return nil, "", err
+		}
+		// The tag changes with the sources, so the image must have been
+		// built, and it is pinned by the digest as build does.
+		image, err = f.builtImage(image)
+		return source, image, err
+	}
+	image, err := build(source, f.buildFlags, true)
+	return source, image, err
+}
+
+func runDeploy(fs *flag.FlagSet, args []string) error {
+	var flags deployFlags
+	flags.register(fs)
+	replicas := fs.Int("replicas", 1, "the initial replicas")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	source, image, err := flags.prepare(args[0])
+	if err != nil {
+		return err
+	}
+	_, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+
+	initialReplicas := int32(*replicas)
+	name := strings.ToLower(source.Sig.Name) // XXX(damnever): to lower, fuck..
+	function, err := funcclientset.UselessV1().Functions(flags.namespace).Create(context.TODO(),
 		&uselessv1.Function{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      name,
-				Namespace: defaultNamespace,
+				Namespace: flags.namespace,
+				Annotations: map[string]string{
+					signatureAnnotation: string(source.Sig.Kind),
+				},
 			},
 			Spec: uselessv1.FunctionSpec{
 				FuncName:    name,
-				FuncContent: content,
-				Image:       imageName(name, dockerReg),
-				Replicas:    &defaultReplicas,
+				FuncContent: source.Content(),
+				Source:      source.Spec(),
+				Mode:        flags.mode(),
+				Runtime:     source.Runtime,
+				Image:       image,
+				Replicas:    &initialReplicas,
 			},
 		},
+		metav1.CreateOptions{},
 	)
-	assert(err == nil, "create function failed: %v", err)
+	if err != nil {
+		return apiErrorf(err, "create function failed")
+	}
 	fmt.Printf("Function created: %s\n", function.GetName())
+	return nil
 }
 
-func deleteFunction(name, kubeConfig string) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
-	assert(err == nil, "build config failed: %v", err)
+func runUpdate(fs *flag.FlagSet, args []string) error {
+	var flags deployFlags
+	flags.register(fs)
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	source, image, err := flags.prepare(args[0])
+	if err != nil {
+		return err
+	}
+	_, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToLower(source.Sig.Name)
+	functions := funcclientset.UselessV1().Functions(flags.namespace)
+	function, err := functions.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return apiErrorf(err, "get function failed")
+	}
+	function = function.DeepCopy()
+	if function.Annotations == nil {
+		function.Annotations = map[string]string{}
+	}
+	function.Annotations[signatureAnnotation] = string(source.Sig.Kind)
+	function.Spec.FuncContent = source.Content()
+	function.Spec.Source = source.Spec()
+	function.Spec.Mode = flags.mode()
+	function.Spec.Runtime = source.Runtime
+	function.Spec.Image = image
+	if _, err := functions.Update(context.TODO(), function, metav1.UpdateOptions{}); err != nil {
+		return apiErrorf(err, "update function failed")
+	}
+	fmt.Printf("Function updated: %s\n", name)
+	return nil
+}
 
-	funcclientset, err := versioned.NewForConfig(config)
-	assert(err == nil, "create clientset failed: %v", err)
-	err = funcclientset.UselessV1().Functions(defaultNamespace).Delete(name, nil)
-	assert(err == nil, "Delete function failed: %v", err)
+func runDelete(fs *flag.FlagSet, args []string) error {
+	var flags kubeFlags
+	flags.register(fs)
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	_, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	if err := funcclientset.UselessV1().Functions(flags.namespace).Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+		return apiErrorf(err, "delete function failed")
+	}
 	fmt.Printf("Function deleted: %s\n", name)
+	return nil
+}
+
+func runGet(fs *flag.FlagSet, args []string) error {
+	var (
+		flags  kubeFlags
+		output outputFlag
+	)
+	flags.register(fs)
+	output.register(fs)
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if err := output.validate(); err != nil {
+		return err
+	}
+	_, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+	function, err := funcclientset.UselessV1().Functions(flags.namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+	if err != nil {
+		return apiErrorf(err, "get function failed")
+	}
+	if output.format != outputTable {
+		return printStructured(os.Stdout, output.format, withTypeMeta(function))
+	}
+	return printFunctions(os.Stdout, output.format, []uselessv1.Function{*function})
+}
+
+func runList(fs *flag.FlagSet, args []string) error {
+	var (
+		flags  kubeFlags
+		output outputFlag
+	)
+	flags.register(fs)
+	output.register(fs)
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if err := output.validate(); err != nil {
+		return err
+	}
+	_, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+	list, err := funcclientset.UselessV1().Functions(flags.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return apiErrorf(err, "list functions failed")
+	}
+	return printFunctions(os.Stdout, output.format, list.Items)
+}
+
+func runDescribe(fs *flag.FlagSet, args []string) error {
+	var (
+		flags  kubeFlags
+		output outputFlag
+	)
+	flags.register(fs)
+	output.register(fs)
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if err := output.validate(); err != nil {
+		return err
+	}
+	kubeclientset, funcclientset, err := flags.clientsets()
+	if err != nil {
+		return err
+	}
+	function, err := funcclientset.UselessV1().Functions(flags.namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+	if err != nil {
+		return apiErrorf(err, "get function failed")
+	}
+	selector := fields.Set{
+		"involvedObject.kind": "Function",
+		"involvedObject.name": function.Name,
+		"involvedObject.uid":  string(function.UID),
+	}.AsSelector().String()
+	events, err := kubeclientset.CoreV1().Events(flags.namespace).List(context.TODO(), metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return apiErrorf(err, "list events failed")
+	}
+	return describeFunction(os.Stdout, output.format, function, events.Items)
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/builder"
+)
+
+// defaultManifest is the manifest used if -f is not given.
+const defaultManifest = "useless.yaml"
+
+// The places to build the images.
+const (
+	localBuild   = "local"
+	clusterBuild = "cluster"
+)
+
+// manifest declares a function, e.g.:
+//
+//	name: whatthecommits
+//	source:
+//	  path: ./artifacts/what_the_commits.go
+//	  function: WhatTheCommits
+//	replicas: 1
+//	env:
+//	- name: LOG_LEVEL
+//	  value: debug
+//	triggers:
+//	- name: hourly
+//	  schedule: "0 * * * *"
+//	  input: '{"count": 1}'
+type manifest struct {
+	// Name is the name of the function, defaults to the lower cased name of
+	// the Go function.
+	Name string `json:"name,omitempty"`
+	// Namespace overrides the -namespace flag if it is set.
+	Namespace string         `json:"namespace,omitempty"`
+	Source    manifestSource `json:"source"`
+	// Image is the prebuilt image of the function, the source is not built
+	// if it is set.
+	Image string `json:"image,omitempty"`
+	// Registry overrides the -docker-registry flag if it is set.
+	Registry string `json:"registry,omitempty"`
+	// Build is where the image is built, "local" (the default) builds it by
+	// the cli, "cluster" leaves it to the controller.
+	Build string `json:"build,omitempty"`
+	// Mode is how the function runs, "compiled" (the default) or
+	// "interpreted", the interpreted function has no image.
+	Mode uselessv1.FunctionMode `json:"mode,omitempty"`
+	// Runtime is where the compiled function runs, "native" (the default)
+	// or "wasm", which runs it in a sandbox limited by Sandbox.
+	Runtime uselessv1.FunctionRuntime `json:"runtime,omitempty"`
+	Sandbox *uselessv1.SandboxSpec    `json:"sandbox,omitempty"`
+	// Replicas is the initial replicas, it is left to the autoscaler once
+	// the function is created unless the autoscaling is disabled.
+	Replicas            *int32                       `json:"replicas,omitempty"`
+	Env                 []corev1.EnvVar              `json:"env,omitempty"`
+	Resources           *corev1.ResourceRequirements `json:"resources,omitempty"`
+	Autoscaling         *uselessv1.AutoscalingSpec   `json:"autoscaling,omitempty"`
+	Triggers            []uselessv1.FunctionTrigger  `json:"triggers,omitempty"`
+	DrainTimeoutSeconds *int32                       `json:"drainTimeoutSeconds,omitempty"`
+
+	// dir is the directory of the manifest file, the source path is
+	// relative to it.
+	dir string
+}
+
+type manifestSource struct {
+	// Path is the Go file or the directory of the function.
+	Path string `json:"path"`
+	// Function is the name of the Go function.
+	Function string `json:"function"`
+}
+
+func loadManifest(fpath string) (*manifest, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, userErrorf("read manifest: %v", err)
+	}
+	m := &manifest{dir: filepath.Dir(fpath)}
+	if err := yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, userErrorf("invalid manifest %s: %v", fpath, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, userErrorf("invalid manifest %s: %v", fpath, err)
+	}
+	return m, nil
+}
+
+func (m *manifest) validate() error {
+	if m.Source.Path == "" || m.Source.Function == "" {
+		return fmt.Errorf("source.path and source.function are required")
+	}
+	if m.Name != "" {
+		// The name is used by the Service as well.
+		if errs := validation.IsDNS1035Label(m.Name); len(errs) > 0 {
+			return fmt.Errorf("name %q: %s", m.Name, strings.Join(errs, ", "))
+		}
+	}
+	switch m.Build {
+	case "", localBuild:
+	case clusterBuild:
+		if m.Image != "" {
+			return fmt.Errorf("image must not be set if the image is built in the cluster")
+		}
+	default:
+		return fmt.Errorf("unknown build %q, want one of: %s|%s", m.Build, localBuild, clusterBuild)
+	}
+	switch m.Mode {
+	case "", uselessv1.CompiledMode:
+	case uselessv1.InterpretedMode:
+		if m.Image != "" || m.Build != "" {
+			return fmt.Errorf("image and build must not be set if the function is interpreted")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q, want one of: %s|%s", m.Mode,
+			uselessv1.CompiledMode, uselessv1.InterpretedMode)
+	}
+	switch m.Runtime {
+	case "", uselessv1.NativeRuntime:
+		if m.Sandbox != nil {
+			return fmt.Errorf("sandbox must not be set unless the runtime is %s", uselessv1.WasmRuntime)
+		}
+	case uselessv1.WasmRuntime:
+		if m.Mode == uselessv1.InterpretedMode {
+			return fmt.Errorf("the interpreted function can not run in the %s runtime", m.Runtime)
+		}
+	default:
+		return fmt.Errorf("unknown runtime %q, want one of: %s|%s", m.Runtime,
+			uselessv1.NativeRuntime, uselessv1.WasmRuntime)
+	}
+	if m.Replicas != nil && *m.Replicas < 0 {
+		return fmt.Errorf("replicas must not be negative")
+	}
+	names := map[string]bool{}
+	for _, trigger := range m.Triggers {
+		if names[trigger.Name] {
+			return fmt.Errorf("duplicate trigger %q", trigger.Name)
+		}
+		names[trigger.Name] = true
+		// The same as Function.CronJobName.
+		if name := m.name() + "-" + trigger.Name; len(name) > uselessv1.MaxCronJobNameLength {
+			return fmt.Errorf("trigger %q: the name of its CronJob %q is longer than %d",
+				trigger.Name, name, uselessv1.MaxCronJobNameLength)
+		}
+	}
+	return nil
+}
+
+// loadSource loads the function source, the path is relative to the
+// manifest.
+func (m *manifest) loadSource() (*builder.Source, error) {
+	fpath := m.Source.Path
+	if !filepath.IsAbs(fpath) {
+		fpath = filepath.Join(m.dir, fpath)
+	}
+	source, err := readFunc(fpath + "::" + m.Source.Function)
+	if err != nil {
+		return nil, err
+	}
+	source.Runtime = m.Runtime
+	return source, nil
+}
+
+func (m *manifest) name() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return strings.ToLower(m.Source.Function)
+}
+
+func (m *manifest) namespace(flagNamespace string) string {
+	if m.Namespace != "" {
+		return m.Namespace
+	}
+	return flagNamespace
+}
+
+func (m *manifest) registry(flagRegistry string) string {
+	if m.Registry != "" {
+		return m.Registry
+	}
+	return flagRegistry
+}
+
+// function returns the desired Function, current is the one in the cluster,
+// it is nil if the function does not exist yet.
+func (m *manifest) function(namespace string, source *builder.Source, image string, current *uselessv1.Function) *uselessv1.Function {
+	name := m.name()
+	function := &uselessv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				signatureAnnotation: string(source.Sig.Kind),
+			},
+		},
+		Spec: uselessv1.FunctionSpec{
+			FuncName:            name,
+			FuncContent:         source.Content(),
+			Source:              source.Spec(),
+			Mode:                m.Mode,
+			Runtime:             m.Runtime,
+			Sandbox:             m.Sandbox,
+			Image:               image,
+			Replicas:            m.Replicas,
+			Autoscaling:         m.Autoscaling,
+			DrainTimeoutSeconds: m.DrainTimeoutSeconds,
+			Env:                 m.Env,
+			Resources:           m.Resources,
+			Triggers:            m.Triggers,
+		},
+	}
+	switch {
+	case current == nil:
+		if function.Spec.Replicas == nil {
+			replicas := int32(1)
+			function.Spec.Replicas = &replicas
+		}
+	case m.Replicas == nil || function.AutoscalingClass() != "":
+		// The replicas are not declared or owned by the autoscaler.
+		function.Spec.Replicas = current.Spec.Replicas
+	}
+	return function
+}
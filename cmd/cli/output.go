@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// outputFlag is the -o flag of the commands which print the resources.
+type outputFlag struct {
+	format string
+}
+
+func (f *outputFlag) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.format, "o", outputTable, "output format, one of: table|json|yaml")
+}
+
+func (f *outputFlag) validate() error {
+	switch f.format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	default:
+		return userErrorf("unknown output format %q, want one of: table|json|yaml", f.format)
+	}
+}
+
+// printStructured prints obj as JSON or YAML.
+func printStructured(w io.Writer, format string, obj interface{}) error {
+	var (
+		data []byte
+		err  error
+	)
+	if format == outputYAML {
+		data, err = yaml.Marshal(obj)
+	} else {
+		data, err = json.MarshalIndent(obj, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// withTypeMeta fills the TypeMeta, which is dropped by the clientset.
+func withTypeMeta(function *uselessv1.Function) *uselessv1.Function {
+	function = function.DeepCopy()
+	function.APIVersion = uselessv1.SchemeGroupVersion.String()
+	function.Kind = "Function"
+	return function
+}
+
+func printFunctions(w io.Writer, format string, functions []uselessv1.Function) error {
+	switch format {
+	case outputJSON, outputYAML:
+		list := &uselessv1.FunctionList{}
+		list.APIVersion = uselessv1.SchemeGroupVersion.String()
+		list.Kind = "FunctionList"
+		for i := range functions {
+			list.Items = append(list.Items, *withTypeMeta(&functions[i]))
+		}
+		return printStructured(w, format, list)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tREADY\tAVAILABLE\tURL\tIMAGE\tAGE")
+	for _, function := range functions {
+		ready := string(corev1.ConditionUnknown)
+		if cond := function.Status.GetCondition(uselessv1.FunctionReady); cond != nil {
+			ready = string(cond.Status)
+		}
+		image := function.Image()
+		switch {
+		case function.Interpreted():
+			image = "<interpreted>"
+		case image == "":
+			image = "<building>"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", function.Name, ready, function.Status.AvailableReplicas,
+			function.Status.URL, image, humanAge(function.CreationTimestamp.Time))
+	}
+	return tw.Flush()
+}
+
+func describeFunction(w io.Writer, format string, function *uselessv1.Function, events []corev1.Event) error {
+	switch format {
+	case outputJSON, outputYAML:
+		return printStructured(w, format, struct {
+			Function *uselessv1.Function `json:"function"`
+			Events   []corev1.Event      `json:"events"`
+		}{withTypeMeta(function), events})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	replicas := "<unset>"
+	if function.Spec.Replicas != nil {
+		replicas = fmt.Sprint(*function.Spec.Replicas)
+	}
+	fmt.Fprintf(tw, "Name:\t%s\n", function.Name)
+	fmt.Fprintf(tw, "Namespace:\t%s\n", function.Namespace)
+	fmt.Fprintf(tw, "Function:\t%s\n", function.Spec.FuncName)
+	if function.Interpreted() {
+		fmt.Fprintf(tw, "Mode:\t%s\n", uselessv1.InterpretedMode)
+	} else {
+		fmt.Fprintf(tw, "Mode:\t%s\n", uselessv1.CompiledMode)
+		if function.Wasm() {
+			memoryLimit, timeout := function.SandboxLimits()
+			fmt.Fprintf(tw, "Runtime:\t%s (%dMiB memory | %ds timeout per invocation)\n",
+				uselessv1.WasmRuntime, memoryLimit, timeout)
+		} else {
+			fmt.Fprintf(tw, "Runtime:\t%s\n", uselessv1.NativeRuntime)
+		}
+		fmt.Fprintf(tw, "Image:\t%s\n", function.Image())
+	}
+	if build := function.Status.Build; build != nil {
+		fmt.Fprintf(tw, "Build:\t%s\n", build.Phase)
+		if build.Job != "" {
+			fmt.Fprintf(tw, "Build Logs:\tkubectl logs -n %s job/%s\n", function.Namespace, build.Job)
+		}
+		if build.Phase == uselessv1.BuildFailed {
+			fmt.Fprintf(tw, "Build Failure:\t%s: %s\n", build.Reason, build.Message)
+		}
+	}
+	fmt.Fprintf(tw, "URL:\t%s\n", function.Status.URL)
+	fmt.Fprintf(tw, "Autoscaling:\t%s\n", function.AutoscalingClass())
+	fmt.Fprintf(tw, "Replicas:\t%s desired | %d current | %d ready | %d available\n", replicas,
+		function.Status.Replicas, function.Status.ReadyReplicas, function.Status.AvailableReplicas)
+	if source := function.Spec.Source; source != nil {
+		fmt.Fprintf(tw, "Source:\t%d files, module %q, directory %q\n", len(source.Files), source.Module, source.Dir)
+	}
+	fmt.Fprintf(tw, "Created:\t%s\n", function.CreationTimestamp.Time.Format(time.RFC3339))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nConditions:")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  TYPE\tSTATUS\tREASON\tAGE\tMESSAGE")
+	for _, cond := range function.Status.Conditions {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", cond.Type, cond.Status, cond.Reason,
+			humanAge(cond.LastTransitionTime.Time), cond.Message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nEvents:")
+	if len(events) == 0 {
+		fmt.Fprintln(w, "  <none>")
+		return nil
+	}
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  TYPE\tREASON\tAGE\tFROM\tMESSAGE")
+	for _, event := range events {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", event.Type, event.Reason,
+			humanAge(event.LastTimestamp.Time), event.Source.Component, strings.TrimSpace(event.Message))
+	}
+	return tw.Flush()
+}
+
+// humanAge formats the age like kubectl does.
+func humanAge(t time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -20,17 +20,24 @@ import (
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/transport"
 	"k8s.io/client-go/util/homedir"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 
 	"github.com/damnever/useless/controller"
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
 	clientset "github.com/damnever/useless/pkg/generated/clientset/versioned"
 	informers "github.com/damnever/useless/pkg/generated/informers/externalversions"
 )
 
 func main() {
 	var (
-		flagMasterURL  string
-		flagKubeConfig string
+		flagMasterURL        string
+		flagKubeConfig       string
+		flagActivatorService string
+		flagIngress          bool
+		flagIngressOpts      controller.IngressOptions
+		flagBuild            bool
+		flagBuildOpts        controller.BuildOptions
+		flagInterpreterImage string
 	)
 	flag.StringVar(&flagMasterURL, "master", "",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
@@ -42,6 +49,29 @@ func main() {
 		flag.StringVar(&flagKubeConfig, "kubeconfig", "",
 			"Absolute path to the kubeconfig file. Only required if out-of-cluster.")
 	}
+	flag.StringVar(&flagActivatorService, "activator-service", "useless/useless-activator",
+		"The namespace/name of the activator Service, the functions scaled to zero are routed to it.")
+	flag.BoolVar(&flagIngress, "ingress", false, "Create the Ingress of the functions, see -ingress-shared.")
+	flag.StringVar(&flagIngressOpts.RootDomain, "root-domain", uselessv1.DefaultRootDomain,
+		"The Ingress host of the functions is <function>.<root-domain>.")
+	flag.StringVar(&flagIngressOpts.Class, "ingress-class", "",
+		"The IngressClass of the Ingress, the default IngressClass of the cluster is used if it is empty.")
+	flag.StringVar(&flagIngressOpts.TLSSecret, "ingress-tls-secret", "",
+		"The TLS secret of the Ingress in the namespace of the function, TLS is disabled if it is empty.")
+	flag.BoolVar(&flagIngressOpts.Shared, "ingress-shared", false,
+		"Create one Ingress for all the functions in a namespace instead of one for each function.")
+	flag.BoolVar(&flagBuild, "build", false, "Build the images of the functions which do not specify one.")
+	flag.StringVar(&flagBuildOpts.BuilderImage, "builder-image", "registry.cn-hangzhou.aliyuncs.com/useless/builder:latest",
+		"The image of the builder which builds the images of the functions.")
+	flag.StringVar(&flagBuildOpts.Registry, "build-registry", "registry.cn-hangzhou.aliyuncs.com/useless",
+		"The registry which the built images are pushed to.")
+	flag.StringVar(&flagBuildOpts.RegistrySecret, "build-registry-secret", "",
+		"The dockerconfigjson secret in the namespace of the function to push the images.")
+	flag.StringVar(&flagBuildOpts.ServiceAccount, "build-service-account", "useless-builder",
+		"The service account in the namespace of the function to run the builds, it must be able to get the functions.")
+	flag.BoolVar(&flagBuildOpts.PlainHTTP, "build-plain-http", false, "Push the built images by http.")
+	flag.StringVar(&flagInterpreterImage, "interpreter-image", "registry.cn-hangzhou.aliyuncs.com/useless/interpreter:latest",
+		"The image of the interpreter which runs the interpreted functions.")
 	flag.Parse()
 	klog.SetOutput(os.Stdout)
 
@@ -87,7 +117,17 @@ func main() {
 				// we're notified when we start - this is where you would
 				// usually put your code
 				klog.Infof("%s: leading", ID)
-				runController(kubeClient, config, ctx.Done())
+				cfg := controller.Config{
+					ActivatorService: flagActivatorService,
+					InterpreterImage: flagInterpreterImage,
+				}
+				if flagIngress {
+					cfg.Ingress = &flagIngressOpts
+				}
+				if flagBuild {
+					cfg.Build = &flagBuildOpts
+				}
+				runController(cfg, kubeClient, config, ctx.Done())
 			},
 			OnStoppedLeading: func() {
 				// we can do cleanup here, or after the RunOrDie method
@@ -105,24 +145,37 @@ func main() {
 		},
 	})
 	// because the context is closed, the client should report errors
-	_, err = kubeClient.CoordinationV1().Leases(leaseLockNamespace).Get(leaseLockName, metav1.GetOptions{})
+	_, err = kubeClient.CoordinationV1().Leases(leaseLockNamespace).Get(context.Background(), leaseLockName, metav1.GetOptions{})
 	if err == nil || !strings.Contains(err.Error(), "the leader is shutting down") {
 		klog.Fatalf("%s: expected to get an error when trying to make a client call: %v", ID, err)
 	}
 }
 
-func runController(kubeClient *kubernetes.Clientset, config *rest.Config, stopCh <-chan struct{}) {
+func runController(cfg controller.Config, kubeClient *kubernetes.Clientset, config *rest.Config, stopCh <-chan struct{}) {
 	uselessClient := clientset.NewForConfigOrDie(config)
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
 	uselessInformerFactory := informers.NewSharedInformerFactory(uselessClient, time.Second*30)
-	controller := controller.New(kubeClient, uselessClient,
+	autoscaler := controller.NewAutoscaler(uselessClient,
+		kubeInformerFactory.Core().V1().Pods(),
+		uselessInformerFactory.Useless().V1().Functions())
+	controller := controller.New(cfg, kubeClient, uselessClient,
 		kubeInformerFactory.Apps().V1().Deployments(),
 		kubeInformerFactory.Core().V1().Services(),
-		kubeInformerFactory.Autoscaling().V1().HorizontalPodAutoscalers(),
+		kubeInformerFactory.Autoscaling().V2().HorizontalPodAutoscalers(),
+		kubeInformerFactory.Core().V1().Endpoints(),
+		kubeInformerFactory.Networking().V1().Ingresses(),
+		kubeInformerFactory.Batch().V1().CronJobs(),
+		kubeInformerFactory.Batch().V1().Jobs(),
+		kubeInformerFactory.Core().V1().ConfigMaps(),
 		uselessInformerFactory.Useless().V1().Functions())
 
 	kubeInformerFactory.Start(stopCh)
 	uselessInformerFactory.Start(stopCh)
+	go func() {
+		if err := autoscaler.Run(stopCh); err != nil {
+			klog.Fatalf("Error running autoscaler: %s", err.Error())
+		}
+	}()
 	if err := controller.Run(5, stopCh); err != nil {
 		klog.Fatalf("Error running controller: %s", err.Error())
 	}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog/v2"
+
+	"github.com/damnever/useless/gateway"
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	clientset "github.com/damnever/useless/pkg/generated/clientset/versioned"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions"
+)
+
+func main() {
+	var (
+		flagMasterURL  string
+		flagKubeConfig string
+		flagListenAddr string
+		flagRootDomain string
+	)
+	flag.StringVar(&flagMasterURL, "master", "",
+		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
+	if _, err := os.Stat(kubeconfig); err == nil {
+		flag.StringVar(&flagKubeConfig, "kubeconfig", kubeconfig,
+			"Absolute path to the kubeconfig file. Only required if out-of-cluster.")
+	} else {
+		flag.StringVar(&flagKubeConfig, "kubeconfig", "",
+			"Absolute path to the kubeconfig file. Only required if out-of-cluster.")
+	}
+	flag.StringVar(&flagListenAddr, "laddr", ":8080", "The listen address.")
+	flag.StringVar(&flagRootDomain, "root-domain", uselessv1.DefaultRootDomain,
+		"The functions are served as <function>[.<namespace>].<root-domain>.")
+	flag.Parse()
+	klog.SetOutput(os.Stdout)
+
+	config, err := clientcmd.BuildConfigFromFlags(flagMasterURL, flagKubeConfig)
+	if err != nil {
+		klog.Fatalf("Error building kubeconfig: %s", err)
+	}
+	uselessClient := clientset.NewForConfigOrDie(config)
+
+	stopCh := make(chan struct{})
+	watchStopSignals(stopCh)
+
+	uselessInformerFactory := informers.NewSharedInformerFactory(uselessClient, time.Second*30)
+	gateway := gateway.New(flagRootDomain, uselessInformerFactory.Useless().V1().Functions())
+
+	uselessInformerFactory.Start(stopCh)
+	if err := gateway.Run(flagListenAddr, stopCh); err != nil {
+		klog.Fatalf("Error running gateway: %s", err.Error())
+	}
+}
+
+func watchStopSignals(stopCh chan struct{}) {
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		close(stopCh)
+		<-sigc
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,50 @@
+// The interpreter is the generic supervisor of the interpreted functions, it
+// loads the source of the function mounted by the controller, and serves it
+// by the embedded Go interpreter, so no image is built for the function.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/damnever/useless/pkg/interpreter"
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+func main() {
+	laddr := flag.String("laddr", ":8080", "the listen address")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long the in-flight invocations can run on shutdown")
+	sourcePath := flag.String("source", "/etc/useless/source/source.json", "the JSON encoded source of the function")
+	funcName := flag.String("function", "", "the lower cased name of the function, "+
+		"the Go function is looked up by it if the source does not record its name")
+	flag.Parse()
+
+	name, function, err := load(*sourcePath, *funcName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Load function failed: %v\n", err)
+		os.Exit(1)
+	}
+	useless := uselessruntime.NewSupervisor(name, function,
+		uselessruntime.WithDrainTimeout(*drainTimeout))
+	defer useless.Close()
+	if err := useless.Run(*laddr); err != nil {
+		fmt.Fprintf(os.Stderr, "Launch function supervisor failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+func load(sourcePath, funcName string) (string, interface{}, error) {
+	data, err := ioutil.ReadFile(sourcePath)
+	if err != nil {
+		return "", nil, err
+	}
+	var source interpreter.Source
+	if err := json.Unmarshal(data, &source); err != nil {
+		return "", nil, fmt.Errorf("invalid source %s: %v", sourcePath, err)
+	}
+	return interpreter.Load(&source, funcName)
+}
@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	clientset "github.com/damnever/useless/pkg/generated/clientset/versioned"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions/useless/v1"
+	listers "github.com/damnever/useless/pkg/generated/listers/useless/v1"
+	"github.com/damnever/useless/pkg/podutil"
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+const (
+	defaultTargetConcurrency   = 10
+	defaultStableWindowSeconds = 60
+	defaultPanicWindowSeconds  = 6
+	// panicThreshold is the ratio of the desired replicas in panic window to
+	// the ready replicas to enter the panic mode.
+	panicThreshold = 2.0
+	// maxScaleUpRate is the ratio of the desired replicas to the ready
+	// replicas which one tick can scale up to, the concurrency of a burst is
+	// only measured on the ready ones.
+	maxScaleUpRate = 10.0
+)
+
+// Autoscaler scales the functions which use the concurrency autoscaling
+// class, the idea comes from the Knative pod autoscaler: it scrapes the
+// in-flight requests from every ready pod, averages the concurrency over
+// a stable window and a shorter panic window, and then writes the desired
+// replicas into the spec of the Function, the scale subresource's
+// specReplicasPath, the controller takes care of the rest.
+type Autoscaler struct {
+	uselessclientset clientset.Interface
+
+	funcsLister listers.FunctionLister
+	funcsSynced cache.InformerSynced
+	podsLister  corelistersv1.PodLister
+	podsSynced  cache.InformerSynced
+
+	tickInterval time.Duration
+	client       *http.Client
+
+	mu      sync.Mutex
+	scalers map[string]*concurrencyScaler
+}
+
+// NewAutoscaler returns a new concurrency based autoscaler.
+func NewAutoscaler(uselessclientset clientset.Interface,
+	podInformer coreinformersv1.PodInformer,
+	funcInformer informers.FunctionInformer) *Autoscaler {
+	return &Autoscaler{
+		uselessclientset: uselessclientset,
+		funcsLister:      funcInformer.Lister(),
+		funcsSynced:      funcInformer.Informer().HasSynced,
+		podsLister:       podInformer.Lister(),
+		podsSynced:       podInformer.Informer().HasSynced,
+		tickInterval:     2 * time.Second,
+		client:           &http.Client{Timeout: time.Second},
+		scalers:          map[string]*concurrencyScaler{},
+	}
+}
+
+// Run scales the functions periodically until stopCh is closed.
+func (a *Autoscaler) Run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+
+	klog.Info("Starting concurrency autoscaler")
+	if ok := cache.WaitForCacheSync(stopCh, a.funcsSynced, a.podsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	wait.Until(a.tick, a.tickInterval, stopCh)
+	klog.Info("Shutting down concurrency autoscaler")
+	return nil
+}
+
+func (a *Autoscaler) tick() {
+	functions, err := a.funcsLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	now := time.Now()
+	active := map[string]bool{}
+	var wg sync.WaitGroup
+	for _, function := range functions {
+		if function.AutoscalingClass() != uselessv1.ConcurrencyAutoscaling {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(function)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		active[key] = true
+
+		wg.Add(1)
+		go func(key string, function *uselessv1.Function) {
+			defer wg.Done()
+			if err := a.scale(key, function, now); err != nil {
+				utilruntime.HandleError(fmt.Errorf("autoscaling '%s': %v", key, err))
+			}
+		}(key, function)
+	}
+	wg.Wait()
+
+	a.mu.Lock()
+	for key := range a.scalers {
+		if !active[key] {
+			delete(a.scalers, key)
+		}
+	}
+	a.mu.Unlock()
+}
+
+func (a *Autoscaler) scaler(key string) *concurrencyScaler {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	scaler, ok := a.scalers[key]
+	if !ok {
+		scaler = &concurrencyScaler{lastActive: time.Now()}
+		a.scalers[key] = scaler
+	}
+	return scaler
+}
+
+func (a *Autoscaler) scale(key string, function *uselessv1.Function, now time.Time) error {
+	pods, err := a.podsLister.Pods(function.Namespace).List(
+		labels.SelectorFromSet(function.PodLabels()))
+	if err != nil {
+		return err
+	}
+	readyPods := 0
+	concurrency := 0.0
+	requests := int64(0)
+	for _, pod := range pods {
+		if !podutil.IsPodReady(pod) {
+			continue
+		}
+		stats, err := a.scrape(pod)
+		if err != nil {
+			klog.V(4).Infof("scraping pod %s/%s failed: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		readyPods++
+		concurrency += float64(stats.InFlight)
+		requests += stats.Requests
+	}
+
+	scaler := a.scaler(key)
+	if readyPods == 0 {
+		// Nothing to learn from, the pods are starting or scaled to zero,
+		// and the idle window starts once they are ready.
+		scaler.lastActive = now
+		return nil
+	}
+	scaler.record(now, concurrency, requests)
+
+	current := int32(1)
+	if function.Spec.Replicas != nil {
+		current = *function.Spec.Replicas
+	}
+	desired := scaler.desiredReplicas(now, function, current, readyPods)
+	if enabled, idleWindow := function.ScaleToZero(); enabled && now.Sub(scaler.lastActive) >= idleWindow {
+		desired = 0
+	}
+	if desired == current {
+		return nil
+	}
+
+	klog.Infof("autoscaling '%s' from %d to %d replicas (concurrency %.1f)", key, current, desired, concurrency)
+	patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, desired)
+	_, err = a.uselessclientset.UselessV1().Functions(function.Namespace).Patch(
+		context.TODO(), function.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+func (a *Autoscaler) scrape(pod *corev1.Pod) (*uselessruntime.Stats, error) {
+	url := fmt.Sprintf("http://%s:%d%s", pod.Status.PodIP, uselessv1.FunctionPort, uselessruntime.StatsPath)
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	stats := &uselessruntime.Stats{}
+	if err := json.NewDecoder(resp.Body).Decode(stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+type concurrencySample struct {
+	time        time.Time
+	concurrency float64
+}
+
+// concurrencyScaler keeps the samples of one function.
+type concurrencyScaler struct {
+	samples    []concurrencySample
+	panicUntil time.Time
+	// requests and lastActive are used to tell whether the function is
+	// idle, the short requests may never be seen by the samples.
+	requests   int64
+	lastActive time.Time
+}
+
+func (s *concurrencyScaler) record(now time.Time, concurrency float64, requests int64) {
+	s.samples = append(s.samples, concurrencySample{time: now, concurrency: concurrency})
+	if concurrency > 0 || requests != s.requests {
+		s.lastActive = now
+	}
+	s.requests = requests
+}
+
+func (s *concurrencyScaler) average(since time.Time) float64 {
+	sum, n := 0.0, 0
+	for _, sample := range s.samples {
+		if sample.time.Before(since) {
+			continue
+		}
+		sum += sample.concurrency
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+func (s *concurrencyScaler) desiredReplicas(now time.Time, function *uselessv1.Function, current int32, readyPods int) int32 {
+	target := float64(defaultTargetConcurrency)
+	stableWindow := defaultStableWindowSeconds * time.Second
+	panicWindow := defaultPanicWindowSeconds * time.Second
+	if spec := function.Spec.Autoscaling; spec != nil {
+		if spec.TargetConcurrency != nil {
+			target = float64(*spec.TargetConcurrency)
+		}
+		if spec.StableWindowSeconds != nil {
+			stableWindow = time.Duration(*spec.StableWindowSeconds) * time.Second
+		}
+		if spec.PanicWindowSeconds != nil {
+			panicWindow = time.Duration(*spec.PanicWindowSeconds) * time.Second
+		}
+	}
+
+	// Drop the samples out of the stable window.
+	for len(s.samples) > 0 && s.samples[0].time.Before(now.Add(-stableWindow)) {
+		s.samples = s.samples[1:]
+	}
+	stableDesired := int32(math.Ceil(s.average(now.Add(-stableWindow)) / target))
+	panicDesired := int32(math.Ceil(s.average(now.Add(-panicWindow)) / target))
+
+	desired := stableDesired
+	if float64(panicDesired)/float64(readyPods) >= panicThreshold {
+		// Enter or stay in the panic mode for a stable window.
+		s.panicUntil = now.Add(stableWindow)
+	}
+	if now.Before(s.panicUntil) {
+		desired = panicDesired
+		// Never scale down in panic mode.
+		if desired < current {
+			desired = current
+		}
+	}
+	if maxDesired := int32(math.Ceil(maxScaleUpRate * float64(readyPods))); desired > current && desired > maxDesired {
+		desired = maxDesired
+		if desired < current {
+			desired = current
+		}
+	}
+
+	minReplicas, maxReplicas := function.ReplicasRange()
+	if desired < minReplicas {
+		desired = minReplicas
+	}
+	if desired > maxReplicas {
+		desired = maxReplicas
+	}
+	return desired
+}
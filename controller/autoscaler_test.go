@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+func TestDesiredReplicas(t *testing.T) {
+	now := time.Unix(1000, 0)
+	int32ptr := func(i int32) *int32 { return &i }
+	// samples returns one sample every two seconds in [now-from, now-to].
+	samples := func(from, to time.Duration, concurrency float64) []concurrencySample {
+		var samples []concurrencySample
+		for d := from; d >= to; d -= 2 * time.Second {
+			samples = append(samples, concurrencySample{time: now.Add(-d), concurrency: concurrency})
+		}
+		return samples
+	}
+	concat := func(samples ...[]concurrencySample) []concurrencySample {
+		var all []concurrencySample
+		for _, s := range samples {
+			all = append(all, s...)
+		}
+		return all
+	}
+
+	cases := []struct {
+		name        string
+		autoscaling uselessv1.AutoscalingSpec
+		samples     []concurrencySample
+		panicUntil  time.Time
+		current     int32
+		readyPods   int
+		want        int32
+		wantPanic   bool
+	}{
+		{
+			name:      "stable",
+			samples:   samples(58*time.Second, 0, 30),
+			current:   2,
+			readyPods: 2,
+			want:      3,
+		},
+		{
+			name:      "stable scale down",
+			samples:   samples(58*time.Second, 0, 15),
+			current:   4,
+			readyPods: 4,
+			want:      2,
+		},
+		{
+			name:        "scale to min",
+			autoscaling: uselessv1.AutoscalingSpec{MinReplicas: int32ptr(2)},
+			samples:     samples(58*time.Second, 0, 0),
+			current:     5,
+			readyPods:   5,
+			want:        2,
+		},
+		{
+			name:        "capped by max",
+			autoscaling: uselessv1.AutoscalingSpec{MaxReplicas: 4},
+			samples:     samples(58*time.Second, 0, 100),
+			current:     3,
+			readyPods:   3,
+			want:        4,
+			wantPanic:   true,
+		},
+		{
+			name:      "samples out of the stable window are dropped",
+			samples:   concat(samples(120*time.Second, 62*time.Second, 100), samples(58*time.Second, 0, 10)),
+			current:   1,
+			readyPods: 1,
+			want:      1,
+		},
+		{
+			name:      "enter panic",
+			samples:   concat(samples(58*time.Second, 8*time.Second, 0), samples(6*time.Second, 0, 50)),
+			current:   1,
+			readyPods: 1,
+			want:      5,
+			wantPanic: true,
+		},
+		{
+			name:      "not panic below the threshold",
+			samples:   concat(samples(58*time.Second, 8*time.Second, 10), samples(6*time.Second, 0, 15)),
+			current:   2,
+			readyPods: 2,
+			want:      2,
+		},
+		{
+			name:       "never scale down in panic",
+			samples:    samples(58*time.Second, 0, 10),
+			panicUntil: now.Add(30 * time.Second),
+			current:    5,
+			readyPods:  5,
+			want:       5,
+			wantPanic:  true,
+		},
+		{
+			name:       "scale up in panic",
+			samples:    concat(samples(58*time.Second, 8*time.Second, 10), samples(6*time.Second, 0, 80)),
+			panicUntil: now.Add(30 * time.Second),
+			current:    5,
+			readyPods:  5,
+			want:       8,
+			wantPanic:  true,
+		},
+		{
+			name:       "exit panic",
+			samples:    samples(58*time.Second, 0, 10),
+			panicUntil: now.Add(-time.Second),
+			current:    5,
+			readyPods:  5,
+			want:       1,
+		},
+		{
+			name:        "max scale up rate",
+			autoscaling: uselessv1.AutoscalingSpec{MaxReplicas: 100, TargetConcurrency: int32ptr(1)},
+			samples:     samples(58*time.Second, 0, 50),
+			current:     2,
+			readyPods:   2,
+			want:        20,
+			wantPanic:   true,
+		},
+		{
+			name:        "max scale up rate never scales down",
+			autoscaling: uselessv1.AutoscalingSpec{MaxReplicas: 100, TargetConcurrency: int32ptr(1)},
+			samples:     samples(58*time.Second, 0, 50),
+			current:     30,
+			readyPods:   2,
+			want:        30,
+			wantPanic:   true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			autoscaling := c.autoscaling
+			autoscaling.Class = uselessv1.ConcurrencyAutoscaling
+			function := &uselessv1.Function{Spec: uselessv1.FunctionSpec{Autoscaling: &autoscaling}}
+			scaler := &concurrencyScaler{samples: c.samples, panicUntil: c.panicUntil}
+
+			got := scaler.desiredReplicas(now, function, c.current, c.readyPods)
+			if got != c.want {
+				t.Errorf("desired replicas: got %d, want %d", got, c.want)
+			}
+			if panicking := now.Before(scaler.panicUntil); panicking != c.wantPanic {
+				t.Errorf("panic mode: got %v, want %v", panicking, c.wantPanic)
+			}
+			for _, sample := range scaler.samples {
+				if sample.time.Before(now.Add(-defaultStableWindowSeconds * time.Second)) {
+					t.Errorf("sample at %v is out of the stable window", sample.time)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+// BuildOptions configures the in-cluster builds of the functions.
+type BuildOptions struct {
+	// BuilderImage is the image of cmd/builder.
+	BuilderImage string
+	// Registry is where the images are pushed to.
+	Registry string
+	// RegistrySecret is the name of the kubernetes.io/dockerconfigjson
+	// secret in the namespace of the function, which is used to push the
+	// images, the registry is accessed anonymously if it is empty.
+	RegistrySecret string
+	// ServiceAccount runs the builds, it must be able to get the Functions.
+	ServiceAccount string
+	// PlainHTTP pushes the images by http.
+	PlainHTTP bool
+}
+
+const (
+	// buildDeadlineSeconds fails the builds which hang, e.g. on the network.
+	buildDeadlineSeconds = 20 * 60
+	// buildDockerConfig is where the registry secret is mounted.
+	buildDockerConfig   = "/etc/useless/docker"
+	buildTerminationLog = "/dev/termination-log"
+)
+
+// buildJob returns the Job which builds the image from the current source,
+// the termination message of the pod is the image pinned by the digest, or
+// the tail of the logs if the build fails.
+func buildJob(f *uselessv1.Function, opts BuildOptions) *batchv1.Job {
+	hash := f.SourceHash()
+	labels := f.BuildLabels()
+	backoffLimit := int32(0)
+	deadline := int64(buildDeadlineSeconds)
+	container := corev1.Container{
+		Name:  "build",
+		Image: opts.BuilderImage,
+		Args: []string{
+			"-namespace=" + f.Namespace,
+			"-function=" + f.Name,
+			"-source-hash=" + hash,
+			"-registry=" + opts.Registry,
+			"-plain-http=" + strconv.FormatBool(opts.PlainHTTP),
+			"-termination-log=" + buildTerminationLog,
+		},
+		TerminationMessagePath:   buildTerminationLog,
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	podSpec := corev1.PodSpec{
+		RestartPolicy:      corev1.RestartPolicyNever,
+		ServiceAccountName: opts.ServiceAccount,
+	}
+	if opts.RegistrySecret != "" {
+		container.Env = []corev1.EnvVar{{Name: "DOCKER_CONFIG", Value: buildDockerConfig}}
+		container.VolumeMounts = []corev1.VolumeMount{{
+			Name:      "docker-config",
+			MountPath: buildDockerConfig,
+			ReadOnly:  true,
+		}}
+		podSpec.Volumes = []corev1.Volume{{
+			Name: "docker-config",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: opts.RegistrySecret,
+					Items: []corev1.KeyToPath{{
+						Key:  corev1.DockerConfigJsonKey,
+						Path: "config.json",
+					}},
+				},
+			},
+		}}
+	}
+	podSpec.Containers = []corev1.Container{container}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      f.Spec.FuncName + "-build-" + f.ShortSourceHash(),
+			Namespace: f.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(f, uselessv1.SchemeGroupVersion.WithKind("Function")),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
+
+// syncBuild builds the image in the cluster if the function does not
+// specify one: the build Job is created once the source changes, and the
+// build status is read from the Job. It returns nil if the image is specified
+// or the function is interpreted.
+func (c *Controller) syncBuild(function *uselessv1.Function) (*uselessv1.BuildStatus, error) {
+	if function.Spec.Image != "" || function.Interpreted() {
+		return nil, c.deleteBuildJobs(function, "")
+	}
+	build := &uselessv1.BuildStatus{}
+	if function.Status.Build != nil {
+		build = function.Status.Build.DeepCopy()
+	}
+	hash := function.SourceHash()
+	if build.SourceHash != hash {
+		// Keep the image of the last succeeded build, it is served until
+		// the new one is built.
+		*build = uselessv1.BuildStatus{Phase: uselessv1.BuildPending, SourceHash: hash, Image: build.Image}
+	}
+	if c.cfg.Build == nil {
+		build.Phase = uselessv1.BuildFailed
+		build.Reason = "BuildDisabled"
+		build.Message = "the controller does not build the images, the image must be specified"
+		return build, nil
+	}
+	// The build which has no Job has not started, e.g. it was disabled.
+	if build.Job != "" && (build.Phase == uselessv1.BuildSucceeded || build.Phase == uselessv1.BuildFailed) {
+		return build, c.deleteBuildJobs(function, build.Job)
+	}
+
+	desired := buildJob(function, *c.cfg.Build)
+	job, err := c.jobsLister.Jobs(function.Namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		job, err = c.kubeclientset.BatchV1().Jobs(function.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		c.recorder.Eventf(function, corev1.EventTypeNormal, BuildStarted, MessageBuildStarted, job.Name)
+	} else if err != nil {
+		return nil, err
+	} else if err := c.isOwner(function, job); err != nil {
+		return nil, err
+	}
+	build.Job = job.Name
+	build.StartTime = job.Status.StartTime
+
+	switch {
+	case jobCondition(job, batchv1.JobComplete):
+		message, err := c.buildMessage(job)
+		if err != nil {
+			return nil, err
+		}
+		// The message is like registry/name:tag@sha256:...
+		if !strings.Contains(message, "@sha256:") {
+			build.Phase = uselessv1.BuildFailed
+			build.Reason = "InvalidImage"
+			build.Message = fmt.Sprintf("the builder reported an invalid image %q", message)
+			c.recorder.Eventf(function, corev1.EventTypeWarning, ErrBuildFailed, MessageBuildFailed, job.Name, build.Message)
+			break
+		}
+		build.Phase = uselessv1.BuildSucceeded
+		build.Image = message
+		build.CompletionTime = job.Status.CompletionTime
+		c.recorder.Eventf(function, corev1.EventTypeNormal, BuildSucceeded, MessageBuildSucceeded, build.Image)
+	case jobCondition(job, batchv1.JobFailed):
+		message, err := c.buildMessage(job)
+		if err != nil {
+			return nil, err
+		}
+		build.Phase = uselessv1.BuildFailed
+		build.Reason, build.Message = "BuildFailed", message
+		for _, cond := range job.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Reason != "" {
+				build.Reason = cond.Reason
+				if build.Message == "" {
+					build.Message = cond.Message
+				}
+			}
+		}
+		build.CompletionTime = &metav1.Time{Time: jobFailedTime(job)}
+		c.recorder.Eventf(function, corev1.EventTypeWarning, ErrBuildFailed, MessageBuildFailed, job.Name, build.Message)
+	case job.Status.Active > 0:
+		build.Phase = uselessv1.BuildRunning
+	default:
+		build.Phase = uselessv1.BuildPending
+	}
+	return build, c.deleteBuildJobs(function, job.Name)
+}
+
+// buildMessage returns the termination message of the last pod of the build
+// Job, which is the image if the build succeeded, or the failure otherwise.
+func (c *Controller) buildMessage(job *batchv1.Job) (string, error) {
+	// The pods are only listed once the Job finishes, so there is no
+	// informer for them.
+	pods, err := c.kubeclientset.CoreV1().Pods(job.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"job-name": job.Name}).String(),
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[j].CreationTimestamp.Before(&pods.Items[i].CreationTimestamp)
+	})
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil {
+				return strings.TrimSpace(status.State.Terminated.Message), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// deleteBuildJobs deletes the build Jobs of the function except the current
+// one, the pods are deleted along with them.
+func (c *Controller) deleteBuildJobs(function *uselessv1.Function, current string) error {
+	jobs, err := c.jobsLister.Jobs(function.Namespace).List(labels.SelectorFromSet(function.BuildLabels()))
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		if job.Name == current || !metav1.IsControlledBy(job, function) {
+			continue
+		}
+		klog.V(4).Infof("deleting job %s/%s", job.Namespace, job.Name)
+		err := c.kubeclientset.BatchV1().Jobs(function.Namespace).Delete(context.TODO(), job.Name,
+			metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessDeleted, MessageResourceDeleted, job.Name)
+	}
+	return nil
+}
+
+func jobCondition(job *batchv1.Job, t batchv1.JobConditionType) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == t && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// jobFailedTime returns when the Job failed, the CompletionTime is only set
+// for the succeeded Jobs.
+func jobFailedTime(job *batchv1.Job) time.Time {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed {
+			return cond.LastTransitionTime.Time
+		}
+	}
+	return time.Now()
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+const builtImage = "registry/test:0123@sha256:abcd"
+
+func newSourceFunction(name string) *uselessv1.Function {
+	function := newFunction(name, int32Ptr(1))
+	function.Spec.Image = ""
+	function.Spec.Source = &uselessv1.FunctionSource{
+		Function: "Test",
+		Files:    map[string]string{"test.go": "package test\n"},
+	}
+	return function
+}
+
+// finishedJob returns the build Job of the function in the condition, and
+// the pod which terminated with the message.
+func finishedJob(function *uselessv1.Function, cond batchv1.JobConditionType, message string) (*batchv1.Job, *corev1.Pod) {
+	job := buildJob(function, BuildOptions{})
+	job.Status.Conditions = []batchv1.JobCondition{{Type: cond, Status: corev1.ConditionTrue, Reason: "Reason"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: job.Namespace,
+			Name:      job.Name + "-abcde",
+			Labels:    map[string]string{"job-name": job.Name},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+			}},
+		},
+	}
+	return job, pod
+}
+
+func TestSyncBuild(t *testing.T) {
+	function := newSourceFunction("test")
+	hash := function.SourceHash()
+	opts := &BuildOptions{BuilderImage: "builder", Registry: "registry"}
+	job := buildJob(function, *opts)
+	running := job.DeepCopy()
+	running.Status.Active = 1
+	succeeded, succeededPod := finishedJob(function, batchv1.JobComplete, builtImage)
+	invalid, invalidPod := finishedJob(function, batchv1.JobComplete, "oops")
+	failed, failedPod := finishedJob(function, batchv1.JobFailed, "compile error")
+	stale := job.DeepCopy()
+	stale.Name = "test-build-stale"
+	withImage := newFunction("test", int32Ptr(1))
+	notOurs := job.DeepCopy()
+	notOurs.OwnerReferences = nil
+
+	testCases := []struct {
+		name          string
+		function      *uselessv1.Function
+		opts          *BuildOptions
+		jobs          []*batchv1.Job
+		pods          []*corev1.Pod
+		expected      func(f *fixture)
+		expectedBuild *uselessv1.BuildStatus
+		expectError   bool
+	}{
+		{
+			name:     "image specified",
+			function: withImage,
+			opts:     opts,
+			jobs:     []*batchv1.Job{stale},
+			expected: func(f *fixture) {
+				f.expectDeleteAction("jobs", function.Namespace, stale.Name)
+			},
+		},
+		{
+			name:     "disabled",
+			function: function,
+			expected: func(*fixture) {},
+			expectedBuild: &uselessv1.BuildStatus{
+				Phase:      uselessv1.BuildFailed,
+				SourceHash: hash,
+				Reason:     "BuildDisabled",
+				Message:    "the controller does not build the images, the image must be specified",
+			},
+		},
+		{
+			name:     "started",
+			function: function,
+			opts:     opts,
+			expected: func(f *fixture) {
+				f.expectCreateAction("jobs", function.Namespace, job)
+			},
+			expectedBuild: &uselessv1.BuildStatus{Phase: uselessv1.BuildPending, SourceHash: hash, Job: job.Name},
+		},
+		{
+			name:          "running",
+			function:      function,
+			opts:          opts,
+			jobs:          []*batchv1.Job{running, stale},
+			expected:      func(f *fixture) { f.expectDeleteAction("jobs", function.Namespace, stale.Name) },
+			expectedBuild: &uselessv1.BuildStatus{Phase: uselessv1.BuildRunning, SourceHash: hash, Job: job.Name},
+		},
+		{
+			name:     "succeeded",
+			function: function,
+			opts:     opts,
+			jobs:     []*batchv1.Job{succeeded},
+			pods:     []*corev1.Pod{succeededPod},
+			expected: func(*fixture) {},
+			expectedBuild: &uselessv1.BuildStatus{
+				Phase:      uselessv1.BuildSucceeded,
+				SourceHash: hash,
+				Job:        job.Name,
+				Image:      builtImage,
+			},
+		},
+		{
+			name:     "invalid image",
+			function: function,
+			opts:     opts,
+			jobs:     []*batchv1.Job{invalid},
+			pods:     []*corev1.Pod{invalidPod},
+			expected: func(*fixture) {},
+			expectedBuild: &uselessv1.BuildStatus{
+				Phase:      uselessv1.BuildFailed,
+				SourceHash: hash,
+				Job:        job.Name,
+				Reason:     "InvalidImage",
+				Message:    `the builder reported an invalid image "oops"`,
+			},
+		},
+		{
+			name:     "failed",
+			function: function,
+			opts:     opts,
+			jobs:     []*batchv1.Job{failed},
+			pods:     []*corev1.Pod{failedPod},
+			expected: func(*fixture) {},
+			expectedBuild: &uselessv1.BuildStatus{
+				Phase:      uselessv1.BuildFailed,
+				SourceHash: hash,
+				Job:        job.Name,
+				Reason:     "Reason",
+				Message:    "compile error",
+			},
+		},
+		{
+			name:        "not ours",
+			function:    function,
+			opts:        opts,
+			jobs:        []*batchv1.Job{notOurs},
+			expected:    func(*fixture) {},
+			expectError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.build = tc.opts
+			for _, job := range tc.jobs {
+				f.jobLister = append(f.jobLister, job)
+				f.kubeobjects = append(f.kubeobjects, job)
+			}
+			for _, pod := range tc.pods {
+				f.kubeobjects = append(f.kubeobjects, pod)
+			}
+			tc.expected(f)
+
+			c, _, _ := f.newController()
+			build, err := c.syncBuild(tc.function)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error: %v, got %v", tc.expectError, err)
+			}
+			if build != nil {
+				// The times come from the Job, they are not compared.
+				build.StartTime, build.CompletionTime = nil, nil
+			}
+			if (build == nil) != (tc.expectedBuild == nil) ||
+				(build != nil && *build != *tc.expectedBuild) {
+				t.Errorf("expected build %+v, got %+v", tc.expectedBuild, build)
+			}
+			actions := filterInformerActions(f.kubeclient.Actions())
+			if len(actions) != len(f.kubeactions) {
+				t.Fatalf("expected actions %+v, got %+v", f.kubeactions, actions)
+			}
+			for i := range actions {
+				checkAction(f.kubeactions[i], actions[i], t)
+			}
+		})
+	}
+}
+
+func TestSyncHandlerWaitsForFirstBuild(t *testing.T) {
+	f := newFixture(t)
+	function := newSourceFunction("test")
+	f.build = &BuildOptions{BuilderImage: "builder", Registry: "registry"}
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+
+	c, _, _ := f.newController()
+	if err := c.syncHandler(getKey(function, t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+		if action.GetResource().Resource == "deployments" {
+			t.Errorf("expected no Deployment before the first image is built, got %+v", action)
+		}
+	}
+	updated, err := f.client.UselessV1().Functions(function.Namespace).Get(context.TODO(), function.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build := updated.Status.Build; build == nil || build.Phase != uselessv1.BuildPending {
+		t.Errorf("expected the pending build, got %+v", build)
+	}
+	if cond := updated.Status.GetCondition(uselessv1.FunctionDeployed); cond == nil || cond.Reason != "NoImage" {
+		t.Errorf("expected the NoImage Deployed condition, got %+v", cond)
+	}
+}
@@ -4,29 +4,39 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
-	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	appsinformersv1 "k8s.io/client-go/informers/apps/v1"
-	autoscalinginformersv1 "k8s.io/client-go/informers/autoscaling/v1"
+	autoscalinginformersv2 "k8s.io/client-go/informers/autoscaling/v2"
+	batchinformersv1 "k8s.io/client-go/informers/batch/v1"
 	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	networkinginformersv1 "k8s.io/client-go/informers/networking/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslistersv1 "k8s.io/client-go/listers/apps/v1"
-	autoscalinglistersv1 "k8s.io/client-go/listers/autoscaling/v1"
+	autoscalinglistersv2 "k8s.io/client-go/listers/autoscaling/v2"
+	batchlistersv1 "k8s.io/client-go/listers/batch/v1"
 	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	networkinglistersv1 "k8s.io/client-go/listers/networking/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 
 	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
 	clientset "github.com/damnever/useless/pkg/generated/clientset/versioned"
@@ -40,20 +50,71 @@ const controllerAgentName = "useless-controller"
 const (
 	// SuccessSynced is used as part of the Event 'reason' when a Foo is synced
 	SuccessSynced = "Synced"
+	// SuccessUpdated is used as part of the Event 'reason' when a resource
+	// owned by a Function is updated to match the Function
+	SuccessUpdated = "Updated"
+	// SuccessDeleted is used as part of the Event 'reason' when a resource
+	// owned by a Function is no longer needed and deleted
+	SuccessDeleted = "Deleted"
 	// ErrResourceExists is used as part of the Event 'reason' when a Foo fails
 	// to sync due to a Deployment of the same name already existing.
 	ErrResourceExists = "ErrResourceExists"
+	// ErrInvalidSpec is used as part of the Event 'reason' when a Function
+	// can not be synced due to an invalid spec.
+	ErrInvalidSpec = "ErrInvalidSpec"
+	// BuildStarted is used as part of the Event 'reason' when the build Job
+	// of a Function is created
+	BuildStarted = "BuildStarted"
+	// BuildSucceeded is used as part of the Event 'reason' when the image of
+	// a Function is built
+	BuildSucceeded = "BuildSucceeded"
+	// ErrBuildFailed is used as part of the Event 'reason' when the image of
+	// a Function fails to build
+	ErrBuildFailed = "ErrBuildFailed"
 
 	// MessageResourceExists is the message used for Events when a resource
 	// fails to sync due to a Deployment already existing
 	MessageResourceExists = "Resource %q already exists and is not managed by Function"
+	// MessageResourceUpdated is the message used for Events when a resource
+	// drifted from the Function and has been updated
+	MessageResourceUpdated = "Resource %q updated to match Function"
+	// MessageResourceDeleted is the message used for Events when a resource
+	// is no longer needed by the Function
+	MessageResourceDeleted = "Resource %q deleted since Function no longer needs it"
 	// MessageResourceSynced is the message used for an Event fired when a Foo
 	// is synced successfully
 	MessageResourceSynced = "Function synced successfully"
+	// MessageBuildStarted is the message used for Events when the build Job
+	// is created
+	MessageBuildStarted = "Build Job %q created"
+	// MessageBuildSucceeded is the message used for Events when the image is
+	// built
+	MessageBuildSucceeded = "Image %q built"
+	// MessageBuildFailed is the message used for Events when the build fails,
+	// the logs of the Job tell more
+	MessageBuildFailed = "Build Job %q failed: %s"
 )
 
+// Config holds the options of the controller.
+type Config struct {
+	// ActivatorService is the namespace/name of the activator Service, the
+	// functions scaled to zero are routed to it.
+	ActivatorService string
+	// Ingress is the options of the Ingress of the functions, the Ingress is
+	// not created if it is nil.
+	Ingress *IngressOptions
+	// Build is the options of the in-cluster builds, the functions must
+	// specify their images if it is nil.
+	Build *BuildOptions
+	// InterpreterImage is the image of cmd/interpreter, which runs the
+	// interpreted functions.
+	InterpreterImage string
+}
+
 // Controller is the controller implementation for Foo resources
 type Controller struct {
+	cfg Config
+
 	// kubeclientset is a standard kubernetes clientset
 	kubeclientset kubernetes.Interface
 	// uselessclientset is a clientset for our own API group
@@ -65,8 +126,18 @@ type Controller struct {
 	funcsSynced       cache.InformerSynced
 	servicesLister    corelistersv1.ServiceLister
 	serviceSynced     cache.InformerSynced
-	hpasLister        autoscalinglistersv1.HorizontalPodAutoscalerLister
+	hpasLister        autoscalinglistersv2.HorizontalPodAutoscalerLister
 	hpaSynced         cache.InformerSynced
+	endpointsLister   corelistersv1.EndpointsLister
+	endpointsSynced   cache.InformerSynced
+	ingressesLister   networkinglistersv1.IngressLister
+	ingressesSynced   cache.InformerSynced
+	cronJobsLister    batchlistersv1.CronJobLister
+	cronJobsSynced    cache.InformerSynced
+	jobsLister        batchlistersv1.JobLister
+	jobsSynced        cache.InformerSynced
+	configMapsLister  corelistersv1.ConfigMapLister
+	configMapsSynced  cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -80,10 +151,15 @@ type Controller struct {
 }
 
 // New returns a new useless controller
-func New(kubeclientset kubernetes.Interface, uselessclientset clientset.Interface,
+func New(cfg Config, kubeclientset kubernetes.Interface, uselessclientset clientset.Interface,
 	deploymentInformer appsinformersv1.DeploymentInformer,
 	serviceInformer coreinformersv1.ServiceInformer,
-	hpaInformer autoscalinginformersv1.HorizontalPodAutoscalerInformer,
+	hpaInformer autoscalinginformersv2.HorizontalPodAutoscalerInformer,
+	endpointsInformer coreinformersv1.EndpointsInformer,
+	ingressInformer networkinginformersv1.IngressInformer,
+	cronJobInformer batchinformersv1.CronJobInformer,
+	jobInformer batchinformersv1.JobInformer,
+	configMapInformer coreinformersv1.ConfigMapInformer,
 	funcInformer informers.FunctionInformer) *Controller {
 
 	// Create event broadcaster
@@ -97,6 +173,7 @@ func New(kubeclientset kubernetes.Interface, uselessclientset clientset.Interfac
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
 	controller := &Controller{
+		cfg:               cfg,
 		kubeclientset:     kubeclientset,
 		uselessclientset:  uselessclientset,
 		deploymentsLister: deploymentInformer.Lister(),
@@ -107,6 +184,16 @@ func New(kubeclientset kubernetes.Interface, uselessclientset clientset.Interfac
 		serviceSynced:     serviceInformer.Informer().HasSynced,
 		hpasLister:        hpaInformer.Lister(),
 		hpaSynced:         hpaInformer.Informer().HasSynced,
+		endpointsLister:   endpointsInformer.Lister(),
+		endpointsSynced:   endpointsInformer.Informer().HasSynced,
+		ingressesLister:   ingressInformer.Lister(),
+		ingressesSynced:   ingressInformer.Informer().HasSynced,
+		cronJobsLister:    cronJobInformer.Lister(),
+		cronJobsSynced:    cronJobInformer.Informer().HasSynced,
+		jobsLister:        jobInformer.Lister(),
+		jobsSynced:        jobInformer.Informer().HasSynced,
+		configMapsLister:  configMapInformer.Lister(),
+		configMapsSynced:  configMapInformer.Informer().HasSynced,
 		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Foos"),
 		recorder:          recorder,
 	}
@@ -154,8 +241,8 @@ func New(kubeclientset kubernetes.Interface, uselessclientset clientset.Interfac
 	hpaInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.handleObject,
 		UpdateFunc: func(old, new interface{}) {
-			newDepl := new.(*autoscalingv1.HorizontalPodAutoscaler)
-			oldDepl := old.(*autoscalingv1.HorizontalPodAutoscaler)
+			newDepl := new.(*autoscalingv2.HorizontalPodAutoscaler)
+			oldDepl := old.(*autoscalingv2.HorizontalPodAutoscaler)
 			if newDepl.ResourceVersion == oldDepl.ResourceVersion {
 				return
 			}
@@ -163,6 +250,67 @@ func New(kubeclientset kubernetes.Interface, uselessclientset clientset.Interfac
 		},
 		DeleteFunc: controller.handleObject,
 	})
+	ingressInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleIngress,
+		UpdateFunc: func(old, new interface{}) {
+			newIng := new.(*networkingv1.Ingress)
+			oldIng := old.(*networkingv1.Ingress)
+			if newIng.ResourceVersion == oldIng.ResourceVersion {
+				return
+			}
+			controller.handleIngress(new)
+		},
+		DeleteFunc: controller.handleIngress,
+	})
+	cronJobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newCj := new.(*batchv1.CronJob)
+			oldCj := old.(*batchv1.CronJob)
+			if newCj.ResourceVersion == oldCj.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+	jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newJob := new.(*batchv1.Job)
+			oldJob := old.(*batchv1.Job)
+			if newJob.ResourceVersion == oldJob.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newCm := new.(*corev1.ConfigMap)
+			oldCm := old.(*corev1.ConfigMap)
+			if newCm.ResourceVersion == oldCm.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+	// The functions scaled to zero must follow the endpoints of the activator.
+	endpointsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleActivatorEndpoints,
+		UpdateFunc: func(old, new interface{}) {
+			newEps := new.(*corev1.Endpoints)
+			oldEps := old.(*corev1.Endpoints)
+			if newEps.ResourceVersion == oldEps.ResourceVersion {
+				return
+			}
+			controller.handleActivatorEndpoints(new)
+		},
+		DeleteFunc: controller.handleActivatorEndpoints,
+	})
 
 	return controller
 }
@@ -180,7 +328,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.funcsSynced, c.serviceSynced, c.hpaSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.funcsSynced, c.serviceSynced, c.hpaSynced, c.endpointsSynced, c.ingressesSynced, c.cronJobsSynced, c.jobsSynced, c.configMapsSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -278,7 +426,8 @@ func (c *Controller) syncHandler(key string) error {
 		// processing.
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("foo '%s' in work queue no longer exists", key))
-			return nil
+			// Remove the rule of the function from the shared Ingress.
+			return c.syncSharedIngress(namespace)
 		}
 
 		return err
@@ -291,8 +440,48 @@ func (c *Controller) syncHandler(key string) error {
 		utilruntime.HandleError(fmt.Errorf("%s: Function name must be specified", key))
 		return nil
 	}
+	// The range is validated after the defaulting, e.g. minReplicas 20 is
+	// invalid without maxReplicas.
+	if minReplicas, maxReplicas := function.ReplicasRange(); function.AutoscalingClass() != "" &&
+		minReplicas > maxReplicas {
+		msg := "minReplicas must not be greater than maxReplicas"
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, msg)
+		utilruntime.HandleError(fmt.Errorf("%s: %s", key, msg))
+		return nil
+	}
+	for _, trigger := range function.Spec.Triggers {
+		if name := function.CronJobName(trigger.Name); len(name) > uselessv1.MaxCronJobNameLength {
+			msg := fmt.Sprintf("the name of the CronJob %q of trigger %q is longer than %d",
+				name, trigger.Name, uselessv1.MaxCronJobNameLength)
+			c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, msg)
+			utilruntime.HandleError(fmt.Errorf("%s: %s", key, msg))
+			return nil
+		}
+	}
+
+	build, err := c.syncBuild(function)
+	if err != nil {
+		return err
+	}
+	if err := c.syncSource(function); err != nil {
+		return err
+	}
+	// The function is not deployed until its first image is built.
+	var deployment *appsv1.Deployment
+	if build == nil || build.Image != "" {
+		deploying := function
+		if build != nil {
+			deploying = function.DeepCopy()
+			deploying.Status.Build = build
+		}
+		if deployment, err = c.tryDeploy(deploying); err != nil {
+			return err
+		}
+	}
 
-	if err := c.tryDeploy(function); err != nil {
+	// Finally, we update the status block of the Function resource to reflect the
+	// current state of the world
+	if err := c.updateFuncStatus(function, build, deployment); err != nil {
 		return err
 	}
 
@@ -300,52 +489,435 @@ func (c *Controller) syncHandler(key string) error {
 	return nil
 }
 
-func (c *Controller) tryDeploy(function *uselessv1.Function) error {
-	isOwner := func(obj, owner metav1.Object) error {
-		if !metav1.IsControlledBy(obj, function) {
-			msg := fmt.Sprintf(MessageResourceExists, obj.GetName())
-			c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
-			return fmt.Errorf(msg)
+func (c *Controller) tryDeploy(function *uselessv1.Function) (*appsv1.Deployment, error) {
+	deployment, err := c.syncDeployment(function)
+	if err != nil {
+		return nil, err
+	}
+	// The Service is routed to the activator if there is no ready replica.
+	proxied := false
+	if enabled, _ := function.ScaleToZero(); enabled && deployment.Status.ReadyReplicas == 0 {
+		proxied = true
+	}
+	if err := c.syncService(function, proxied); err != nil {
+		return nil, err
+	}
+	if proxied {
+		if err := c.syncProxiedEndpoints(function); err != nil {
+			return nil, err
 		}
-		return nil
 	}
-	service, err := c.servicesLister.Services(function.Namespace).Get(function.Spec.FuncName)
+	if err := c.syncIngress(function); err != nil {
+		return nil, err
+	}
+	if err := c.syncHorizontalPodAutoscaler(function); err != nil {
+		return nil, err
+	}
+	if err := c.syncTriggers(function); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// isOwner checks whether the object is controlled by the function, we must not
+// touch the resources we did not create.
+func (c *Controller) isOwner(function *uselessv1.Function, obj metav1.Object) error {
+	if !metav1.IsControlledBy(obj, function) {
+		msg := fmt.Sprintf(MessageResourceExists, obj.GetName())
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+	return nil
+}
+
+// serviceDrifted tells whether the Service differs from the desired one. Like
+// the other drift checks, the fields we own are compared exactly, so that
+// anything added to them by hand is reverted, and DeepDerivative is only used
+// for the fields which are defaulted by the API server: the unset fields in
+// the desired object are ignored there.
+func serviceDrifted(desired, actual *corev1.Service) bool {
+	if desired.Spec.Type != actual.Spec.Type ||
+		!equality.Semantic.DeepEqual(desired.Spec.Selector, actual.Spec.Selector) ||
+		len(desired.Spec.Ports) != len(actual.Spec.Ports) {
+		return true
+	}
+	for i := range desired.Spec.Ports {
+		port, actualPort := &desired.Spec.Ports[i], &actual.Spec.Ports[i]
+		if port.Name != actualPort.Name || port.Protocol != actualPort.Protocol || port.Port != actualPort.Port {
+			return true
+		}
+		// The target port is defaulted to the port if unset.
+		if port.TargetPort != (intstr.IntOrString{}) && port.TargetPort != actualPort.TargetPort {
+			return true
+		}
+	}
+	return false
+}
+
+func deploymentDrifted(desired, actual *appsv1.Deployment) bool {
+	if desired.Spec.Replicas != nil && !equality.Semantic.DeepEqual(desired.Spec.Replicas, actual.Spec.Replicas) {
+		return true
+	}
+	template, actualTemplate := &desired.Spec.Template, &actual.Spec.Template
+	if !equality.Semantic.DeepEqual(template.Labels, actualTemplate.Labels) {
+		return true
+	}
+	spec, actualSpec := &template.Spec, &actualTemplate.Spec
+	if len(spec.Volumes) != len(actualSpec.Volumes) ||
+		len(spec.InitContainers) != len(actualSpec.InitContainers) ||
+		len(spec.Containers) != len(actualSpec.Containers) {
+		return true
+	}
+	for i := range spec.Containers {
+		if containerDrifted(&spec.Containers[i], &actualSpec.Containers[i]) {
+			return true
+		}
+	}
+	// The rest of the pod spec, e.g. the probes, is partly defaulted.
+	return !equality.Semantic.DeepDerivative(*template, *actualTemplate)
+}
+
+func hpaDrifted(desired, actual *autoscalingv2.HorizontalPodAutoscaler) bool {
+	spec, actualSpec := &desired.Spec, &actual.Spec
+	if !equality.Semantic.DeepEqual(spec.ScaleTargetRef, actualSpec.ScaleTargetRef) ||
+		!equality.Semantic.DeepEqual(spec.MinReplicas, actualSpec.MinReplicas) ||
+		spec.MaxReplicas != actualSpec.MaxReplicas ||
+		!equality.Semantic.DeepEqual(spec.Metrics, actualSpec.Metrics) ||
+		(spec.Behavior == nil) != (actualSpec.Behavior == nil) {
+		return true
+	}
+	// The rules and the policies left out of the behavior are defaulted.
+	return !equality.Semantic.DeepDerivative(spec.Behavior, actualSpec.Behavior)
+}
+
+func containerDrifted(desired, actual *corev1.Container) bool {
+	return desired.Name != actual.Name ||
+		desired.Image != actual.Image ||
+		!equality.Semantic.DeepEqual(desired.Command, actual.Command) ||
+		!equality.Semantic.DeepEqual(desired.Args, actual.Args) ||
+		!equality.Semantic.DeepEqual(desired.VolumeMounts, actual.VolumeMounts) ||
+		!equality.Semantic.DeepEqual(desired.Resources, actual.Resources) ||
+		len(desired.Env) != len(actual.Env) ||
+		len(desired.Ports) != len(actual.Ports) ||
+		(desired.StartupProbe == nil) != (actual.StartupProbe == nil) ||
+		(desired.LivenessProbe == nil) != (actual.LivenessProbe == nil) ||
+		(desired.ReadinessProbe == nil) != (actual.ReadinessProbe == nil) ||
+		(desired.Lifecycle == nil) != (actual.Lifecycle == nil)
+}
+
+// syncService removes the selector of the Service if it is proxied, so that
+// the Endpoints can be managed by us.
+func (c *Controller) syncService(function *uselessv1.Function, proxied bool) error {
+	desired := function.Service()
+	if proxied {
+		desired.Spec.Selector = nil
+	}
+	service, err := c.servicesLister.Services(function.Namespace).Get(desired.Name)
 	if errors.IsNotFound(err) {
-		_, err = c.kubeclientset.CoreV1().Services(
-			function.Namespace).Create(function.Service())
-	} else if err == nil {
-		err = isOwner(service, function)
+		_, err = c.kubeclientset.CoreV1().Services(function.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+		return err
 	}
 	if err != nil {
 		return err
 	}
+	if err := c.isOwner(function, service); err != nil {
+		return err
+	}
+	if !serviceDrifted(desired, service) {
+		return nil
+	}
+
+	service = service.DeepCopy()
+	service.Spec.Selector = desired.Spec.Selector
+	service.Spec.Ports = desired.Spec.Ports
+	service.Spec.Type = desired.Spec.Type
+	klog.V(4).Infof("updating service %s/%s", service.Namespace, service.Name)
+	_, err = c.kubeclientset.CoreV1().Services(function.Namespace).Update(context.TODO(), service, metav1.UpdateOptions{})
+	if err == nil {
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessUpdated, MessageResourceUpdated, service.Name)
+	}
+	return err
+}
 
-	deployment, err := c.deploymentsLister.Deployments(function.Namespace).Get(function.Spec.FuncName)
+func (c *Controller) syncDeployment(function *uselessv1.Function) (*appsv1.Deployment, error) {
+	desired := function.Deployment(c.cfg.InterpreterImage)
+	deployment, err := c.deploymentsLister.Deployments(function.Namespace).Get(desired.Name)
 	if errors.IsNotFound(err) {
-		_, err = c.kubeclientset.AppsV1().Deployments(
-			function.Namespace).Create(function.Deployment())
-	} else if err == nil {
-		err = isOwner(deployment, function)
+		return c.kubeclientset.AppsV1().Deployments(function.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
 	}
 	if err != nil {
+		return nil, err
+	}
+	if err := c.isOwner(function, deployment); err != nil {
+		return nil, err
+	}
+	if !deploymentDrifted(desired, deployment) {
+		return deployment, nil
+	}
+
+	// The selector is immutable, and it never changes since it is derived
+	// from the name.
+	deployment = deployment.DeepCopy()
+	deployment.Spec.Replicas = desired.Spec.Replicas
+	deployment.Spec.Template = desired.Spec.Template
+	klog.V(4).Infof("updating deployment %s/%s", deployment.Namespace, deployment.Name)
+	deployment, err = c.kubeclientset.AppsV1().Deployments(function.Namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	if err == nil {
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessUpdated, MessageResourceUpdated, deployment.Name)
+	}
+	return deployment, err
+}
+
+// syncIngress syncs the Ingress of the function, or the shared Ingress of
+// the namespace, and removes the other one since the option may change.
+func (c *Controller) syncIngress(function *uselessv1.Function) error {
+	shared := c.cfg.Ingress != nil && c.cfg.Ingress.Shared
+	if err := c.syncSharedIngress(function.Namespace); err != nil {
 		return err
 	}
+	ingress, err := c.ingressesLister.Ingresses(function.Namespace).Get(function.Spec.FuncName)
+	if errors.IsNotFound(err) {
+		if c.cfg.Ingress == nil || shared {
+			return nil
+		}
+		_, err = c.kubeclientset.NetworkingV1().Ingresses(function.Namespace).Create(
+			context.TODO(), functionIngress(function, *c.cfg.Ingress), metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.isOwner(function, ingress); err != nil {
+		return err
+	}
+	if c.cfg.Ingress == nil || shared { // The Ingress has been turned off.
+		klog.V(4).Infof("deleting ingress %s/%s", ingress.Namespace, ingress.Name)
+		err = c.kubeclientset.NetworkingV1().Ingresses(function.Namespace).Delete(
+			context.TODO(), ingress.Name, metav1.DeleteOptions{})
+		if err == nil {
+			c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessDeleted, MessageResourceDeleted, ingress.Name)
+		}
+		return err
+	}
+	ingress, drifted := updatedIngress(functionIngress(function, *c.cfg.Ingress), ingress)
+	if !drifted {
+		return nil
+	}
+
+	klog.V(4).Infof("updating ingress %s/%s", ingress.Namespace, ingress.Name)
+	_, err = c.kubeclientset.NetworkingV1().Ingresses(function.Namespace).Update(
+		context.TODO(), ingress, metav1.UpdateOptions{})
+	if err == nil {
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessUpdated, MessageResourceUpdated, ingress.Name)
+	}
+	return err
+}
+
+// updatedIngress returns a copy of the Ingress updated to the desired one,
+// and whether it has drifted. The metadata is left untouched, it may be
+// managed by the other controllers. The IngressClassName is kept if the
+// desired one is absent, since the DefaultIngressClass admission sets it.
+func updatedIngress(desired, actual *networkingv1.Ingress) (*networkingv1.Ingress, bool) {
+	spec := desired.Spec.DeepCopy()
+	if spec.IngressClassName == nil {
+		spec.IngressClassName = actual.Spec.IngressClassName
+	}
+	if equality.Semantic.DeepEqual(*spec, actual.Spec) {
+		return actual, false
+	}
+
+	ingress := actual.DeepCopy()
+	ingress.Spec = *spec
+	return ingress, true
+}
 
+func (c *Controller) syncHorizontalPodAutoscaler(function *uselessv1.Function) error {
+	desired := function.HorizontalPodAutoscaler()
 	hpa, err := c.hpasLister.HorizontalPodAutoscalers(function.Namespace).Get(function.Spec.FuncName)
 	if errors.IsNotFound(err) {
-		_, err = c.kubeclientset.AutoscalingV1().HorizontalPodAutoscalers(
-			function.Namespace).Create(function.HorizontalPodAutoscaler())
-	} else if err == nil {
-		err = isOwner(hpa, function)
+		if desired == nil {
+			return nil
+		}
+		_, err = c.kubeclientset.AutoscalingV2().HorizontalPodAutoscalers(function.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.isOwner(function, hpa); err != nil {
+		return err
+	}
+	if desired == nil { // The autoscaling has been turned off.
+		klog.V(4).Infof("deleting hpa %s/%s", hpa.Namespace, hpa.Name)
+		err = c.kubeclientset.AutoscalingV2().HorizontalPodAutoscalers(function.Namespace).Delete(context.TODO(), hpa.Name, metav1.DeleteOptions{})
+		if err == nil {
+			c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessDeleted, MessageResourceDeleted, hpa.Name)
+		}
+		return err
+	}
+	if !hpaDrifted(desired, hpa) {
+		return nil
+	}
+
+	hpa = hpa.DeepCopy()
+	hpa.Spec = desired.Spec
+	klog.V(4).Infof("updating hpa %s/%s", hpa.Namespace, hpa.Name)
+	_, err = c.kubeclientset.AutoscalingV2().HorizontalPodAutoscalers(function.Namespace).Update(context.TODO(), hpa, metav1.UpdateOptions{})
+	if err == nil {
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessUpdated, MessageResourceUpdated, hpa.Name)
 	}
 	return err
 }
 
-func (c *Controller) updateFuncStatus(function *uselessv1.Function, deployment *appsv1.Deployment) error {
-	// XXX: Unused
+// syncTriggers creates or updates the CronJobs of the triggers, and deletes
+// the ones whose triggers have been removed.
+func (c *Controller) syncTriggers(function *uselessv1.Function) error {
+	desiredNames := map[string]bool{}
+	for _, desired := range function.CronJobs() {
+		desiredNames[desired.Name] = true
+		cronJob, err := c.cronJobsLister.CronJobs(function.Namespace).Get(desired.Name)
+		if errors.IsNotFound(err) {
+			_, err = c.kubeclientset.BatchV1().CronJobs(function.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.isOwner(function, cronJob); err != nil {
+			return err
+		}
+		// The labels are owned, the spec is partly defaulted.
+		if equality.Semantic.DeepEqual(desired.Labels, cronJob.Labels) &&
+			equality.Semantic.DeepDerivative(desired.Spec, cronJob.Spec) {
+			continue
+		}
+
+		cronJob = cronJob.DeepCopy()
+		cronJob.Labels = desired.Labels
+		cronJob.Spec = desired.Spec
+		klog.V(4).Infof("updating cronjob %s/%s", cronJob.Namespace, cronJob.Name)
+		if _, err := c.kubeclientset.BatchV1().CronJobs(function.Namespace).Update(context.TODO(), cronJob, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessUpdated, MessageResourceUpdated, cronJob.Name)
+	}
+
+	cronJobs, err := c.cronJobsLister.CronJobs(function.Namespace).List(
+		labels.SelectorFromSet(function.TriggerLabels()))
+	if err != nil {
+		return err
+	}
+	for _, cronJob := range cronJobs {
+		if desiredNames[cronJob.Name] || !metav1.IsControlledBy(cronJob, function) {
+			continue
+		}
+		klog.V(4).Infof("deleting cronjob %s/%s", cronJob.Namespace, cronJob.Name)
+		err := c.kubeclientset.BatchV1().CronJobs(function.Namespace).Delete(context.TODO(), cronJob.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessDeleted, MessageResourceDeleted, cronJob.Name)
+	}
 	return nil
 }
 
+func (c *Controller) updateFuncStatus(function *uselessv1.Function, build *uselessv1.BuildStatus,
+	deployment *appsv1.Deployment) error {
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	// You can use DeepCopy() to make a deep copy of original object and modify this copy
+	// Or create a copy manually for better performance
+	functionCopy := function.DeepCopy()
+	status := &functionCopy.Status
+	status.ObservedGeneration = function.Generation
+	status.URL = function.URL()
+	status.Build = build
+	if build != nil {
+		switch build.Phase {
+		case uselessv1.BuildSucceeded:
+			status.SetCondition(uselessv1.FunctionBuilt, corev1.ConditionTrue, string(build.Phase), "")
+		case uselessv1.BuildFailed:
+			status.SetCondition(uselessv1.FunctionBuilt, corev1.ConditionFalse, build.Reason, build.Message)
+		default:
+			status.SetCondition(uselessv1.FunctionBuilt, corev1.ConditionFalse, string(build.Phase),
+				fmt.Sprintf("see the logs of job %s", build.Job))
+		}
+	} else {
+		status.RemoveCondition(uselessv1.FunctionBuilt)
+	}
+	if deployment == nil {
+		status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionFalse, "NoImage",
+			"waiting for the first image to be built")
+		status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, "NotDeployed",
+			"waiting for the first image to be built")
+	} else {
+		setDeploymentStatus(function, status, deployment)
+	}
+
+	// Skip the update if nothing changed, otherwise the update event would
+	// bring us here again and again.
+	if equality.Semantic.DeepEqual(function.Status, functionCopy.Status) {
+		return nil
+	}
+	// If the CustomResourceSubresources feature gate is not enabled,
+	// we must use Update instead of UpdateStatus to update the Status block of the Function resource.
+	// UpdateStatus will not allow changes to the Spec of the resource,
+	// which is ideal for ensuring nothing other than resource status has been updated.
+	_, err := c.uselessclientset.UselessV1().Functions(function.Namespace).UpdateStatus(context.TODO(), functionCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// setDeploymentStatus sets the replicas and the conditions from the Deployment.
+func setDeploymentStatus(function *uselessv1.Function, status *uselessv1.FunctionStatus,
+	deployment *appsv1.Deployment) {
+	status.Replicas = deployment.Status.Replicas
+	status.Selector = metav1.FormatLabelSelector(deployment.Spec.Selector)
+	status.ReadyReplicas = deployment.Status.ReadyReplicas
+	status.AvailableReplicas = deployment.Status.AvailableReplicas
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	deployed := deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == replicas
+	if deployed {
+		status.Image = deployment.Spec.Template.Spec.Containers[0].Image
+		status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionTrue, "RolledOut", "")
+	} else {
+		status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionFalse, "RollingOut",
+			fmt.Sprintf("%d of %d replicas updated", deployment.Status.UpdatedReplicas, replicas))
+	}
+	if deployment.Status.ReadyReplicas == replicas {
+		status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionTrue, "AllReplicasReady", "")
+	} else {
+		status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionFalse, "ReplicasNotReady",
+			fmt.Sprintf("%d of %d replicas ready", deployment.Status.ReadyReplicas, replicas))
+	}
+	switch {
+	case !deployed:
+		status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, "NotDeployed",
+			"the latest spec has not been rolled out yet")
+	case deployment.Status.AvailableReplicas == 0:
+		if enabled, _ := function.ScaleToZero(); enabled {
+			reason := "Activating"
+			if replicas == 0 {
+				reason = "ScaledToZero"
+			}
+			// The requests are still accepted, but they are not served
+			// until a replica is available.
+			status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, reason,
+				"requests are buffered by the activator until replicas are available")
+		} else {
+			status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, "NoAvailableReplicas", "")
+		}
+	default:
+		status.SetCondition(uselessv1.FunctionReady, corev1.ConditionTrue, "Serving", "")
+	}
+}
+
 // enqueueFoo takes a Foo resource and converts it into a namespace/name
 // string which is then put onto the work queue. This method should *not* be
 // passed resources of any type other than Foo.
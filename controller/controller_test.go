@@ -0,0 +1,1128 @@
+// Code is copy and modified from:
+//   https://github.com/kubernetes/sample-controller
+
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/generated/clientset/versioned/fake"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions"
+)
+
+var (
+	alwaysReady        = func() bool { return true }
+	noResyncPeriodFunc = func() time.Duration { return 0 }
+)
+
+type fixture struct {
+	t *testing.T
+
+	client     *fake.Clientset
+	kubeclient *k8sfake.Clientset
+	// Objects to put in the store.
+	functionLister   []*uselessv1.Function
+	deploymentLister []*appsv1.Deployment
+	serviceLister    []*corev1.Service
+	hpaLister        []*autoscalingv2.HorizontalPodAutoscaler
+	endpointsLister  []*corev1.Endpoints
+	ingressLister    []*networkingv1.Ingress
+	cronJobLister    []*batchv1.CronJob
+	jobLister        []*batchv1.Job
+	configMapLister  []*corev1.ConfigMap
+	// ingress is the Ingress options of the controller.
+	ingress *IngressOptions
+	// build is the in-cluster build options of the controller.
+	build *BuildOptions
+	// Actions expected to happen on the client.
+	kubeactions []core.Action
+	actions     []core.Action
+	// Objects from here preloaded into NewSimpleFake.
+	kubeobjects []runtime.Object
+	objects     []runtime.Object
+}
+
+const activatorService = "useless/activator"
+
+func newFixture(t *testing.T) *fixture {
+	return &fixture{t: t}
+}
+
+func newFunction(name string, replicas *int32) *uselessv1.Function {
+	return &uselessv1.Function{
+		TypeMeta: metav1.TypeMeta{APIVersion: uselessv1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  metav1.NamespaceDefault,
+			Generation: 1,
+		},
+		Spec: uselessv1.FunctionSpec{
+			FuncName: name,
+			Image:    "useless/" + name + ":latest",
+			Replicas: replicas,
+		},
+	}
+}
+
+func (f *fixture) newController() (*Controller, informers.SharedInformerFactory, kubeinformers.SharedInformerFactory) {
+	f.client = fake.NewSimpleClientset(f.objects...)
+	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+
+	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
+
+	c := New(Config{ActivatorService: activatorService, Ingress: f.ingress, Build: f.build}, f.kubeclient, f.client,
+		k8sI.Apps().V1().Deployments(),
+		k8sI.Core().V1().Services(),
+		k8sI.Autoscaling().V2().HorizontalPodAutoscalers(),
+		k8sI.Core().V1().Endpoints(),
+		k8sI.Networking().V1().Ingresses(),
+		k8sI.Batch().V1().CronJobs(),
+		k8sI.Batch().V1().Jobs(),
+		k8sI.Core().V1().ConfigMaps(),
+		i.Useless().V1().Functions())
+
+	c.funcsSynced = alwaysReady
+	c.deploymentsSynced = alwaysReady
+	c.serviceSynced = alwaysReady
+	c.hpaSynced = alwaysReady
+	c.endpointsSynced = alwaysReady
+	c.ingressesSynced = alwaysReady
+	c.cronJobsSynced = alwaysReady
+	c.jobsSynced = alwaysReady
+	c.configMapsSynced = alwaysReady
+	c.recorder = &record.FakeRecorder{}
+
+	for _, f := range f.functionLister {
+		i.Useless().V1().Functions().Informer().GetIndexer().Add(f)
+	}
+	for _, d := range f.deploymentLister {
+		k8sI.Apps().V1().Deployments().Informer().GetIndexer().Add(d)
+	}
+	for _, s := range f.serviceLister {
+		k8sI.Core().V1().Services().Informer().GetIndexer().Add(s)
+	}
+	for _, h := range f.hpaLister {
+		k8sI.Autoscaling().V2().HorizontalPodAutoscalers().Informer().GetIndexer().Add(h)
+	}
+	for _, e := range f.endpointsLister {
+		k8sI.Core().V1().Endpoints().Informer().GetIndexer().Add(e)
+	}
+	for _, ing := range f.ingressLister {
+		k8sI.Networking().V1().Ingresses().Informer().GetIndexer().Add(ing)
+	}
+	for _, cj := range f.cronJobLister {
+		k8sI.Batch().V1().CronJobs().Informer().GetIndexer().Add(cj)
+	}
+	for _, j := range f.jobLister {
+		k8sI.Batch().V1().Jobs().Informer().GetIndexer().Add(j)
+	}
+	for _, cm := range f.configMapLister {
+		k8sI.Core().V1().ConfigMaps().Informer().GetIndexer().Add(cm)
+	}
+	return c, i, k8sI
+}
+
+func (f *fixture) run(functionName string) {
+	f.runController(functionName, true, false)
+}
+
+func (f *fixture) runExpectError(functionName string) {
+	f.runController(functionName, true, true)
+}
+
+func (f *fixture) runController(functionName string, startInformers bool, expectError bool) {
+	c, i, k8sI := f.newController()
+	if startInformers {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		i.Start(stopCh)
+		k8sI.Start(stopCh)
+	}
+
+	err := c.syncHandler(functionName)
+	if !expectError && err != nil {
+		f.t.Errorf("error syncing function: %v", err)
+	} else if expectError && err == nil {
+		f.t.Error("expected error syncing function, got nil")
+	}
+
+	actions := filterInformerActions(f.client.Actions())
+	for i, action := range actions {
+		if len(f.actions) < i+1 {
+			f.t.Errorf("%d unexpected actions: %+v", len(actions)-len(f.actions), actions[i:])
+			break
+		}
+		checkAction(f.actions[i], action, f.t)
+	}
+	if len(f.actions) > len(actions) {
+		f.t.Errorf("%d additional expected actions:%+v", len(f.actions)-len(actions), f.actions[len(actions):])
+	}
+
+	k8sActions := filterInformerActions(f.kubeclient.Actions())
+	for i, action := range k8sActions {
+		if len(f.kubeactions) < i+1 {
+			f.t.Errorf("%d unexpected actions: %+v", len(k8sActions)-len(f.kubeactions), k8sActions[i:])
+			break
+		}
+		checkAction(f.kubeactions[i], action, f.t)
+	}
+	if len(f.kubeactions) > len(k8sActions) {
+		f.t.Errorf("%d additional expected actions:%+v", len(f.kubeactions)-len(k8sActions), f.kubeactions[len(k8sActions):])
+	}
+}
+
+// checkAction verifies that expected and actual actions are equal and both
+// have same attached resources, the conditions of the functions are compared
+// without their transition times.
+func checkAction(expected, actual core.Action, t *testing.T) {
+	if !(expected.Matches(actual.GetVerb(), actual.GetResource().Resource) && actual.GetSubresource() == expected.GetSubresource()) {
+		t.Errorf("Expected\n\t%#v\ngot\n\t%#v", expected, actual)
+		return
+	}
+
+	if reflect.TypeOf(actual) != reflect.TypeOf(expected) {
+		t.Errorf("Action has wrong type. Expected: %t. Got: %t", expected, actual)
+		return
+	}
+
+	switch a := actual.(type) {
+	case core.CreateActionImpl:
+		e, _ := expected.(core.CreateActionImpl)
+		checkObject(t, a, e.GetObject(), a.GetObject())
+	case core.UpdateActionImpl:
+		e, _ := expected.(core.UpdateActionImpl)
+		checkObject(t, a, e.GetObject(), a.GetObject())
+	case core.DeleteActionImpl:
+		e, _ := expected.(core.DeleteActionImpl)
+		if e.GetName() != a.GetName() {
+			t.Errorf("Action %s %s has wrong name\nExpected:\n\t%s\ngot:\n\t%s",
+				a.GetVerb(), a.GetResource().Resource, e.GetName(), a.GetName())
+		}
+	case core.PatchActionImpl:
+		e, _ := expected.(core.PatchActionImpl)
+		if string(e.GetPatch()) != string(a.GetPatch()) {
+			t.Errorf("Action %s %s has wrong patch\nExpected:\n\t%s\ngot:\n\t%s",
+				a.GetVerb(), a.GetResource().Resource, e.GetPatch(), a.GetPatch())
+		}
+	default:
+		t.Errorf("Uncaptured Action %s %s, you should explicitly add a case to capture it",
+			actual.GetVerb(), actual.GetResource().Resource)
+	}
+}
+
+func checkObject(t *testing.T, action core.Action, expObject, object runtime.Object) {
+	if function, ok := object.(*uselessv1.Function); ok {
+		object = withoutTransitionTimes(function)
+		expObject = withoutTransitionTimes(expObject.(*uselessv1.Function))
+	}
+	if !equality.Semantic.DeepEqual(expObject, object) {
+		t.Errorf("Action %s %s has wrong object\nExpected:\n\t%#v\ngot:\n\t%#v",
+			action.GetVerb(), action.GetResource().Resource, expObject, object)
+	}
+}
+
+func withoutTransitionTimes(function *uselessv1.Function) *uselessv1.Function {
+	function = function.DeepCopy()
+	for i := range function.Status.Conditions {
+		function.Status.Conditions[i].LastTransitionTime = metav1.Time{}
+	}
+	return function
+}
+
+// filterInformerActions filters list and watch actions for testing resources.
+// Since list and watch don't change resource state we can filter it to lower
+// noise level in our tests.
+func filterInformerActions(actions []core.Action) []core.Action {
+	ret := []core.Action{}
+	for _, action := range actions {
+		if verb := action.GetVerb(); verb == "list" || verb == "watch" {
+			continue
+		}
+		ret = append(ret, action)
+	}
+	return ret
+}
+
+func resource(name string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Resource: name}
+}
+
+func (f *fixture) expectCreateAction(name string, namespace string, obj runtime.Object) {
+	f.kubeactions = append(f.kubeactions, core.NewCreateAction(resource(name), namespace, obj))
+}
+
+func (f *fixture) expectUpdateAction(name string, namespace string, obj runtime.Object) {
+	f.kubeactions = append(f.kubeactions, core.NewUpdateAction(resource(name), namespace, obj))
+}
+
+func (f *fixture) expectDeleteAction(name string, namespace string, objectName string) {
+	f.kubeactions = append(f.kubeactions, core.NewDeleteAction(resource(name), namespace, objectName))
+}
+
+func (f *fixture) expectUpdateFunctionStatusAction(function *uselessv1.Function) {
+	f.actions = append(f.actions, core.NewUpdateSubresourceAction(
+		schema.GroupVersionResource{Resource: "functions"}, "status", function.Namespace, function))
+}
+
+func getKey(function *uselessv1.Function, t *testing.T) string {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(function)
+	if err != nil {
+		t.Errorf("Unexpected error getting key for function %v: %v", function.Name, err)
+		return ""
+	}
+	return key
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+// rolledOut returns the Deployment of the function whose replicas have
+// been updated and ready replicas are the given ones.
+func rolledOut(function *uselessv1.Function, ready int32) *appsv1.Deployment {
+	deployment := function.Deployment("")
+	deployment.Generation = 1
+	deployment.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: 1,
+		Replicas:           *function.Spec.Replicas,
+		UpdatedReplicas:    *function.Spec.Replicas,
+		ReadyReplicas:      ready,
+		AvailableReplicas:  ready,
+	}
+	return deployment
+}
+
+func TestCreatesResources(t *testing.T) {
+	f := newFixture(t)
+	function := newFunction("test", int32Ptr(1))
+
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+
+	f.expectCreateAction("deployments", function.Namespace, function.Deployment(""))
+	f.expectCreateAction("services", function.Namespace, function.Service())
+	f.expectCreateAction("horizontalpodautoscalers", function.Namespace, function.HorizontalPodAutoscaler())
+	// The created Deployment has no status yet.
+	expected := function.DeepCopy()
+	expected.Status.ObservedGeneration = 1
+	expected.Status.Selector = metav1.FormatLabelSelector(function.Deployment("").Spec.Selector)
+	expected.Status.URL = function.URL()
+	expected.Status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionFalse, "RollingOut", "0 of 1 replicas updated")
+	expected.Status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionFalse, "ReplicasNotReady", "0 of 1 replicas ready")
+	expected.Status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, "NotDeployed",
+		"the latest spec has not been rolled out yet")
+	f.expectUpdateFunctionStatusAction(expected)
+
+	f.run(getKey(function, t))
+}
+
+func TestUpdatesStatus(t *testing.T) {
+	testCases := []struct {
+		name       string
+		deployment func(*uselessv1.Function) *appsv1.Deployment
+		status     func(*uselessv1.FunctionStatus)
+	}{
+		{
+			name: "serving",
+			deployment: func(function *uselessv1.Function) *appsv1.Deployment {
+				return rolledOut(function, 2)
+			},
+			status: func(status *uselessv1.FunctionStatus) {
+				status.Image = "useless/test:latest"
+				status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionTrue, "RolledOut", "")
+				status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionTrue, "AllReplicasReady", "")
+				status.SetCondition(uselessv1.FunctionReady, corev1.ConditionTrue, "Serving", "")
+			},
+		},
+		{
+			name: "no available replicas",
+			deployment: func(function *uselessv1.Function) *appsv1.Deployment {
+				return rolledOut(function, 0)
+			},
+			status: func(status *uselessv1.FunctionStatus) {
+				status.Image = "useless/test:latest"
+				status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionTrue, "RolledOut", "")
+				status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionFalse, "ReplicasNotReady", "0 of 2 replicas ready")
+				status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, "NoAvailableReplicas", "")
+			},
+		},
+		{
+			name: "rolling out",
+			deployment: func(function *uselessv1.Function) *appsv1.Deployment {
+				deployment := rolledOut(function, 2)
+				deployment.Generation = 2
+				return deployment
+			},
+			status: func(status *uselessv1.FunctionStatus) {
+				status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionFalse, "RollingOut", "2 of 2 replicas updated")
+				status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionTrue, "AllReplicasReady", "")
+				status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, "NotDeployed",
+					"the latest spec has not been rolled out yet")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			function := newFunction("test", int32Ptr(2))
+			deployment := tc.deployment(function)
+
+			f.functionLister = append(f.functionLister, function)
+			f.objects = append(f.objects, function)
+			f.serviceLister = append(f.serviceLister, function.Service())
+			f.deploymentLister = append(f.deploymentLister, deployment)
+			f.kubeobjects = append(f.kubeobjects, function.Service(), deployment)
+			f.expectCreateAction("horizontalpodautoscalers", function.Namespace, function.HorizontalPodAutoscaler())
+			expected := function.DeepCopy()
+			expected.Status.ObservedGeneration = 1
+			expected.Status.Replicas = deployment.Status.Replicas
+			expected.Status.Selector = metav1.FormatLabelSelector(function.Deployment("").Spec.Selector)
+			expected.Status.ReadyReplicas = deployment.Status.ReadyReplicas
+			expected.Status.AvailableReplicas = deployment.Status.AvailableReplicas
+			expected.Status.URL = function.URL()
+			tc.status(&expected.Status)
+			f.expectUpdateFunctionStatusAction(expected)
+
+			f.run(getKey(function, t))
+		})
+	}
+}
+
+func TestSkipsUnchangedStatus(t *testing.T) {
+	f := newFixture(t)
+	function := newFunction("test", int32Ptr(1))
+	deployment := rolledOut(function, 1)
+	function.Status = uselessv1.FunctionStatus{
+		ObservedGeneration: 1,
+		Replicas:           1,
+		Selector:           metav1.FormatLabelSelector(function.Deployment("").Spec.Selector),
+		ReadyReplicas:      1,
+		AvailableReplicas:  1,
+		URL:                function.URL(),
+		Image:              function.Spec.Image,
+	}
+	function.Status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionTrue, "RolledOut", "")
+	function.Status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionTrue, "AllReplicasReady", "")
+	function.Status.SetCondition(uselessv1.FunctionReady, corev1.ConditionTrue, "Serving", "")
+
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+	f.serviceLister = append(f.serviceLister, function.Service())
+	f.deploymentLister = append(f.deploymentLister, deployment)
+	f.kubeobjects = append(f.kubeobjects, function.Service(), deployment)
+	f.expectCreateAction("horizontalpodautoscalers", function.Namespace, function.HorizontalPodAutoscaler())
+
+	f.run(getKey(function, t))
+}
+
+func TestNotControlledByUs(t *testing.T) {
+	f := newFixture(t)
+	function := newFunction("test", int32Ptr(1))
+	service := function.Service()
+	service.OwnerReferences = nil
+	deployment := rolledOut(function, 1)
+
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+	f.serviceLister = append(f.serviceLister, service)
+	f.deploymentLister = append(f.deploymentLister, deployment)
+	f.kubeobjects = append(f.kubeobjects, service, deployment)
+
+	f.runExpectError(getKey(function, t))
+}
+
+func TestServiceDrifted(t *testing.T) {
+	function := newFunction("test", int32Ptr(1))
+	// The API server defaults the target port and allocates the cluster IP.
+	defaulted := function.Service()
+	defaulted.Spec.Ports[0].TargetPort = intstr.FromInt(80)
+	defaulted.Spec.ClusterIP = "10.0.0.1"
+	defaulted.Spec.SessionAffinity = corev1.ServiceAffinityNone
+
+	testCases := []struct {
+		name    string
+		edit    func(*corev1.Service)
+		drifted bool
+	}{
+		{name: "defaulted", edit: func(*corev1.Service) {}},
+		{name: "labels of others", edit: func(s *corev1.Service) { s.Labels = map[string]string{"team": "a"} }},
+		{name: "type", edit: func(s *corev1.Service) { s.Spec.Type = corev1.ServiceTypeNodePort }, drifted: true},
+		{name: "selector added", edit: func(s *corev1.Service) { s.Spec.Selector["version"] = "v2" }, drifted: true},
+		{name: "selector removed", edit: func(s *corev1.Service) { s.Spec.Selector = nil }, drifted: true},
+		{name: "port", edit: func(s *corev1.Service) { s.Spec.Ports[0].Port = 8080 }, drifted: true},
+		{
+			name: "port added",
+			edit: func(s *corev1.Service) {
+				s.Spec.Ports = append(s.Spec.Ports, corev1.ServicePort{Name: "debug", Port: 6060})
+			},
+			drifted: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := defaulted.DeepCopy()
+			tc.edit(actual)
+			if drifted := serviceDrifted(function.Service(), actual); drifted != tc.drifted {
+				t.Errorf("expected drifted: %v, got %v", tc.drifted, drifted)
+			}
+		})
+	}
+}
+
+func TestDeploymentDrifted(t *testing.T) {
+	function := newFunction("test", int32Ptr(2))
+	// The API server defaults the strategy, the pull policy and so on.
+	defaulted := function.Deployment("")
+	defaulted.Spec.Strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+	defaulted.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	defaulted.Spec.Template.Spec.Containers[0].ImagePullPolicy = corev1.PullIfNotPresent
+	defaulted.Spec.Template.Spec.Containers[0].TerminationMessagePath = corev1.TerminationMessagePathDefault
+	container := &defaulted.Spec.Template.Spec.Containers[0]
+	for _, probe := range []*corev1.Probe{container.StartupProbe, container.LivenessProbe, container.ReadinessProbe} {
+		probe.TimeoutSeconds = 1
+		probe.SuccessThreshold = 1
+		probe.HTTPGet.Scheme = corev1.URISchemeHTTP
+	}
+
+	testCases := []struct {
+		name    string
+		desired func(*appsv1.Deployment)
+		edit    func(*appsv1.Deployment)
+		drifted bool
+	}{
+		{name: "defaulted", edit: func(*appsv1.Deployment) {}},
+		{
+			name:    "replicas left to others",
+			desired: func(d *appsv1.Deployment) { d.Spec.Replicas = nil },
+			edit:    func(d *appsv1.Deployment) { d.Spec.Replicas = int32Ptr(5) },
+		},
+		{name: "replicas", edit: func(d *appsv1.Deployment) { d.Spec.Replicas = int32Ptr(5) }, drifted: true},
+		{
+			name:    "pod labels",
+			edit:    func(d *appsv1.Deployment) { d.Spec.Template.Labels["version"] = "v2" },
+			drifted: true,
+		},
+		{
+			name:    "image",
+			edit:    func(d *appsv1.Deployment) { d.Spec.Template.Spec.Containers[0].Image = "useless/test:v2" },
+			drifted: true,
+		},
+		{
+			name: "env added",
+			edit: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "DEBUG", Value: "1"}}
+			},
+			drifted: true,
+		},
+		{
+			name: "container added",
+			edit: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers,
+					corev1.Container{Name: "sidecar", Image: "sidecar"})
+			},
+			drifted: true,
+		},
+		{
+			name: "probe",
+			edit: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers[0].LivenessProbe.HTTPGet.Path = "/"
+			},
+			drifted: true,
+		},
+		{
+			name: "service account",
+			edit: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.ServiceAccountName = "admin"
+			},
+		},
+		{
+			name: "node selector of the desired",
+			desired: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.NodeSelector = map[string]string{"arch": "arm64"}
+			},
+			edit:    func(*appsv1.Deployment) {},
+			drifted: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			desired := function.Deployment("")
+			if tc.desired != nil {
+				tc.desired(desired)
+			}
+			actual := defaulted.DeepCopy()
+			tc.edit(actual)
+			if drifted := deploymentDrifted(desired, actual); drifted != tc.drifted {
+				t.Errorf("expected drifted: %v, got %v", tc.drifted, drifted)
+			}
+		})
+	}
+}
+
+func TestContainerDrifted(t *testing.T) {
+	desired := newFunction("test", int32Ptr(1)).Deployment("").Spec.Template.Spec.Containers[0]
+
+	testCases := []struct {
+		name    string
+		edit    func(*corev1.Container)
+		drifted bool
+	}{
+		{name: "same", edit: func(*corev1.Container) {}},
+		{name: "pull policy", edit: func(c *corev1.Container) { c.ImagePullPolicy = corev1.PullAlways }},
+		{name: "name", edit: func(c *corev1.Container) { c.Name = "other" }, drifted: true},
+		{name: "image", edit: func(c *corev1.Container) { c.Image = "other" }, drifted: true},
+		{name: "command", edit: func(c *corev1.Container) { c.Command = []string{"sh"} }, drifted: true},
+		{name: "args", edit: func(c *corev1.Container) { c.Args = []string{"-v"} }, drifted: true},
+		{
+			name: "resources",
+			edit: func(c *corev1.Container) {
+				c.Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: k8sresource.MustParse("1")}
+			},
+			drifted: true,
+		},
+		{
+			name: "port added",
+			edit: func(c *corev1.Container) {
+				c.Ports = append(c.Ports, corev1.ContainerPort{Name: "debug", ContainerPort: 6060})
+			},
+			drifted: true,
+		},
+		{name: "startup probe", edit: func(c *corev1.Container) { c.StartupProbe = nil }, drifted: true},
+		{name: "liveness probe", edit: func(c *corev1.Container) { c.LivenessProbe = nil }, drifted: true},
+		{name: "readiness probe", edit: func(c *corev1.Container) { c.ReadinessProbe = nil }, drifted: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := desired.DeepCopy()
+			tc.edit(actual)
+			if drifted := containerDrifted(&desired, actual); drifted != tc.drifted {
+				t.Errorf("expected drifted: %v, got %v", tc.drifted, drifted)
+			}
+		})
+	}
+}
+
+func TestWasmDeployment(t *testing.T) {
+	function := newFunction("test", int32Ptr(1))
+	function.Spec.Runtime = uselessv1.WasmRuntime
+	function.Spec.Sandbox = &uselessv1.SandboxSpec{MemoryLimitMiB: 16}
+	container := function.Deployment("").Spec.Template.Spec.Containers[0]
+	expectedArgs := []string{"-laddr=:80", "-drain-timeout=30s", "-memory-limit-mib=16", "-timeout=10s"}
+	if !reflect.DeepEqual(container.Args, expectedArgs) {
+		t.Errorf("expected args %v, got %v", expectedArgs, container.Args)
+	}
+	if !reflect.DeepEqual(container.Command, []string{"/app/function"}) {
+		t.Errorf("expected the function binary as the command, got %v", container.Command)
+	}
+
+	// The image of the other runtime is rebuilt.
+	native := newFunction("test", int32Ptr(1))
+	if native.SourceHash() == function.SourceHash() {
+		t.Error("expected another source hash for the wasm runtime")
+	}
+	native.Spec.Runtime = uselessv1.NativeRuntime
+	if native.SourceHash() != newFunction("test", int32Ptr(1)).SourceHash() {
+		t.Error("expected the same source hash for the default runtime")
+	}
+	if container := native.Deployment("").Spec.Template.Spec.Containers[0]; container.Command != nil || container.Args != nil {
+		t.Errorf("expected no command or args for the native runtime, got %v %v", container.Command, container.Args)
+	}
+}
+
+func TestRevertsManualEdits(t *testing.T) {
+	f := newFixture(t)
+	function := newFunction("test", int32Ptr(1))
+	function.Status.ObservedGeneration = 1
+	function.Status.URL = function.URL()
+	service := function.Service()
+	service.Spec.Type = corev1.ServiceTypeNodePort
+	deployment := rolledOut(function, 1)
+	deployment.Spec.Template.Spec.Containers[0].Image = "useless/test:debug"
+	hpa := function.HorizontalPodAutoscaler()
+	hpa.Spec.MaxReplicas = 100
+
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+	f.serviceLister = append(f.serviceLister, service)
+	f.deploymentLister = append(f.deploymentLister, deployment)
+	f.hpaLister = append(f.hpaLister, hpa)
+	f.kubeobjects = append(f.kubeobjects, service, deployment, hpa)
+
+	expectedDeployment := deployment.DeepCopy()
+	expectedDeployment.Spec.Template = function.Deployment("").Spec.Template
+	f.expectUpdateAction("deployments", function.Namespace, expectedDeployment)
+	f.expectUpdateAction("services", function.Namespace, function.Service())
+	f.expectUpdateAction("horizontalpodautoscalers", function.Namespace, function.HorizontalPodAutoscaler())
+	expected := function.DeepCopy()
+	expected.Status.Replicas = 1
+	expected.Status.Selector = metav1.FormatLabelSelector(function.Deployment("").Spec.Selector)
+	expected.Status.ReadyReplicas = 1
+	expected.Status.AvailableReplicas = 1
+	expected.Status.Image = function.Spec.Image
+	expected.Status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionTrue, "RolledOut", "")
+	expected.Status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionTrue, "AllReplicasReady", "")
+	expected.Status.SetCondition(uselessv1.FunctionReady, corev1.ConditionTrue, "Serving", "")
+	f.expectUpdateFunctionStatusAction(expected)
+
+	f.run(getKey(function, t))
+}
+
+func TestHPADrifted(t *testing.T) {
+	window := int32(30)
+	function := newFunction("test", int32Ptr(1))
+	function.Spec.Autoscaling = &uselessv1.AutoscalingSpec{
+		Behavior: &autoscalingv2.HorizontalPodAutoscalerBehavior{
+			ScaleUp: &autoscalingv2.HPAScalingRules{StabilizationWindowSeconds: &window},
+		},
+	}
+	// The API server defaults the rest of the behavior.
+	maxPolicy := autoscalingv2.MaxChangePolicySelect
+	defaulted := function.HorizontalPodAutoscaler()
+	defaulted.Spec.Behavior.ScaleUp.SelectPolicy = &maxPolicy
+	defaulted.Spec.Behavior.ScaleUp.Policies = []autoscalingv2.HPAScalingPolicy{
+		{Type: autoscalingv2.PodsScalingPolicy, Value: 4, PeriodSeconds: 15},
+		{Type: autoscalingv2.PercentScalingPolicy, Value: 100, PeriodSeconds: 15},
+	}
+	defaulted.Spec.Behavior.ScaleDown = &autoscalingv2.HPAScalingRules{
+		SelectPolicy: &maxPolicy,
+		Policies: []autoscalingv2.HPAScalingPolicy{
+			{Type: autoscalingv2.PercentScalingPolicy, Value: 100, PeriodSeconds: 15},
+		},
+	}
+
+	testCases := []struct {
+		name    string
+		edit    func(*autoscalingv2.HorizontalPodAutoscaler)
+		drifted bool
+	}{
+		{name: "defaulted", edit: func(*autoscalingv2.HorizontalPodAutoscaler) {}},
+		{name: "min replicas", edit: func(h *autoscalingv2.HorizontalPodAutoscaler) { h.Spec.MinReplicas = int32Ptr(2) }, drifted: true},
+		{name: "max replicas", edit: func(h *autoscalingv2.HorizontalPodAutoscaler) { h.Spec.MaxReplicas = 100 }, drifted: true},
+		{
+			name:    "target",
+			edit:    func(h *autoscalingv2.HorizontalPodAutoscaler) { h.Spec.ScaleTargetRef.Name = "other" },
+			drifted: true,
+		},
+		{
+			name: "metrics",
+			edit: func(h *autoscalingv2.HorizontalPodAutoscaler) {
+				h.Spec.Metrics[0].Resource.Target.AverageUtilization = int32Ptr(90)
+			},
+			drifted: true,
+		},
+		{
+			name: "stabilization window",
+			edit: func(h *autoscalingv2.HorizontalPodAutoscaler) {
+				h.Spec.Behavior.ScaleUp.StabilizationWindowSeconds = int32Ptr(0)
+			},
+			drifted: true,
+		},
+		{name: "behavior removed", edit: func(h *autoscalingv2.HorizontalPodAutoscaler) { h.Spec.Behavior = nil }, drifted: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := defaulted.DeepCopy()
+			tc.edit(actual)
+			if drifted := hpaDrifted(function.HorizontalPodAutoscaler(), actual); drifted != tc.drifted {
+				t.Errorf("expected drifted: %v, got %v", tc.drifted, drifted)
+			}
+		})
+	}
+}
+
+func TestDeletesHPAOnceAutoscalingDisabled(t *testing.T) {
+	f := newFixture(t)
+	function := newFunction("test", int32Ptr(3))
+	hpa := function.HorizontalPodAutoscaler()
+	function.Spec.Autoscaling = &uselessv1.AutoscalingSpec{Disabled: true}
+	function.Status.ObservedGeneration = 1
+	deployment := rolledOut(function, 3)
+
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+	f.serviceLister = append(f.serviceLister, function.Service())
+	f.deploymentLister = append(f.deploymentLister, deployment)
+	f.hpaLister = append(f.hpaLister, hpa)
+	f.kubeobjects = append(f.kubeobjects, function.Service(), deployment, hpa)
+
+	f.expectDeleteAction("horizontalpodautoscalers", function.Namespace, hpa.Name)
+	expected := function.DeepCopy()
+	expected.Status.Replicas = 3
+	expected.Status.Selector = metav1.FormatLabelSelector(function.Deployment("").Spec.Selector)
+	expected.Status.ReadyReplicas = 3
+	expected.Status.AvailableReplicas = 3
+	expected.Status.URL = function.URL()
+	expected.Status.Image = function.Spec.Image
+	expected.Status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionTrue, "RolledOut", "")
+	expected.Status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionTrue, "AllReplicasReady", "")
+	expected.Status.SetCondition(uselessv1.FunctionReady, corev1.ConditionTrue, "Serving", "")
+	f.expectUpdateFunctionStatusAction(expected)
+
+	f.run(getKey(function, t))
+}
+
+func TestRejectsInvalidReplicasRange(t *testing.T) {
+	testCases := []struct {
+		name        string
+		autoscaling *uselessv1.AutoscalingSpec
+	}{
+		{name: "min over max", autoscaling: &uselessv1.AutoscalingSpec{MinReplicas: int32Ptr(5), MaxReplicas: 3}},
+		{name: "min over default max", autoscaling: &uselessv1.AutoscalingSpec{MinReplicas: int32Ptr(20)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			function := newFunction("test", int32Ptr(1))
+			function.Spec.Autoscaling = tc.autoscaling
+			f.functionLister = append(f.functionLister, function)
+			f.objects = append(f.objects, function)
+
+			// Nothing is created and the error is not retried.
+			f.run(getKey(function, t))
+		})
+	}
+}
+
+func TestRejectsLongCronJobName(t *testing.T) {
+	f := newFixture(t)
+	function := newFunction("test", int32Ptr(1))
+	function.Spec.Triggers = []uselessv1.FunctionTrigger{
+		{Name: strings.Repeat("a", uselessv1.MaxCronJobNameLength-len("test")), Schedule: "@hourly"},
+	}
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+
+	// Nothing is created and the error is not retried.
+	f.run(getKey(function, t))
+}
+
+func TestSyncTriggers(t *testing.T) {
+	function := newFunction("test", int32Ptr(1))
+	function.Spec.Triggers = []uselessv1.FunctionTrigger{{Name: "hourly", Schedule: "@hourly", Input: "{}"}}
+	withoutTriggers := function.DeepCopy()
+	withoutTriggers.Spec.Triggers = nil
+	desired := function.CronJobs()[0]
+
+	defaulted := desired.DeepCopy()
+	defaulted.Spec.Suspend = new(bool)
+	defaulted.Spec.JobTemplate.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+	relabeled := desired.DeepCopy()
+	relabeled.Labels = map[string]string{"useless": "trigger"}
+	rescheduled := desired.DeepCopy()
+	rescheduled.Spec.Schedule = "@daily"
+	notOurs := desired.DeepCopy()
+	notOurs.OwnerReferences = nil
+
+	testCases := []struct {
+		name        string
+		function    *uselessv1.Function
+		cronJobs    []*batchv1.CronJob
+		expected    func(f *fixture)
+		expectError bool
+	}{
+		{
+			name:     "created",
+			function: function,
+			expected: func(f *fixture) {
+				f.expectCreateAction("cronjobs", function.Namespace, desired)
+			},
+		},
+		{name: "unchanged", function: function, cronJobs: []*batchv1.CronJob{desired}, expected: func(*fixture) {}},
+		{name: "defaulted", function: function, cronJobs: []*batchv1.CronJob{defaulted}, expected: func(*fixture) {}},
+		{
+			name:     "labels drifted",
+			function: function,
+			cronJobs: []*batchv1.CronJob{relabeled},
+			expected: func(f *fixture) {
+				f.expectUpdateAction("cronjobs", function.Namespace, desired)
+			},
+		},
+		{
+			name:     "schedule drifted",
+			function: function,
+			cronJobs: []*batchv1.CronJob{rescheduled},
+			expected: func(f *fixture) {
+				f.expectUpdateAction("cronjobs", function.Namespace, desired)
+			},
+		},
+		{
+			name:     "deleted once removed",
+			function: withoutTriggers,
+			cronJobs: []*batchv1.CronJob{desired},
+			expected: func(f *fixture) {
+				f.expectDeleteAction("cronjobs", function.Namespace, desired.Name)
+			},
+		},
+		{
+			name:        "not ours",
+			function:    function,
+			cronJobs:    []*batchv1.CronJob{notOurs},
+			expected:    func(*fixture) {},
+			expectError: true,
+		},
+		{name: "not ours kept", function: withoutTriggers, cronJobs: []*batchv1.CronJob{notOurs}, expected: func(*fixture) {}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			for _, cronJob := range tc.cronJobs {
+				f.cronJobLister = append(f.cronJobLister, cronJob)
+				f.kubeobjects = append(f.kubeobjects, cronJob)
+			}
+			tc.expected(f)
+
+			c, _, _ := f.newController()
+			err := c.syncTriggers(tc.function)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error: %v, got %v", tc.expectError, err)
+			}
+			actions := filterInformerActions(f.kubeclient.Actions())
+			if len(actions) != len(f.kubeactions) {
+				t.Fatalf("expected actions %+v, got %+v", f.kubeactions, actions)
+			}
+			for i := range actions {
+				checkAction(f.kubeactions[i], actions[i], t)
+			}
+		})
+	}
+}
+
+// newScaleToZeroFunction returns a function which can be scaled to zero.
+func newScaleToZeroFunction(name string, replicas *int32) *uselessv1.Function {
+	function := newFunction(name, replicas)
+	function.Spec.Autoscaling = &uselessv1.AutoscalingSpec{
+		Class:       uselessv1.ConcurrencyAutoscaling,
+		MinReplicas: int32Ptr(0),
+		ScaleToZero: &uselessv1.ScaleToZeroSpec{},
+	}
+	return function
+}
+
+func newActivatorEndpoints(ips ...string) *corev1.Endpoints {
+	addresses := []corev1.EndpointAddress{}
+	for _, ip := range ips {
+		addresses = append(addresses, corev1.EndpointAddress{IP: ip})
+	}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "useless", Name: "activator"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: addresses,
+			Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}},
+		}},
+	}
+}
+
+// proxiedEndpoints returns the Endpoints of the function which route to the
+// activator.
+func proxiedEndpoints(function *uselessv1.Function, ips ...string) *corev1.Endpoints {
+	addresses := []corev1.EndpointAddress{}
+	for _, ip := range ips {
+		addresses = append(addresses, corev1.EndpointAddress{IP: ip})
+	}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: function.Namespace,
+			Name:      function.Spec.FuncName,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, uselessv1.SchemeGroupVersion.WithKind("Function")),
+			},
+		},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: addresses,
+			Ports: []corev1.EndpointPort{{
+				Name:     function.Spec.FuncName,
+				Port:     8080,
+				Protocol: corev1.ProtocolTCP,
+			}},
+		}},
+	}
+}
+
+func TestRoutesScaledToZeroToActivator(t *testing.T) {
+	f := newFixture(t)
+	function := newScaleToZeroFunction("test", int32Ptr(0))
+	function.Status.ObservedGeneration = 1
+	deployment := rolledOut(function, 0)
+	activator := newActivatorEndpoints("10.0.0.1")
+
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+	f.serviceLister = append(f.serviceLister, function.Service())
+	f.deploymentLister = append(f.deploymentLister, deployment)
+	f.endpointsLister = append(f.endpointsLister, activator)
+	f.kubeobjects = append(f.kubeobjects, function.Service(), deployment, activator)
+
+	service := function.Service()
+	service.Spec.Selector = nil
+	f.expectUpdateAction("services", function.Namespace, service)
+	f.expectCreateAction("endpoints", function.Namespace, proxiedEndpoints(function, "10.0.0.1"))
+	expected := function.DeepCopy()
+	expected.Status.Selector = metav1.FormatLabelSelector(function.Deployment("").Spec.Selector)
+	expected.Status.URL = function.URL()
+	expected.Status.Image = function.Spec.Image
+	expected.Status.SetCondition(uselessv1.FunctionDeployed, corev1.ConditionTrue, "RolledOut", "")
+	expected.Status.SetCondition(uselessv1.FunctionScaled, corev1.ConditionTrue, "AllReplicasReady", "")
+	expected.Status.SetCondition(uselessv1.FunctionReady, corev1.ConditionFalse, "ScaledToZero",
+		"requests are buffered by the activator until replicas are available")
+	f.expectUpdateFunctionStatusAction(expected)
+
+	f.run(getKey(function, t))
+}
+
+func TestSyncProxiedEndpoints(t *testing.T) {
+	function := newScaleToZeroFunction("test", int32Ptr(0))
+	// The endpoints controller creates the Endpoints without owner while
+	// the Service has a selector.
+	unowned := proxiedEndpoints(function, "10.0.0.2")
+	unowned.OwnerReferences = nil
+	ownedByOthers := proxiedEndpoints(function, "10.0.0.2")
+	ownedByOthers.OwnerReferences[0].Name = "other"
+	ownedByOthers.OwnerReferences[0].UID = "other"
+
+	testCases := []struct {
+		name        string
+		activator   *corev1.Endpoints
+		endpoints   *corev1.Endpoints
+		expected    func(f *fixture)
+		expectError bool
+	}{
+		{
+			name:      "created",
+			activator: newActivatorEndpoints("10.0.0.1", "10.0.0.2"),
+			expected: func(f *fixture) {
+				f.expectCreateAction("endpoints", function.Namespace, proxiedEndpoints(function, "10.0.0.1", "10.0.0.2"))
+			},
+		},
+		{
+			name:      "adopted",
+			activator: newActivatorEndpoints("10.0.0.1"),
+			endpoints: unowned,
+			expected: func(f *fixture) {
+				f.expectUpdateAction("endpoints", function.Namespace, proxiedEndpoints(function, "10.0.0.1"))
+			},
+		},
+		{
+			name:      "activator moved",
+			activator: newActivatorEndpoints("10.0.0.1"),
+			endpoints: proxiedEndpoints(function, "10.0.0.2"),
+			expected: func(f *fixture) {
+				f.expectUpdateAction("endpoints", function.Namespace, proxiedEndpoints(function, "10.0.0.1"))
+			},
+		},
+		{
+			name:      "unchanged",
+			activator: newActivatorEndpoints("10.0.0.2"),
+			endpoints: proxiedEndpoints(function, "10.0.0.2"),
+			expected:  func(*fixture) {},
+		},
+		{
+			name:        "owned by others",
+			activator:   newActivatorEndpoints("10.0.0.1"),
+			endpoints:   ownedByOthers,
+			expected:    func(*fixture) {},
+			expectError: true,
+		},
+		{
+			name:        "no activator",
+			expected:    func(*fixture) {},
+			expectError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			for _, endpoints := range []*corev1.Endpoints{tc.activator, tc.endpoints} {
+				if endpoints != nil {
+					f.endpointsLister = append(f.endpointsLister, endpoints)
+					f.kubeobjects = append(f.kubeobjects, endpoints)
+				}
+			}
+			tc.expected(f)
+
+			c, _, _ := f.newController()
+			err := c.syncProxiedEndpoints(function)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error: %v, got %v", tc.expectError, err)
+			}
+			actions := filterInformerActions(f.kubeclient.Actions())
+			if len(actions) != len(f.kubeactions) {
+				t.Fatalf("expected actions %+v, got %+v", f.kubeactions, actions)
+			}
+			for i := range actions {
+				checkAction(f.kubeactions[i], actions[i], t)
+			}
+		})
+	}
+}
+
+func TestHandleActivatorEndpoints(t *testing.T) {
+	scaleToZero := newScaleToZeroFunction("zero", int32Ptr(0))
+	other := newFunction("other", int32Ptr(1))
+
+	testCases := []struct {
+		name     string
+		obj      interface{}
+		expected []string
+	}{
+		{
+			name:     "activator",
+			obj:      newActivatorEndpoints("10.0.0.1"),
+			expected: []string{getKey(scaleToZero, t)},
+		},
+		{
+			name:     "activator deleted",
+			obj:      cache.DeletedFinalStateUnknown{Key: activatorService, Obj: newActivatorEndpoints()},
+			expected: []string{getKey(scaleToZero, t)},
+		},
+		{
+			name:     "endpoints of a function",
+			obj:      proxiedEndpoints(other, "10.0.0.1"),
+			expected: []string{getKey(other, t)},
+		},
+		{
+			name: "endpoints of others",
+			obj: &corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "kubernetes"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.functionLister = append(f.functionLister, scaleToZero, other)
+			c, _, _ := f.newController()
+
+			c.handleActivatorEndpoints(tc.obj)
+			keys := []string{}
+			for c.workqueue.Len() > 0 {
+				key, _ := c.workqueue.Get()
+				keys = append(keys, key.(string))
+				c.workqueue.Done(key)
+			}
+			if len(keys) != len(tc.expected) || (len(keys) > 0 && !reflect.DeepEqual(keys, tc.expected)) {
+				t.Errorf("expected enqueued %v, got %v", tc.expected, keys)
+			}
+		})
+	}
+}
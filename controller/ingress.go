@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+// IngressOptions configures the Ingress of the functions.
+type IngressOptions struct {
+	// RootDomain is the root domain of the external names.
+	RootDomain string
+	// Class is the IngressClass of the Ingress, the default IngressClass of
+	// the cluster is used if it is empty.
+	Class string
+	// TLSSecret is the name of the TLS secret in the namespace of the
+	// function, TLS is disabled if it is empty.
+	TLSSecret string
+	// Shared puts the rules of all the functions in a namespace into one
+	// Ingress named sharedIngressName, instead of one Ingress per function.
+	Shared bool
+}
+
+// sharedIngressName is the name of the Ingress shared by the functions in a
+// namespace.
+const sharedIngressName = "useless-functions"
+
+// sharedIngressLabels returns the labels of the shared Ingress, which tell
+// that it is managed by us since it is not owned by any function.
+func sharedIngressLabels() map[string]string {
+	return map[string]string{
+		"useless": "ingress",
+	}
+}
+
+// functionIngress exposes the function as its external name.
+func functionIngress(f *uselessv1.Function, opts IngressOptions) *networkingv1.Ingress {
+	ingress := newIngress(f.Namespace, f.Spec.FuncName, opts, []*uselessv1.Function{f})
+	ingress.OwnerReferences = []metav1.OwnerReference{
+		*metav1.NewControllerRef(f, uselessv1.SchemeGroupVersion.WithKind("Function")),
+	}
+	return ingress
+}
+
+// sharedIngress exposes the functions in the namespace as their external
+// names by one Ingress, the rules are sorted by the function names.
+func sharedIngress(namespace string, functions []*uselessv1.Function, opts IngressOptions) *networkingv1.Ingress {
+	sorted := make([]*uselessv1.Function, len(functions))
+	copy(sorted, functions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Spec.FuncName < sorted[j].Spec.FuncName
+	})
+	ingress := newIngress(namespace, sharedIngressName, opts, sorted)
+	ingress.Labels = sharedIngressLabels()
+	return ingress
+}
+
+// newIngress routes the hosts of the functions to their Services. The whole
+// host belongs to the function, the supervisor serves the invocations on "/"
+// and there is no portable way to strip a path prefix.
+func newIngress(namespace, name string, opts IngressOptions, functions []*uselessv1.Function) *networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	if opts.Class != "" {
+		class := opts.Class
+		ingress.Spec.IngressClassName = &class
+	}
+	hosts := make([]string, 0, len(functions))
+	for _, f := range functions {
+		host := f.ExternalName(opts.RootDomain)
+		hosts = append(hosts, host)
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     "/",
+						PathType: &pathType,
+						Backend: networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: f.Spec.FuncName,
+								Port: networkingv1.ServiceBackendPort{Number: uselessv1.FunctionPort},
+							},
+						},
+					}},
+				},
+			},
+		})
+	}
+	if opts.TLSSecret != "" {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{
+			Hosts:      hosts,
+			SecretName: opts.TLSSecret,
+		}}
+	}
+	return ingress
+}
+
+// syncSharedIngress syncs the Ingress shared by the functions in the
+// namespace, it is rebuilt from all the functions since it is not owned by
+// any of them, and it is deleted once no function is left or the shared
+// Ingress has been turned off.
+func (c *Controller) syncSharedIngress(namespace string) error {
+	var functions []*uselessv1.Function
+	if c.cfg.Ingress != nil && c.cfg.Ingress.Shared {
+		all, err := c.funcsLister.Functions(namespace).List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		for _, function := range all {
+			if function.Spec.FuncName != "" && function.DeletionTimestamp == nil {
+				functions = append(functions, function)
+			}
+		}
+	}
+
+	client := c.kubeclientset.NetworkingV1().Ingresses(namespace)
+	ingress, err := c.ingressesLister.Ingresses(namespace).Get(sharedIngressName)
+	if errors.IsNotFound(err) {
+		if len(functions) == 0 {
+			return nil
+		}
+		_, err = client.Create(context.TODO(),
+			sharedIngress(namespace, functions, *c.cfg.Ingress), metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !isSharedIngress(ingress) {
+		if len(functions) == 0 { // Not ours, and not needed.
+			return nil
+		}
+		return fmt.Errorf(MessageResourceExists, ingress.Name)
+	}
+	if len(functions) == 0 {
+		klog.V(4).Infof("deleting shared ingress %s/%s", ingress.Namespace, ingress.Name)
+		err = client.Delete(context.TODO(), ingress.Name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	ingress, drifted := updatedIngress(sharedIngress(namespace, functions, *c.cfg.Ingress), ingress)
+	if !drifted {
+		return nil
+	}
+
+	klog.V(4).Infof("updating shared ingress %s/%s", ingress.Namespace, ingress.Name)
+	_, err = client.Update(context.TODO(), ingress, metav1.UpdateOptions{})
+	return err
+}
+
+func isSharedIngress(ingress *networkingv1.Ingress) bool {
+	selector := labels.SelectorFromSet(sharedIngressLabels())
+	return metav1.GetControllerOf(ingress) == nil && selector.Matches(labels.Set(ingress.Labels))
+}
+
+// handleIngress enqueues a function of the namespace if the shared Ingress
+// changes, so that the manual changes are reverted, the Ingress of the
+// functions are handled by handleObject.
+func (c *Controller) handleIngress(obj interface{}) {
+	ingress, ok := obj.(*networkingv1.Ingress)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		if ingress, ok = tombstone.Obj.(*networkingv1.Ingress); !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+	if ingress.Name != sharedIngressName || !isSharedIngress(ingress) {
+		c.handleObject(obj)
+		return
+	}
+	functions, err := c.funcsLister.Functions(ingress.Namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	// Any of the functions syncs the shared Ingress.
+	if len(functions) > 0 {
+		c.enqueueFunc(functions[0])
+	}
+}
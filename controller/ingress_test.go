@@ -0,0 +1,315 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+func TestUpdatedIngress(t *testing.T) {
+	function := newFunction("test", int32Ptr(1))
+	opts := IngressOptions{RootDomain: "fn.example.com", TLSSecret: "tls"}
+	withClass := opts
+	withClass.Class = "nginx"
+
+	testCases := []struct {
+		name     string
+		opts     IngressOptions
+		edit     func(*networkingv1.Ingress)
+		expected func(*networkingv1.Ingress)
+	}{
+		{name: "unchanged", opts: withClass, edit: func(*networkingv1.Ingress) {}},
+		{
+			name: "default class",
+			opts: opts,
+			edit: func(ing *networkingv1.Ingress) {
+				class := "default"
+				ing.Spec.IngressClassName = &class
+			},
+		},
+		{
+			name: "class changed",
+			opts: withClass,
+			edit: func(ing *networkingv1.Ingress) {
+				class := "traefik"
+				ing.Spec.IngressClassName = &class
+			},
+			expected: func(*networkingv1.Ingress) {},
+		},
+		{
+			name: "annotations kept",
+			opts: withClass,
+			edit: func(ing *networkingv1.Ingress) {
+				ing.Annotations = map[string]string{"nginx.ingress.kubernetes.io/ssl-redirect": "false"}
+				ing.Spec.Rules[0].Host = "test.example.com"
+			},
+			expected: func(ing *networkingv1.Ingress) {
+				ing.Annotations = map[string]string{"nginx.ingress.kubernetes.io/ssl-redirect": "false"}
+			},
+		},
+		{
+			name: "path type",
+			opts: withClass,
+			edit: func(ing *networkingv1.Ingress) {
+				pathType := networkingv1.PathTypeImplementationSpecific
+				ing.Spec.Rules[0].HTTP.Paths[0].PathType = &pathType
+			},
+			expected: func(*networkingv1.Ingress) {},
+		},
+		{
+			name:     "tls removed",
+			opts:     withClass,
+			edit:     func(ing *networkingv1.Ingress) { ing.Spec.TLS = nil },
+			expected: func(*networkingv1.Ingress) {},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := functionIngress(function, tc.opts)
+			tc.edit(actual)
+			updated, drifted := updatedIngress(functionIngress(function, tc.opts), actual)
+			if drifted != (tc.expected != nil) {
+				t.Fatalf("expected drifted: %v, got %v", tc.expected != nil, drifted)
+			}
+			if !drifted {
+				if updated != actual {
+					t.Error("expected the actual Ingress if it has not drifted")
+				}
+				return
+			}
+			expected := functionIngress(function, tc.opts)
+			tc.expected(expected)
+			if !reflect.DeepEqual(expected, updated) {
+				t.Errorf("expected %+v, got %+v", expected, updated)
+			}
+		})
+	}
+}
+
+func TestSyncIngress(t *testing.T) {
+	function := newFunction("test", int32Ptr(1))
+	other := newFunction("other", int32Ptr(1))
+	opts := &IngressOptions{RootDomain: "fn.example.com", Class: "nginx"}
+	shared := &IngressOptions{RootDomain: "fn.example.com", Shared: true}
+	drifted := functionIngress(function, *opts)
+	drifted.Spec.Rules[0].Host = "test.example.com"
+	notOurs := sharedIngress(function.Namespace, []*uselessv1.Function{function}, *shared)
+	notOurs.Labels = nil
+
+	testCases := []struct {
+		name        string
+		opts        *IngressOptions
+		ingresses   []*networkingv1.Ingress
+		expected    func(f *fixture)
+		expectError bool
+	}{
+		{
+			name: "created",
+			opts: opts,
+			expected: func(f *fixture) {
+				f.expectCreateAction("ingresses", function.Namespace, functionIngress(function, *opts))
+			},
+		},
+		{
+			name:      "unchanged",
+			opts:      opts,
+			ingresses: []*networkingv1.Ingress{functionIngress(function, *opts)},
+			expected:  func(*fixture) {},
+		},
+		{
+			name:      "updated",
+			opts:      opts,
+			ingresses: []*networkingv1.Ingress{drifted},
+			expected: func(f *fixture) {
+				f.expectUpdateAction("ingresses", function.Namespace, functionIngress(function, *opts))
+			},
+		},
+		{
+			name:      "deleted once disabled",
+			ingresses: []*networkingv1.Ingress{functionIngress(function, *opts)},
+			expected: func(f *fixture) {
+				f.expectDeleteAction("ingresses", function.Namespace, function.Spec.FuncName)
+			},
+		},
+		{
+			name:      "shared",
+			opts:      shared,
+			ingresses: []*networkingv1.Ingress{functionIngress(function, *opts)},
+			expected: func(f *fixture) {
+				f.expectCreateAction("ingresses", function.Namespace,
+					sharedIngress(function.Namespace, []*uselessv1.Function{other, function}, *shared))
+				f.expectDeleteAction("ingresses", function.Namespace, function.Spec.FuncName)
+			},
+		},
+		{
+			name:        "shared but not ours",
+			opts:        shared,
+			ingresses:   []*networkingv1.Ingress{notOurs},
+			expected:    func(*fixture) {},
+			expectError: true,
+		},
+		{
+			name: "shared deleted once disabled",
+			ingresses: []*networkingv1.Ingress{
+				sharedIngress(function.Namespace, []*uselessv1.Function{function}, *shared),
+			},
+			expected: func(f *fixture) {
+				f.expectDeleteAction("ingresses", function.Namespace, sharedIngressName)
+			},
+		},
+		{
+			name:      "unused name of the shared one",
+			ingresses: []*networkingv1.Ingress{notOurs},
+			expected:  func(*fixture) {},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.ingress = tc.opts
+			f.functionLister = append(f.functionLister, function, other)
+			for _, ingress := range tc.ingresses {
+				f.ingressLister = append(f.ingressLister, ingress)
+				f.kubeobjects = append(f.kubeobjects, ingress)
+			}
+			tc.expected(f)
+
+			c, _, _ := f.newController()
+			err := c.syncIngress(function)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error: %v, got %v", tc.expectError, err)
+			}
+			actions := filterInformerActions(f.kubeclient.Actions())
+			if len(actions) != len(f.kubeactions) {
+				t.Fatalf("expected actions %+v, got %+v", f.kubeactions, actions)
+			}
+			for i := range actions {
+				checkAction(f.kubeactions[i], actions[i], t)
+			}
+		})
+	}
+}
+
+func TestHandleIngress(t *testing.T) {
+	function := newFunction("test", int32Ptr(1))
+	opts := IngressOptions{RootDomain: "fn.example.com", Shared: true}
+	notOurs := sharedIngress(function.Namespace, []*uselessv1.Function{function}, opts)
+	notOurs.Labels = nil
+
+	testCases := []struct {
+		name     string
+		obj      interface{}
+		expected []string
+	}{
+		{
+			name:     "shared",
+			obj:      sharedIngress(function.Namespace, []*uselessv1.Function{function}, opts),
+			expected: []string{getKey(function, t)},
+		},
+		{
+			name:     "owned",
+			obj:      functionIngress(function, opts),
+			expected: []string{getKey(function, t)},
+		},
+		{name: "not ours", obj: notOurs},
+		{
+			name: "other namespace",
+			obj: sharedIngress(metav1.NamespaceSystem,
+				[]*uselessv1.Function{function}, opts),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.functionLister = append(f.functionLister, function)
+			c, _, _ := f.newController()
+
+			c.handleIngress(tc.obj)
+			keys := []string{}
+			for c.workqueue.Len() > 0 {
+				key, _ := c.workqueue.Get()
+				keys = append(keys, key.(string))
+				c.workqueue.Done(key)
+			}
+			if len(keys) != len(tc.expected) || (len(keys) > 0 && !reflect.DeepEqual(keys, tc.expected)) {
+				t.Errorf("expected enqueued %v, got %v", tc.expected, keys)
+			}
+		})
+	}
+}
+
+// routeIngress returns the Service and the port which the request is routed
+// to by the Prefix rules, the path is passed through as is.
+func routeIngress(ingress *networkingv1.Ingress, host, path string) (string, int32, bool) {
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != host {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			prefix := strings.TrimSuffix(p.Path, "/")
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return p.Backend.Service.Name, p.Backend.Service.Port.Number, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+func TestIngressRoutesToSupervisor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laddr := l.Addr().String()
+	l.Close()
+	supervisor := uselessruntime.NewSupervisor("test", func(_ context.Context, input string) (string, error) {
+		return "hello " + input, nil
+	})
+	go supervisor.Run(laddr)
+	defer supervisor.Close()
+
+	function := newFunction("test", int32Ptr(1))
+	other := newFunction("other", int32Ptr(1))
+	opts := IngressOptions{RootDomain: "fn.example.com"}
+	shared := opts
+	shared.Shared = true
+	for _, ingress := range []*networkingv1.Ingress{
+		functionIngress(function, opts),
+		sharedIngress(function.Namespace, []*uselessv1.Function{other, function}, shared),
+	} {
+		// The clients invoke the function on the root of its host.
+		service, port, ok := routeIngress(ingress, function.ExternalName(opts.RootDomain), "/")
+		if !ok || service != function.Spec.FuncName || port != uselessv1.FunctionPort {
+			t.Fatalf("%s: expected the invocation routed to %s:%d, got %v %s:%d",
+				ingress.Name, function.Spec.FuncName, uselessv1.FunctionPort, ok, service, port)
+		}
+
+		var resp *http.Response
+		for i := 0; i < 100; i++ {
+			resp, err = http.Post("http://"+laddr+"/", "application/json", strings.NewReader(`{"input":"world"}`))
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "hello world") {
+			t.Errorf("%s: expected the function invoked, got %d %s", ingress.Name, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+// syncSource creates the ConfigMap which holds the source of the interpreted
+// function, it must exist before the pods are rolled out. The ConfigMaps of
+// the older sources are kept until the Deployment is rolled out, since the
+// pods of the older templates may be restarted and mount them again.
+func (c *Controller) syncSource(function *uselessv1.Function) error {
+	current := ""
+	if function.Interpreted() {
+		desired := function.SourceConfigMap()
+		current = desired.Name
+		if err := c.syncSourceConfigMap(function, desired); err != nil {
+			return err
+		}
+	}
+
+	// The ConfigMap mounted by the current template is kept as well, the
+	// Deployment is updated to mount the new one after this.
+	mounted := map[string]bool{current: true}
+	deployment, err := c.deploymentsLister.Deployments(function.Namespace).Get(function.Spec.FuncName)
+	if err == nil {
+		if !deploymentRolledOut(deployment) {
+			return nil
+		}
+		for _, volume := range deployment.Spec.Template.Spec.Volumes {
+			if volume.ConfigMap != nil {
+				mounted[volume.ConfigMap.Name] = true
+			}
+		}
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	configMaps, err := c.configMapsLister.ConfigMaps(function.Namespace).List(
+		labels.SelectorFromSet(function.SourceLabels()))
+	if err != nil {
+		return err
+	}
+	for _, configMap := range configMaps {
+		if mounted[configMap.Name] || !metav1.IsControlledBy(configMap, function) {
+			continue
+		}
+		klog.V(4).Infof("deleting configmap %s/%s", configMap.Namespace, configMap.Name)
+		err := c.kubeclientset.CoreV1().ConfigMaps(function.Namespace).Delete(context.TODO(), configMap.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessDeleted, MessageResourceDeleted, configMap.Name)
+	}
+	return nil
+}
+
+// deploymentRolledOut reports whether all the pods of the deployment run its current
+// template.
+func deploymentRolledOut(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas && status.Replicas == replicas
+}
+
+func (c *Controller) syncSourceConfigMap(function *uselessv1.Function, desired *corev1.ConfigMap) error {
+	configMap, err := c.configMapsLister.ConfigMaps(function.Namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		_, err = c.kubeclientset.CoreV1().ConfigMaps(function.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.isOwner(function, configMap); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(desired.Data, configMap.Data) {
+		return nil
+	}
+
+	configMap = configMap.DeepCopy()
+	configMap.Labels = desired.Labels
+	configMap.Data = desired.Data
+	klog.V(4).Infof("updating configmap %s/%s", configMap.Namespace, configMap.Name)
+	_, err = c.kubeclientset.CoreV1().ConfigMaps(function.Namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	if err == nil {
+		c.recorder.Eventf(function, corev1.EventTypeNormal, SuccessUpdated, MessageResourceUpdated, configMap.Name)
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+const interpreterImage = "useless/interpreter:latest"
+
+func newInterpretedFunction(name string) *uselessv1.Function {
+	function := newSourceFunction(name)
+	function.Spec.Mode = uselessv1.InterpretedMode
+	return function
+}
+
+func TestSyncSource(t *testing.T) {
+	function := newInterpretedFunction("test")
+	desired := function.SourceConfigMap()
+	outdated := desired.DeepCopy()
+	outdated.Data = map[string]string{uselessv1.SourceKey: "{}"}
+	stale := desired.DeepCopy()
+	stale.Name = "test-source-stale"
+	notOurs := desired.DeepCopy()
+	notOurs.OwnerReferences = nil
+	compiled := newSourceFunction("test")
+
+	// The pods of the stale source are being replaced.
+	staleFunction := function.DeepCopy()
+	staleFunction.Spec.Source.Files["test.go"] = "package test\n\n// stale\n"
+	stale.Name = staleFunction.SourceConfigMap().Name
+	rollingOut := rolledOut(staleFunction, 1)
+	rollingOut.Spec.Template = function.Deployment(interpreterImage).Spec.Template
+	rollingOut.Generation = 2
+	updating := rolledOut(function, 1)
+	updating.Status.Replicas = 2
+	// The template has not been updated to the current source yet.
+	outdatedTemplate := rolledOut(staleFunction, 1)
+
+	testCases := []struct {
+		name        string
+		function    *uselessv1.Function
+		configMaps  []*corev1.ConfigMap
+		deployment  *appsv1.Deployment
+		expected    func(f *fixture)
+		expectError bool
+	}{
+		{
+			name:     "created",
+			function: function,
+			expected: func(f *fixture) {
+				f.expectCreateAction("configmaps", function.Namespace, desired)
+			},
+		},
+		{
+			name:       "unchanged",
+			function:   function,
+			configMaps: []*corev1.ConfigMap{desired},
+			expected:   func(*fixture) {},
+		},
+		{
+			name:       "updated",
+			function:   function,
+			configMaps: []*corev1.ConfigMap{outdated},
+			expected: func(f *fixture) {
+				f.expectUpdateAction("configmaps", function.Namespace, desired)
+			},
+		},
+		{
+			name:       "stale deleted",
+			function:   function,
+			configMaps: []*corev1.ConfigMap{desired, stale},
+			expected: func(f *fixture) {
+				f.expectDeleteAction("configmaps", function.Namespace, stale.Name)
+			},
+		},
+		{
+			name:       "stale kept until observed",
+			function:   function,
+			configMaps: []*corev1.ConfigMap{desired, stale},
+			deployment: rollingOut,
+			expected:   func(*fixture) {},
+		},
+		{
+			name:       "stale kept while rolling out",
+			function:   function,
+			configMaps: []*corev1.ConfigMap{desired, stale},
+			deployment: updating,
+			expected:   func(*fixture) {},
+		},
+		{
+			name:       "stale kept while mounted",
+			function:   function,
+			configMaps: []*corev1.ConfigMap{desired, stale},
+			deployment: outdatedTemplate,
+			expected:   func(*fixture) {},
+		},
+		{
+			name:       "stale deleted once rolled out",
+			function:   function,
+			configMaps: []*corev1.ConfigMap{desired, stale},
+			deployment: rolledOut(function, 1),
+			expected: func(f *fixture) {
+				f.expectDeleteAction("configmaps", function.Namespace, stale.Name)
+			},
+		},
+		{
+			name:       "compiled",
+			function:   compiled,
+			configMaps: []*corev1.ConfigMap{desired},
+			expected: func(f *fixture) {
+				f.expectDeleteAction("configmaps", function.Namespace, desired.Name)
+			},
+		},
+		{
+			name:        "not ours",
+			function:    function,
+			configMaps:  []*corev1.ConfigMap{notOurs},
+			expected:    func(*fixture) {},
+			expectError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			for _, configMap := range tc.configMaps {
+				f.configMapLister = append(f.configMapLister, configMap)
+				f.kubeobjects = append(f.kubeobjects, configMap)
+			}
+			if tc.deployment != nil {
+				f.deploymentLister = append(f.deploymentLister, tc.deployment)
+				f.kubeobjects = append(f.kubeobjects, tc.deployment)
+			}
+			tc.expected(f)
+
+			c, _, _ := f.newController()
+			err := c.syncSource(tc.function)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expected error: %v, got %v", tc.expectError, err)
+			}
+			actions := filterInformerActions(f.kubeclient.Actions())
+			if len(actions) != len(f.kubeactions) {
+				t.Fatalf("expected actions %+v, got %+v", f.kubeactions, actions)
+			}
+			for i := range actions {
+				checkAction(f.kubeactions[i], actions[i], t)
+			}
+		})
+	}
+}
+
+func TestInterpretedDeployment(t *testing.T) {
+	function := newInterpretedFunction("test")
+	deployment := function.Deployment(interpreterImage)
+	podSpec := deployment.Spec.Template.Spec
+	container := podSpec.Containers[0]
+	if container.Image != interpreterImage {
+		t.Errorf("expected the interpreter image, got %s", container.Image)
+	}
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].ConfigMap == nil ||
+		podSpec.Volumes[0].ConfigMap.Name != function.SourceConfigMap().Name {
+		t.Errorf("expected the source ConfigMap mounted, got %+v", podSpec.Volumes)
+	}
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].Name != podSpec.Volumes[0].Name {
+		t.Errorf("expected the source volume mounted, got %+v", container.VolumeMounts)
+	}
+
+	// The pods are rolled out once the source changes.
+	changed := function.DeepCopy()
+	changed.Spec.Source.Files["test.go"] = "package test\n\nfunc Test() {}\n"
+	if name := changed.Deployment(interpreterImage).Spec.Template.Spec.Volumes[0].ConfigMap.Name; name == podSpec.Volumes[0].ConfigMap.Name {
+		t.Errorf("expected another ConfigMap for the changed source, got %s", name)
+	}
+}
+
+func TestSyncHandlerInterpreted(t *testing.T) {
+	f := newFixture(t)
+	function := newInterpretedFunction("test")
+	// No image is built for the interpreted function, even if the build is
+	// enabled.
+	f.build = &BuildOptions{BuilderImage: "builder", Registry: "registry"}
+	f.functionLister = append(f.functionLister, function)
+	f.objects = append(f.objects, function)
+
+	c, _, _ := f.newController()
+	c.cfg.InterpreterImage = interpreterImage
+	if err := c.syncHandler(getKey(function, t)); err != nil {
+		t.Fatal(err)
+	}
+	created := map[string]bool{}
+	for _, action := range filterInformerActions(f.kubeclient.Actions()) {
+		if action.GetVerb() == "create" {
+			created[action.GetResource().Resource] = true
+		}
+	}
+	if created["jobs"] {
+		t.Error("expected no build Job for the interpreted function")
+	}
+	if !created["configmaps"] || !created["deployments"] {
+		t.Errorf("expected the source ConfigMap and the Deployment created, got %v", created)
+	}
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+// syncProxiedEndpoints points the Endpoints of a Service without selector to
+// the activator, the activator holds the requests until the function has
+// ready replicas.
+func (c *Controller) syncProxiedEndpoints(function *uselessv1.Function) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(c.cfg.ActivatorService)
+	if err != nil {
+		return err
+	}
+	activator, err := c.endpointsLister.Endpoints(namespace).Get(name)
+	if err != nil {
+		return fmt.Errorf("activator endpoints %s: %v", c.cfg.ActivatorService, err)
+	}
+
+	subsets := []corev1.EndpointSubset{}
+	for _, subset := range activator.Subsets {
+		if len(subset.Addresses) == 0 || len(subset.Ports) == 0 {
+			continue
+		}
+		addresses := make([]corev1.EndpointAddress, 0, len(subset.Addresses))
+		for _, addr := range subset.Addresses {
+			addresses = append(addresses, corev1.EndpointAddress{IP: addr.IP})
+		}
+		subsets = append(subsets, corev1.EndpointSubset{
+			Addresses: addresses,
+			Ports: []corev1.EndpointPort{{
+				// Must match the name of the Service port.
+				Name:     function.Spec.FuncName,
+				Port:     subset.Ports[0].Port,
+				Protocol: corev1.ProtocolTCP,
+			}},
+		})
+	}
+
+	endpoints, err := c.endpointsLister.Endpoints(function.Namespace).Get(function.Spec.FuncName)
+	if errors.IsNotFound(err) {
+		_, err = c.kubeclientset.CoreV1().Endpoints(function.Namespace).Create(context.TODO(), &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      function.Spec.FuncName,
+				Namespace: function.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(function, uselessv1.SchemeGroupVersion.WithKind("Function")),
+				},
+			},
+			Subsets: subsets,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	// The Endpoints are created by the endpoints controller without owner
+	// while the Service had a selector, they belong to our Service which has
+	// been checked by syncService, so they are adopted then.
+	controllerRef := metav1.GetControllerOf(endpoints)
+	if controllerRef != nil {
+		if err := c.isOwner(function, endpoints); err != nil {
+			return err
+		}
+	}
+	if controllerRef != nil && equality.Semantic.DeepEqual(subsets, endpoints.Subsets) {
+		return nil
+	}
+	endpoints = endpoints.DeepCopy()
+	endpoints.Subsets = subsets
+	if controllerRef == nil {
+		endpoints.OwnerReferences = append(endpoints.OwnerReferences,
+			*metav1.NewControllerRef(function, uselessv1.SchemeGroupVersion.WithKind("Function")))
+	}
+	klog.V(4).Infof("routing endpoints %s/%s to the activator", endpoints.Namespace, endpoints.Name)
+	_, err = c.kubeclientset.CoreV1().Endpoints(function.Namespace).Update(context.TODO(), endpoints, metav1.UpdateOptions{})
+	return err
+}
+
+// handleActivatorEndpoints enqueues all the functions which can be scaled to
+// zero if the endpoints of the activator changes.
+func (c *Controller) handleActivatorEndpoints(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if key != c.cfg.ActivatorService {
+		c.handleObject(obj)
+		return
+	}
+	functions, err := c.funcsLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, function := range functions {
+		if enabled, _ := function.ScaleToZero(); enabled {
+			c.enqueueFunc(function)
+		}
+	}
+}
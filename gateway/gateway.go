@@ -0,0 +1,174 @@
+// Package gateway implements the single entry point of the functions, it
+// routes the requests to the Services of the functions by the path or the
+// host.
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/damnever/useless/activator"
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions/useless/v1"
+	listers "github.com/damnever/useless/pkg/generated/listers/useless/v1"
+)
+
+// Gateway routes the requests:
+//   - <function>.<rootDomain>/<path> and <function>.<namespace>.<rootDomain>/<path>
+//   - /<namespace>/<function>/<path>
+//
+// to http://<function>.<namespace>.svc.cluster.local/<path>.
+type Gateway struct {
+	rootDomain string
+
+	funcsLister listers.FunctionLister
+	funcsSynced cache.InformerSynced
+
+	transport http.RoundTripper
+}
+
+// New returns a new Gateway.
+func New(rootDomain string, funcInformer informers.FunctionInformer) *Gateway {
+	return &Gateway{
+		rootDomain:  strings.Trim(rootDomain, "."),
+		funcsLister: funcInformer.Lister(),
+		funcsSynced: funcInformer.Informer().HasSynced,
+		transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost: 32,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// Run serves the requests on laddr until stopCh is closed.
+func (g *Gateway) Run(laddr string, stopCh <-chan struct{}) error {
+	klog.Info("Waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(stopCh, g.funcsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	server := &http.Server{Addr: laddr, Handler: g}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+	klog.Infof("Gateway listening on %s, root domain: %s", laddr, g.rootDomain)
+	select {
+	case err := <-errc:
+		return err
+	case <-stopCh:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" && !g.isFunctionHost(r.Host) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	function, path, err := g.route(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	target, err := url.Parse(function.URL())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = path
+			req.URL.RawPath = ""
+			req.Host = target.Host
+			// Let the activator know who is the target if the function is
+			// scaled to zero.
+			req.Header.Set(activator.FunctionHeader, function.Namespace+"/"+function.Spec.FuncName)
+		},
+		Transport: g.transport,
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func (g *Gateway) isFunctionHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.HasSuffix(host, "."+g.rootDomain)
+}
+
+// route returns the function and the path to forward.
+func (g *Gateway) route(r *http.Request) (*uselessv1.Function, string, error) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if strings.HasSuffix(host, "."+g.rootDomain) {
+		parts := strings.Split(strings.TrimSuffix(host, "."+g.rootDomain), ".")
+		switch len(parts) {
+		case 1:
+			function, err := g.lookup("", parts[0])
+			return function, r.URL.Path, err
+		case 2:
+			function, err := g.lookup(parts[1], parts[0])
+			return function, r.URL.Path, err
+		default:
+			return nil, "", fmt.Errorf("unknown host: %s", host)
+		}
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, "", fmt.Errorf("path must be in the form of /<namespace>/<function>")
+	}
+	path := "/"
+	if len(parts) == 3 {
+		path += parts[2]
+	}
+	function, err := g.lookup(parts[0], parts[1])
+	return function, path, err
+}
+
+// lookup finds the function by the name, it must be unique across the
+// namespaces if the namespace is empty.
+func (g *Gateway) lookup(namespace, name string) (*uselessv1.Function, error) {
+	functions, err := g.funcsLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var found *uselessv1.Function
+	for _, function := range functions {
+		if function.Spec.FuncName != name || (namespace != "" && function.Namespace != namespace) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("function %q is ambiguous, use %s.<namespace>.%s instead",
+				name, name, g.rootDomain)
+		}
+		found = function
+	}
+	if found == nil {
+		return nil, fmt.Errorf("function %q not found", name)
+	}
+	return found, nil
+}
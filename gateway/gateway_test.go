@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/damnever/useless/activator"
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/generated/clientset/versioned/fake"
+	informers "github.com/damnever/useless/pkg/generated/informers/externalversions"
+)
+
+// backendTransport sends all the requests to the backend, the original
+// host is kept in the response.
+type backendTransport struct {
+	backend *url.URL
+}
+
+func (t backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Target", req.URL.Host)
+	req.URL.Host = t.backend.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newFunction(namespace, name string) *uselessv1.Function {
+	return &uselessv1.Function{
+		TypeMeta:   metav1.TypeMeta{APIVersion: uselessv1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: uselessv1.FunctionSpec{
+			FuncName: name,
+			Image:    "useless/" + name + ":latest",
+		},
+	}
+}
+
+func newGateway(t *testing.T, functions ...*uselessv1.Function) *Gateway {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Target")+" "+r.URL.Path+" "+r.Header.Get(activator.FunctionHeader))
+	}))
+	t.Cleanup(backend.Close)
+	backendURL, _ := url.Parse(backend.URL)
+
+	i := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	g := New("fn.example.com.", i.Useless().V1().Functions())
+	g.transport = backendTransport{backend: backendURL}
+	for _, function := range functions {
+		i.Useless().V1().Functions().Informer().GetIndexer().Add(function)
+	}
+	return g
+}
+
+func TestRoutes(t *testing.T) {
+	g := newGateway(t,
+		newFunction("default", "hello"),
+		newFunction("default", "echo"),
+		newFunction("staging", "echo"),
+	)
+
+	testCases := []struct {
+		name         string
+		host         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "host",
+			host:         "hello.fn.example.com",
+			path:         "/greet",
+			expectedCode: http.StatusOK,
+			expectedBody: "hello.default.svc.cluster.local /greet default/hello",
+		},
+		{
+			name:         "host with port",
+			host:         "hello.fn.example.com:8080",
+			path:         "/",
+			expectedCode: http.StatusOK,
+			expectedBody: "hello.default.svc.cluster.local / default/hello",
+		},
+		{
+			name:         "host with namespace",
+			host:         "echo.staging.fn.example.com",
+			path:         "/a/b",
+			expectedCode: http.StatusOK,
+			expectedBody: "echo.staging.svc.cluster.local /a/b staging/echo",
+		},
+		{
+			name:         "ambiguous host",
+			host:         "echo.fn.example.com",
+			path:         "/",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown host",
+			host:         "a.b.c.fn.example.com",
+			path:         "/",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "path",
+			host:         "gateway",
+			path:         "/staging/echo/a/b",
+			expectedCode: http.StatusOK,
+			expectedBody: "echo.staging.svc.cluster.local /a/b staging/echo",
+		},
+		{
+			name:         "path without trailing path",
+			host:         "gateway",
+			path:         "/default/hello",
+			expectedCode: http.StatusOK,
+			expectedBody: "hello.default.svc.cluster.local / default/hello",
+		},
+		{
+			name:         "path without function",
+			host:         "gateway",
+			path:         "/default",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "function not found",
+			host:         "gateway",
+			path:         "/default/nope/",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "healthz",
+			host:         "gateway",
+			path:         "/healthz",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "healthz of a function",
+			host:         "hello.fn.example.com",
+			path:         "/healthz",
+			expectedCode: http.StatusOK,
+			expectedBody: "hello.default.svc.cluster.local /healthz default/hello",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Host = tc.host
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedCode {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectedCode, w.Code, w.Body.String())
+			}
+			if tc.expectedBody != "" && w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
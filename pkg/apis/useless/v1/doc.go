@@ -1,4 +1,5 @@
 // +k8s:deepcopy-gen=package
-// +groupName=useless
+// +groupName=alphabetical.useless
+// +groupGoName=Useless
 
 package v1
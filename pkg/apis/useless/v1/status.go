@@ -0,0 +1,53 @@
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns the condition with the given type, nil if not found.
+func (s *FunctionStatus) GetCondition(t FunctionConditionType) *FunctionCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsReady tells whether the Ready condition is true.
+func (s *FunctionStatus) IsReady() bool {
+	cond := s.GetCondition(FunctionReady)
+	return cond != nil && cond.Status == corev1.ConditionTrue
+}
+
+// SetCondition adds or replaces the condition with the same type, the
+// LastTransitionTime only changes if the status changes.
+func (s *FunctionStatus) SetCondition(t FunctionConditionType, status corev1.ConditionStatus, reason, message string) {
+	cond := FunctionCondition{
+		Type:               t,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	if old := s.GetCondition(t); old != nil {
+		if old.Status == status {
+			cond.LastTransitionTime = old.LastTransitionTime
+		}
+		*old = cond
+		return
+	}
+	s.Conditions = append(s.Conditions, cond)
+}
+
+// RemoveCondition removes the condition with the given type.
+func (s *FunctionStatus) RemoveCondition(t FunctionConditionType) {
+	conditions := s.Conditions[:0]
+	for _, cond := range s.Conditions {
+		if cond.Type != t {
+			conditions = append(conditions, cond)
+		}
+	}
+	s.Conditions = conditions
+}
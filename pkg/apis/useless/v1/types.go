@@ -1,12 +1,24 @@
 package v1
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
-	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // +genclient
@@ -16,15 +28,252 @@ type Function struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec FunctionSpec `json:"spec"`
-	// Status FuncStatus `json:"status"`
+	Spec   FunctionSpec   `json:"spec"`
+	Status FunctionStatus `json:"status,omitempty"`
 }
 
 type FunctionSpec struct {
-	FuncName    string `json:"funcName"`
-	FuncContent string `json:"funcContent"`
-	Image       string `json:"image"`
-	Replicas    *int32 `json:"replicas"`
+	FuncName string `json:"funcName"`
+	// FuncContent is the content of the file which declares the function,
+	// the whole source tree is in Source.
+	FuncContent string          `json:"funcContent"`
+	Source      *FunctionSource `json:"source,omitempty"`
+	// Mode is how the function runs, defaults to "compiled".
+	Mode FunctionMode `json:"mode,omitempty"`
+	// Runtime is where the compiled function runs, defaults to "native".
+	Runtime FunctionRuntime `json:"runtime,omitempty"`
+	// Sandbox limits the invocations of the functions in the wasm runtime.
+	Sandbox *SandboxSpec `json:"sandbox,omitempty"`
+	// Image is the image of the function, the controller builds it from the
+	// source if it is empty, see FunctionStatus.Build. It is not used if the
+	// function is interpreted.
+	Image    string `json:"image,omitempty"`
+	Replicas *int32 `json:"replicas"`
+	// Autoscaling configures the HorizontalPodAutoscaler of the function,
+	// the defaults are used if it is nil.
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+	// DrainTimeoutSeconds is how long the in-flight invocations can run
+	// once the replica is being terminated, defaults to 30.
+	DrainTimeoutSeconds *int32 `json:"drainTimeoutSeconds,omitempty"`
+	// Env is the environment variables of the function.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// Resources replaces the default resource requirements of the function,
+	// which only request 100m CPU and 64Mi memory, the requests are required
+	// by the HorizontalPodAutoscaler.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Triggers invoke the function on schedules, the function can always be
+	// invoked through its Service.
+	Triggers []FunctionTrigger `json:"triggers,omitempty"`
+}
+
+type FunctionMode string
+
+const (
+	// CompiledMode runs the image of the function, which is either specified
+	// or built from the source.
+	CompiledMode FunctionMode = "compiled"
+	// InterpretedMode runs the source of the function by the interpreter
+	// image, which is shared by all the functions, no image is built.
+	InterpretedMode FunctionMode = "interpreted"
+)
+
+type FunctionRuntime string
+
+const (
+	// NativeRuntime runs the function binary built for the platform.
+	NativeRuntime FunctionRuntime = "native"
+	// WasmRuntime compiles the function to WebAssembly (GOOS=wasip1), the
+	// supervisor runs it in a sandbox per invocation, which isolates the
+	// untrusted functions better.
+	WasmRuntime FunctionRuntime = "wasm"
+)
+
+// SandboxSpec limits every invocation of the function in the wasm runtime.
+type SandboxSpec struct {
+	// MemoryLimitMiB is the maximum memory of an invocation, defaults to 64.
+	MemoryLimitMiB int32 `json:"memoryLimitMiB,omitempty"`
+	// TimeoutSeconds is the maximum execution time of an invocation,
+	// defaults to 10.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// FunctionTrigger invokes the function on the schedule by a CronJob.
+type FunctionTrigger struct {
+	// Name must be unique among the triggers of the function, the name of
+	// its CronJob is <funcName>-<name>, which is limited to
+	// MaxCronJobNameLength.
+	Name string `json:"name"`
+	// Schedule is in the cron format, e.g. "*/5 * * * *".
+	Schedule string `json:"schedule"`
+	// Input is the request body sent to the function.
+	Input string `json:"input,omitempty"`
+}
+
+// FunctionSource is the source tree of the function, it is either a Go
+// module or a single directory of Go files.
+type FunctionSource struct {
+	// Module is the module path in go.mod, it is empty if the function is
+	// not a Go module.
+	Module string `json:"module,omitempty"`
+	// Dir is the slash separated directory of the function package relative
+	// to the root of the tree.
+	Dir string `json:"dir,omitempty"`
+	// Function is the name of the Go function, FuncName is lower cased.
+	Function string `json:"function,omitempty"`
+	// Files are the file contents keyed by the slash separated paths
+	// relative to the root of the tree.
+	Files map[string]string `json:"files"`
+}
+
+type AutoscalingClass string
+
+const (
+	// HPAAutoscaling scales the function by the HorizontalPodAutoscaler.
+	HPAAutoscaling AutoscalingClass = "hpa"
+	// ConcurrencyAutoscaling scales the function by the in-flight requests,
+	// the controller is the autoscaler.
+	ConcurrencyAutoscaling AutoscalingClass = "concurrency"
+)
+
+type AutoscalingSpec struct {
+	// Disabled turns the autoscaling off, the replicas are left to the user.
+	Disabled bool `json:"disabled,omitempty"`
+	// Class is the autoscaler to use, defaults to "hpa".
+	Class       AutoscalingClass `json:"class,omitempty"`
+	MinReplicas *int32           `json:"minReplicas,omitempty"`
+	MaxReplicas int32            `json:"maxReplicas,omitempty"`
+	// The target utilization is the percentage of the requested resources.
+	TargetCPUUtilizationPercentage    *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+	// Metrics are the custom metrics which describe the pods, the
+	// metrics API must be served by an adapter.
+	Metrics []PodsMetric `json:"metrics,omitempty"`
+	// Behavior configures the scaling up and down of the
+	// HorizontalPodAutoscaler, e.g. the stabilization windows, the defaults
+	// of the cluster are used if it is nil. It is used by the hpa class
+	// only.
+	Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+
+	// The following fields are used by the concurrency class only.
+
+	// TargetConcurrency is the desired in-flight requests per replica.
+	TargetConcurrency *int32 `json:"targetConcurrency,omitempty"`
+	// StableWindowSeconds is the window to average the concurrency over.
+	StableWindowSeconds *int32 `json:"stableWindowSeconds,omitempty"`
+	// PanicWindowSeconds is the shorter window to react to bursts, the
+	// autoscaler never scales down in panic mode.
+	PanicWindowSeconds *int32 `json:"panicWindowSeconds,omitempty"`
+	// ScaleToZero scales the function to zero replicas once it has been
+	// idle for a while, the requests are routed to the activator then.
+	ScaleToZero *ScaleToZeroSpec `json:"scaleToZero,omitempty"`
+}
+
+type ScaleToZeroSpec struct {
+	// IdleWindowSeconds is how long the function must be idle before
+	// being scaled to zero.
+	IdleWindowSeconds int32 `json:"idleWindowSeconds,omitempty"`
+}
+
+// ScaleToZero tells whether the function can be scaled to zero, and the idle
+// window if so.
+func (f *Function) ScaleToZero() (bool, time.Duration) {
+	if f.AutoscalingClass() != ConcurrencyAutoscaling || f.Spec.Autoscaling.ScaleToZero == nil {
+		return false, 0
+	}
+	idleWindow := time.Duration(defaultIdleWindowSeconds) * time.Second
+	if seconds := f.Spec.Autoscaling.ScaleToZero.IdleWindowSeconds; seconds > 0 {
+		idleWindow = time.Duration(seconds) * time.Second
+	}
+	return true, idleWindow
+}
+
+// AutoscalingClass returns the autoscaler in use, empty if the autoscaling
+// is disabled.
+func (f *Function) AutoscalingClass() AutoscalingClass {
+	spec := f.Spec.Autoscaling
+	switch {
+	case spec == nil:
+		return HPAAutoscaling
+	case spec.Disabled:
+		return ""
+	case spec.Class == "":
+		return HPAAutoscaling
+	default:
+		return spec.Class
+	}
+}
+
+type PodsMetric struct {
+	Name               string            `json:"name"`
+	TargetAverageValue resource.Quantity `json:"targetAverageValue"`
+}
+
+type FunctionStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Replicas and Selector are used by the scale subresource.
+	Replicas          int32  `json:"replicas,omitempty"`
+	Selector          string `json:"selector,omitempty"`
+	ReadyReplicas     int32  `json:"readyReplicas,omitempty"`
+	AvailableReplicas int32  `json:"availableReplicas,omitempty"`
+	// URL is the in-cluster address of the function.
+	URL string `json:"url,omitempty"`
+	// Image is the image which has been rolled out to all replicas.
+	Image string `json:"image,omitempty"`
+	// Build is the in-cluster build of the image, it is nil if the image
+	// is specified.
+	Build      *BuildStatus        `json:"build,omitempty"`
+	Conditions []FunctionCondition `json:"conditions,omitempty"`
+}
+
+type BuildPhase string
+
+const (
+	BuildPending   BuildPhase = "Pending"
+	BuildRunning   BuildPhase = "Running"
+	BuildSucceeded BuildPhase = "Succeeded"
+	BuildFailed    BuildPhase = "Failed"
+)
+
+// BuildStatus is the build of the current source, and the image built from
+// the last succeeded one, which is served until the current build succeeds.
+type BuildStatus struct {
+	Phase BuildPhase `json:"phase"`
+	// SourceHash identifies the source being built, see Function.SourceHash.
+	SourceHash string `json:"sourceHash"`
+	// Job is the name of the build Job, its logs are the build logs.
+	Job string `json:"job,omitempty"`
+	// Image is pinned by the digest, e.g. registry/name:tag@sha256:...
+	Image string `json:"image,omitempty"`
+	// Reason and Message tell why the build failed.
+	Reason         string       `json:"reason,omitempty"`
+	Message        string       `json:"message,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+type FunctionConditionType string
+
+const (
+	// FunctionReady means the function is able to serve requests, it is
+	// false with the reason ScaledToZero or Activating while the requests
+	// are buffered by the activator.
+	FunctionReady FunctionConditionType = "Ready"
+	// FunctionDeployed means the latest spec has been rolled out.
+	FunctionDeployed FunctionConditionType = "Deployed"
+	// FunctionScaled means all desired replicas are ready.
+	FunctionScaled FunctionConditionType = "Scaled"
+	// FunctionBuilt means the image of the current source has been built,
+	// it is only set if the image is built in the cluster.
+	FunctionBuilt FunctionConditionType = "Built"
+)
+
+type FunctionCondition struct {
+	Type               FunctionConditionType  `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -36,13 +285,35 @@ type FunctionList struct {
 	Items []Function `json:"items"`
 }
 
-func (f *Function) Deployment() *appsv1.Deployment {
-	labels := map[string]string{
+// PodLabels returns the labels of the pods which run the function.
+func (f *Function) PodLabels() map[string]string {
+	return map[string]string{
 		"controller": f.Name,
 		"useless":    "function",
 		"function":   f.Spec.FuncName,
 	}
-	return &appsv1.Deployment{
+}
+
+const (
+	defaultCPURequest    = "100m"
+	defaultMemoryRequest = "64Mi"
+	// startupProbeFailureThreshold gives the function a minute to start.
+	startupProbeFailureThreshold = 30
+)
+
+// Deployment returns the Deployment of the function, the interpreted function
+// runs the interpreterImage with the source mounted, and the function in the
+// wasm runtime is given the limits of the sandbox.
+func (f *Function) Deployment(interpreterImage string) *appsv1.Deployment {
+	labels := f.PodLabels()
+	drainTimeout := int64(defaultDrainTimeoutSeconds)
+	if f.Spec.DrainTimeoutSeconds != nil {
+		drainTimeout = int64(*f.Spec.DrainTimeoutSeconds)
+	}
+	// The preStop hook gives the endpoints some time to remove the pod before
+	// the function receives SIGTERM and starts draining.
+	gracePeriod := preStopSleepSeconds + drainTimeout + 5
+	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      f.Spec.FuncName,
 			Namespace: f.Namespace,
@@ -60,20 +331,383 @@ func (f *Function) Deployment() *appsv1.Deployment {
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
+					TerminationGracePeriodSeconds: &gracePeriod,
 					Containers: []corev1.Container{
 						{
 							Name:  f.Spec.FuncName,
-							Image: f.Spec.Image,
+							Image: f.Image(),
+							Env: append([]corev1.EnvVar{{
+								Name:  "DRAIN_TIMEOUT",
+								Value: fmt.Sprintf("%ds", drainTimeout),
+							}}, f.Spec.Env...),
+							Lifecycle: &corev1.Lifecycle{
+								PreStop: &corev1.LifecycleHandler{
+									Exec: &corev1.ExecAction{
+										Command: []string{"sleep", strconv.Itoa(preStopSleepSeconds)},
+									},
+								},
+							},
+							Ports: []corev1.ContainerPort{{
+								Name:          "http",
+								ContainerPort: FunctionPort,
+								Protocol:      corev1.ProtocolTCP,
+							}},
+							// The paths are served by runtime.Supervisor, the
+							// liveness probe starts once the startup probe
+							// succeeds, which allows a slow start.
+							StartupProbe:   httpProbe("/healthz", 2, startupProbeFailureThreshold),
+							LivenessProbe:  httpProbe("/healthz", 10, 3),
+							ReadinessProbe: httpProbe("/readyz", 2, 1),
+							Resources:      f.resources(),
+						},
+					},
+				},
+			},
+		},
+	}
+	if f.Interpreted() {
+		podSpec := &deployment.Spec.Template.Spec
+		container := &podSpec.Containers[0]
+		container.Image = interpreterImage
+		container.Args = []string{
+			fmt.Sprintf("-laddr=:%d", FunctionPort),
+			fmt.Sprintf("-drain-timeout=%ds", drainTimeout),
+			"-source=" + path.Join(sourceMountPath, SourceKey),
+			"-function=" + f.Spec.FuncName,
+		}
+		container.VolumeMounts = []corev1.VolumeMount{{
+			Name:      "source",
+			MountPath: sourceMountPath,
+			ReadOnly:  true,
+		}}
+		// The ConfigMap is named by the source hash, so the pods are rolled
+		// out once the source changes.
+		podSpec.Volumes = []corev1.Volume{{
+			Name: "source",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: f.sourceConfigMapName()},
+				},
+			},
+		}}
+	}
+	if f.Wasm() {
+		memoryLimit, timeout := f.SandboxLimits()
+		container := &deployment.Spec.Template.Spec.Containers[0]
+		container.Command = []string{"/app/function"}
+		container.Args = []string{
+			fmt.Sprintf("-laddr=:%d", FunctionPort),
+			fmt.Sprintf("-drain-timeout=%ds", drainTimeout),
+			fmt.Sprintf("-memory-limit-mib=%d", memoryLimit),
+			fmt.Sprintf("-timeout=%ds", timeout),
+		}
+	}
+	return deployment
+}
+
+// SandboxLimits returns the memory limit in MiB and the timeout in seconds
+// of the invocations in the wasm runtime.
+func (f *Function) SandboxLimits() (int32, int32) {
+	memoryLimit, timeout := int32(defaultSandboxMemoryLimitMiB), int32(defaultSandboxTimeoutSeconds)
+	if spec := f.Spec.Sandbox; spec != nil {
+		if spec.MemoryLimitMiB > 0 {
+			memoryLimit = spec.MemoryLimitMiB
+		}
+		if spec.TimeoutSeconds > 0 {
+			timeout = spec.TimeoutSeconds
+		}
+	}
+	return memoryLimit, timeout
+}
+
+// resources returns the resource requirements of the function container,
+// Spec.Resources replaces the default requests as a whole.
+func (f *Function) resources() corev1.ResourceRequirements {
+	if f.Spec.Resources != nil {
+		return *f.Spec.Resources.DeepCopy()
+	}
+	// The HorizontalPodAutoscaler needs the requests to calculate the
+	// utilization.
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(defaultCPURequest),
+			corev1.ResourceMemory: resource.MustParse(defaultMemoryRequest),
+		},
+	}
+}
+
+// TriggerImage is the image which invokes the function for the triggers.
+const TriggerImage = "curlimages/curl:7.66.0"
+
+// TriggerLabels returns the labels of the CronJobs of the triggers, they
+// must not select the pods of the function.
+func (f *Function) TriggerLabels() map[string]string {
+	return map[string]string{
+		"controller": f.Name,
+		"useless":    "trigger",
+		"function":   f.Spec.FuncName,
+	}
+}
+
+// MaxCronJobNameLength is the limit of the CronJob names, the CronJob
+// controller appends an 11 characters suffix to them as the Job names, which
+// are used as labels.
+const MaxCronJobNameLength = 52
+
+// CronJobName returns the name of the CronJob of the trigger.
+func (f *Function) CronJobName(trigger string) string {
+	return f.Spec.FuncName + "-" + trigger
+}
+
+// CronJobs returns the CronJobs which invoke the function for the triggers.
+func (f *Function) CronJobs() []*batchv1.CronJob {
+	historyLimit := int32(1)
+	backoffLimit := int32(2)
+	cronJobs := []*batchv1.CronJob{}
+	for _, trigger := range f.Spec.Triggers {
+		labels := f.TriggerLabels()
+		labels["trigger"] = trigger.Name
+		cronJobs = append(cronJobs, &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      f.CronJobName(trigger.Name),
+				Namespace: f.Namespace,
+				Labels:    labels,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(f, SchemeGroupVersion.WithKind("Function")),
+				},
+			},
+			Spec: batchv1.CronJobSpec{
+				Schedule:                   trigger.Schedule,
+				ConcurrencyPolicy:          batchv1.ForbidConcurrent,
+				SuccessfulJobsHistoryLimit: &historyLimit,
+				FailedJobsHistoryLimit:     &historyLimit,
+				JobTemplate: batchv1.JobTemplateSpec{
+					Spec: batchv1.JobSpec{
+						BackoffLimit: &backoffLimit,
+						Template: corev1.PodTemplateSpec{
+							ObjectMeta: metav1.ObjectMeta{
+								Labels: labels,
+							},
+							Spec: corev1.PodSpec{
+								RestartPolicy: corev1.RestartPolicyNever,
+								Containers: []corev1.Container{{
+									Name:  "trigger",
+									Image: TriggerImage,
+									Args: []string{
+										"-sS", "--fail", "-X", "POST",
+										"-H", "Content-Type: application/json",
+										"-d", trigger.Input,
+										f.URL(),
+									},
+								}},
+							},
 						},
 					},
 				},
 			},
+		})
+	}
+	return cronJobs
+}
+
+// Interpreted tells whether the function is run by the interpreter.
+func (f *Function) Interpreted() bool {
+	return f.Spec.Mode == InterpretedMode
+}
+
+// Wasm tells whether the compiled function runs in the wasm runtime.
+func (f *Function) Wasm() bool {
+	return !f.Interpreted() && f.Spec.Runtime == WasmRuntime
+}
+
+// Image returns the image of the compiled function, it is the one built in
+// the cluster if the image is not specified, empty if there is none yet.
+func (f *Function) Image() string {
+	if f.Spec.Image != "" {
+		return f.Spec.Image
+	}
+	if f.Status.Build != nil {
+		return f.Status.Build.Image
+	}
+	return ""
+}
+
+// SourceHash identifies the source of the function, the image is rebuilt
+// once it changes.
+func (f *Function) SourceHash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "func %q\n", f.Spec.FuncName)
+	if f.Spec.Runtime != "" && f.Spec.Runtime != NativeRuntime {
+		// The native ones are hashed as they were before the runtimes.
+		fmt.Fprintf(h, "runtime %q\n", f.Spec.Runtime)
+	}
+	if src := f.Spec.Source; src != nil {
+		fmt.Fprintf(h, "module %q dir %q function %q\n", src.Module, src.Dir, src.Function)
+		names := make([]string, 0, len(src.Files))
+		for name := range src.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(h, "file %q %d\n", name, len(src.Files[name]))
+			io.WriteString(h, src.Files[name])
+		}
+	} else {
+		fmt.Fprintf(h, "content %d\n", len(f.Spec.FuncContent))
+		io.WriteString(h, f.Spec.FuncContent)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sourceHashLen is the number of the hex digits of the source hash in the
+// names of the build Jobs and the source ConfigMaps.
+const sourceHashLen = 10
+
+// ShortSourceHash returns the prefix of the SourceHash which names the build
+// Jobs and the source ConfigMaps.
+func (f *Function) ShortSourceHash() string {
+	return f.SourceHash()[:sourceHashLen]
+}
+
+// BuildLabels returns the labels of the build Jobs, they must not select
+// the pods of the function.
+func (f *Function) BuildLabels() map[string]string {
+	return map[string]string{
+		"controller": f.Name,
+		"useless":    "build",
+		"function":   f.Spec.FuncName,
+	}
+}
+
+const (
+	// SourceKey is the key of the source in the ConfigMap of the interpreted
+	// function, it is the JSON encoded FunctionSource.
+	SourceKey       = "source.json"
+	sourceMountPath = "/etc/useless/source"
+)
+
+// SourceLabels returns the labels of the source ConfigMaps.
+func (f *Function) SourceLabels() map[string]string {
+	return map[string]string{
+		"controller": f.Name,
+		"useless":    "source",
+		"function":   f.Spec.FuncName,
+	}
+}
+
+func (f *Function) sourceConfigMapName() string {
+	return f.Spec.FuncName + "-source-" + f.ShortSourceHash()
+}
+
+// SourceConfigMap returns the ConfigMap which holds the source of the
+// interpreted function, the functions without the source tree are made of
+// FuncContent.
+func (f *Function) SourceConfigMap() *corev1.ConfigMap {
+	source := &FunctionSource{
+		Dir:   ".",
+		Files: map[string]string{strings.ToLower(f.Spec.FuncName) + ".go": f.Spec.FuncContent},
+	}
+	if f.Spec.Source != nil {
+		source = f.Spec.Source
+	}
+	data, err := json.Marshal(source)
+	if err != nil {
+		panic(err) // The strings are always encodable.
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      f.sourceConfigMapName(),
+			Namespace: f.Namespace,
+			Labels:    f.SourceLabels(),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(f, SchemeGroupVersion.WithKind("Function")),
+			},
 		},
+		Data: map[string]string{SourceKey: string(data)},
 	}
 }
 
-func (f *Function) HorizontalPodAutoscaler() *autoscalingv1.HorizontalPodAutoscaler {
-	return &autoscalingv1.HorizontalPodAutoscaler{
+// FunctionPort is the port which the function listens on, it is also the
+// port of the Service.
+const FunctionPort = 80
+
+const (
+	defaultMinReplicas                    = 1
+	defaultMaxReplicas                    = 10
+	defaultTargetCPUUtilizationPercentage = 50
+	defaultIdleWindowSeconds              = 300
+	defaultDrainTimeoutSeconds            = 30
+	defaultSandboxMemoryLimitMiB          = 64
+	defaultSandboxTimeoutSeconds          = 10
+	preStopSleepSeconds                   = 5
+)
+
+// ReplicasRange returns the minimum and the maximum replicas of the
+// autoscaling.
+func (f *Function) ReplicasRange() (int32, int32) {
+	minReplicas, maxReplicas := int32(defaultMinReplicas), int32(defaultMaxReplicas)
+	if spec := f.Spec.Autoscaling; spec != nil {
+		if spec.MinReplicas != nil {
+			minReplicas = *spec.MinReplicas
+		}
+		if spec.MaxReplicas != 0 {
+			maxReplicas = spec.MaxReplicas
+		}
+	}
+	return minReplicas, maxReplicas
+}
+
+// HorizontalPodAutoscaler returns nil if the autoscaling is disabled or
+// the HorizontalPodAutoscaler is not the autoscaler.
+func (f *Function) HorizontalPodAutoscaler() *autoscalingv2.HorizontalPodAutoscaler {
+	if f.AutoscalingClass() != HPAAutoscaling {
+		return nil
+	}
+	spec := f.Spec.Autoscaling
+	if spec == nil {
+		spec = &AutoscalingSpec{}
+	}
+
+	minReplicas, maxReplicas := f.ReplicasRange()
+	metrics := []autoscalingv2.MetricSpec{}
+	resourceMetric := func(name corev1.ResourceName, utilization *int32) autoscalingv2.MetricSpec {
+		return autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: name,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: utilization,
+				},
+			},
+		}
+	}
+	if spec.TargetCPUUtilizationPercentage != nil {
+		metrics = append(metrics, resourceMetric(corev1.ResourceCPU, spec.TargetCPUUtilizationPercentage))
+	}
+	if spec.TargetMemoryUtilizationPercentage != nil {
+		metrics = append(metrics, resourceMetric(corev1.ResourceMemory, spec.TargetMemoryUtilizationPercentage))
+	}
+	for _, metric := range spec.Metrics {
+		averageValue := metric.TargetAverageValue.DeepCopy()
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{
+					Name: metric.Name,
+				},
+				Target: autoscalingv2.MetricTarget{
+					Type:         autoscalingv2.AverageValueMetricType,
+					AverageValue: &averageValue,
+				},
+			},
+		})
+	}
+	if len(metrics) == 0 {
+		metrics = append(metrics, resourceMetric(corev1.ResourceCPU, int32ptr(defaultTargetCPUUtilizationPercentage)))
+	}
+
+	return &autoscalingv2.HorizontalPodAutoscaler{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      f.Spec.FuncName,
 			Namespace: f.Namespace,
@@ -81,25 +715,24 @@ func (f *Function) HorizontalPodAutoscaler() *autoscalingv1.HorizontalPodAutosca
 				*metav1.NewControllerRef(f, SchemeGroupVersion.WithKind("Function")),
 			},
 		},
-		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
-			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			// The Function itself is the target through the scale
+			// subresource, so the replicas in spec always win.
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
 				Kind:       "Function",
-				Name:       f.Spec.FuncName,
-				APIVersion: "v1",
+				Name:       f.Name,
+				APIVersion: SchemeGroupVersion.String(),
 			},
-			MinReplicas:                    int32ptr(1),
-			MaxReplicas:                    10,
-			TargetCPUUtilizationPercentage: int32ptr(50),
+			MinReplicas: &minReplicas,
+			MaxReplicas: maxReplicas,
+			Metrics:     metrics,
+			Behavior:    spec.Behavior.DeepCopy(),
 		},
 	}
 }
 
 func (f *Function) Service() *corev1.Service {
-	labels := map[string]string{
-		"controller": f.Name,
-		"useless":    "function",
-		"function":   f.Spec.FuncName,
-	}
+	labels := f.PodLabels()
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      f.Spec.FuncName,
@@ -113,18 +746,44 @@ func (f *Function) Service() *corev1.Service {
 			Ports: []corev1.ServicePort{{
 				Name:     f.Spec.FuncName,
 				Protocol: corev1.ProtocolTCP,
-				Port:     80,
+				Port:     FunctionPort,
 			}},
 			Type: corev1.ServiceTypeClusterIP,
 		},
 	}
 }
 
+// URL returns the in-cluster address of the function's Service.
+func (f *Function) URL() string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local", f.Spec.FuncName, f.Namespace)
+}
+
+// httpProbe returns the probe on the path of the function port, the fields
+// defaulted by the API server are set, so that the drift can be detected.
+func httpProbe(path string, periodSeconds, failureThreshold int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path:   path,
+				Port:   intstr.FromString("http"),
+				Scheme: corev1.URISchemeHTTP,
+			},
+		},
+		TimeoutSeconds:   1,
+		PeriodSeconds:    periodSeconds,
+		SuccessThreshold: 1,
+		FailureThreshold: failureThreshold,
+	}
+}
+
 func int32ptr(i int32) *int32 {
 	return &i
 }
 
-func externalName(funcName string) string {
-	const rootDomain = "useless.io.dev1"
-	return fmt.Sprintf("%s.%s", funcName, rootDomain)
+// DefaultRootDomain is the default root domain of the external names.
+const DefaultRootDomain = "useless.io.dev1"
+
+// ExternalName returns the host name of the function under the root domain.
+func (f *Function) ExternalName(rootDomain string) string {
+	return fmt.Sprintf("%s.%s", f.Spec.FuncName, rootDomain)
 }
@@ -0,0 +1,438 @@
+// Package builder loads the function sources, generates the build modules
+// of the functions and assembles the function images, it is shared by the
+// cli and the in-cluster builder, so they build the same images.
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+	"github.com/damnever/useless/pkg/oci"
+)
+
+const (
+	// GOOS and DefaultGOARCH are the default platform of the images, the
+	// function sources are type checked for it.
+	GOOS          = "linux"
+	DefaultGOARCH = "amd64"
+)
+
+// GOARCHes are the architectures the images can be built for, the function
+// files built for any of them are loaded.
+var GOARCHes = []string{"amd64", "arm64", "arm", "386", "ppc64le", "s390x"}
+
+const (
+	maintpl = `package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+{{ range .Imports }}
+	{{ .Name }} "{{ .Path }}"{{ end }}
+
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+func main() {
+	laddr := flag.String("laddr", ":8080", "the listen address")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long the in-flight invocations can run on shutdown")
+	flag.Parse()
+
+	useless := uselessruntime.NewSupervisor("{{ .FuncName }}", {{ .Adapter }},
+		uselessruntime.WithDrainTimeout(*drainTimeout))
+	defer useless.Close()
+	if err := useless.Run(*laddr); err != nil {
+		fmt.Fprintf(os.Stderr, "Launch function supervisor failed: %v", err)
+		os.Exit(1)
+	}
+}`
+
+	// wasmmaintpl is the main of the function in the wasm runtime, which is
+	// compiled to WebAssembly, and wasmhosttpl is the main of the supervisor
+	// which runs it, the module is embedded by go:embed.
+	wasmmaintpl = `package main
+
+import (
+{{ range .Imports }}
+	{{ .Name }} "{{ .Path }}"{{ end }}
+
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+func main() {
+	uselessruntime.ServeWasm({{ .Adapter }})
+}`
+	wasmhosttpl = `package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	uselessruntime "github.com/damnever/useless/runtime"
+)
+
+//go:embed {{ .ModuleFile }}
+var wasmModule []byte
+
+func main() {
+	laddr := flag.String("laddr", ":8080", "the listen address")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long the in-flight invocations can run on shutdown")
+	memoryLimit := flag.Uint64("memory-limit-mib", 64, "the maximum memory of an invocation in MiB")
+	timeout := flag.Duration("timeout", 10*time.Second, "the maximum execution time of an invocation")
+	flag.Parse()
+
+	useless := uselessruntime.NewSupervisor("{{ .FuncName }}", &uselessruntime.WasmFunction{
+		Module:      wasmModule,
+		Stream:      {{ .Stream }},
+		MemoryLimit: *memoryLimit << 20,
+		Timeout:     *timeout,
+	}, uselessruntime.WithDrainTimeout(*drainTimeout))
+	defer useless.Close()
+	if err := useless.Run(*laddr); err != nil {
+		fmt.Fprintf(os.Stderr, "Launch function supervisor failed: %v", err)
+		os.Exit(1)
+	}
+}`
+	// wasmModuleFile is the WebAssembly module in the host package, it is
+	// written once the module is compiled.
+	wasmModuleFile = "zz_useless_module.wasm"
+)
+
+const (
+	// SupervisorDockerfile packs the function binary into the image, it is
+	// relative to the root of this repository.
+	SupervisorDockerfile = "./docker/supervisor.Dockerfile"
+	// SupervisorBaseImage and supervisorCmd must be kept the same as the
+	// ones in SupervisorDockerfile.
+	SupervisorBaseImage = "alpine:3.7"
+	supervisorCmd       = "/app/function -laddr=${LISTEN_ADDR} -drain-timeout=${DRAIN_TIMEOUT:-30s}"
+)
+
+// supervisorDockerfile is the content of SupervisorDockerfile, it is used
+// out of the repository.
+var supervisorDockerfile = `FROM ` + SupervisorBaseImage + `
+COPY ./bin/function /app/function
+ARG listen_addr
+ENV LISTEN_ADDR=$listen_addr
+CMD ` + supervisorCmd + `
+`
+
+// ParsePlatforms parses the platforms like linux/amd64,linux/arm/v7.
+func ParsePlatforms(s string) ([]oci.Platform, error) {
+	platforms := []oci.Platform{}
+	seen := map[oci.Platform]bool{}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("%q is not like os/arch[/variant]", item)
+		}
+		platform := oci.Platform{OS: parts[0], Architecture: parts[1]}
+		if len(parts) == 3 {
+			platform.Variant = parts[2]
+		}
+		if platform.OS != GOOS {
+			return nil, fmt.Errorf("%q: the functions run on %s only", item, GOOS)
+		}
+		if !containsString(GOARCHes, platform.Architecture) {
+			return nil, fmt.Errorf("%q: unsupported architecture, want one of: %s", item, strings.Join(GOARCHes, "|"))
+		}
+		if _, err := goarm(platform); err != nil {
+			return nil, fmt.Errorf("%q: %v", item, err)
+		}
+		if !seen[platform] {
+			seen[platform] = true
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms, nil
+}
+
+// goarm returns the GOARM of the variant, e.g. 7 for arm/v7.
+func goarm(platform oci.Platform) (string, error) {
+	if platform.Variant == "" {
+		return "", nil
+	}
+	if platform.Architecture == "arm" {
+		switch platform.Variant {
+		case "v5", "v6", "v7":
+			return platform.Variant[1:], nil
+		}
+	}
+	if platform.Architecture == "arm64" && platform.Variant == "v8" {
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported variant %q", platform.Variant)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleFiles returns the files of the build module keyed by the slash
+// separated paths: the source tree, the rewritten function package, the
+// generated main, and a go.mod if the function is not a Go module. The
+// functions in the wasm runtime have the generated host package as well.
+func ModuleFiles(source *Source) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for name, content := range source.Tree {
+		if path.Dir(name) == source.Dir && strings.HasSuffix(name, ".go") {
+			continue // Rewritten below.
+		}
+		files[name] = content
+	}
+	for name, content := range source.Files {
+		files[path.Join(source.Dir, name)] = content
+	}
+	if source.Module == "" {
+		gomod := fmt.Sprintf("module useless.local/%s\n\ngo 1.21\n", strings.ToLower(source.Sig.Name))
+		files["go.mod"] = []byte(gomod)
+	}
+
+	sig := source.Sig
+	tpl := maintpl
+	if source.Runtime == uselessv1.WasmRuntime {
+		tpl = wasmmaintpl
+		host, err := renderTemplate(wasmhosttpl, struct {
+			FuncName   string
+			Stream     bool
+			ModuleFile string
+		}{
+			FuncName:   sig.Name,
+			Stream:     sig.Kind == StreamFunc,
+			ModuleFile: wasmModuleFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		files[path.Join(source.Dir, WasmHostDir, "main.go")] = host
+	}
+	main, err := renderTemplate(tpl, struct {
+		FuncName string
+		Adapter  string
+		Imports  []ImportSpec
+	}{
+		FuncName: sig.Name,
+		Adapter:  sig.Adapter(),
+		Imports:  sig.AdapterImports(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	files[path.Join(source.Dir, MainFile)] = main
+	return files, nil
+}
+
+func renderTemplate(tplstr string, args interface{}) ([]byte, error) {
+	tpl := template.Must(template.New("TODO").Parse(tplstr))
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, args); err != nil {
+		return nil, fmt.Errorf("generate code: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFiles writes the files keyed by the slash separated paths into dir.
+func WriteFiles(dir string, files map[string][]byte) error {
+	for name, content := range files {
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(fpath, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Builder compiles the functions against the runtime.
+type Builder struct {
+	// RepoDir is the root of this repository, the runtime is built from it
+	// by a replace directive if it is set, which is for developing the
+	// runtime in the repository.
+	RepoDir string
+	// RuntimeVersion is the released version of the runtime required by
+	// the functions if RepoDir is empty, e.g. v0.3.0.
+	RuntimeVersion string
+	// BuildDir is where the build module is generated, the binaries are
+	// written next to it.
+	BuildDir string
+	// CreatedBy is recorded in the history of the images.
+	CreatedBy string
+	// Log receives the progress and the output of the go commands, it is
+	// os.Stderr if nil.
+	Log io.Writer
+}
+
+// Prepare writes the build module, and resolves its dependencies.
+func (b *Builder) Prepare(files map[string][]byte) error {
+	if err := os.RemoveAll(b.BuildDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rm -rf %s: %v", b.BuildDir, err)
+	}
+	if err := WriteFiles(b.BuildDir, files); err != nil {
+		return err
+	}
+	args := []string{"mod", "edit", "-require=" + UselessModule + "@" + b.RuntimeVersion}
+	if b.RepoDir != "" {
+		args = []string{"mod", "edit", "-require=" + UselessModule + "@v0.0.0",
+			"-replace=" + UselessModule + "=" + b.RepoDir}
+	} else if b.RuntimeVersion == "" {
+		return fmt.Errorf("no version of %s is required", UselessModule)
+	}
+	if err := b.run(nil, "go", args...); err != nil {
+		return err
+	}
+	return b.run([]string{"GOFLAGS=-mod=mod"}, "go", "mod", "tidy")
+}
+
+// Dockerfile returns the path of the SupervisorDockerfile, it is written
+// next to the BuildDir out of the repository.
+func (b *Builder) Dockerfile() (string, error) {
+	if b.RepoDir != "" {
+		return filepath.Join(b.RepoDir, SupervisorDockerfile), nil
+	}
+	fpath := filepath.Join(filepath.Dir(b.BuildDir), "supervisor.Dockerfile")
+	if err := ioutil.WriteFile(fpath, []byte(supervisorDockerfile), 0644); err != nil {
+		return "", err
+	}
+	return fpath, nil
+}
+
+// Compile cross compiles the function in the build module for the platform,
+// the function in the wasm runtime is compiled to WebAssembly, and then the
+// host which embeds it is compiled for the platform.
+func (b *Builder) Compile(source *Source, platform oci.Platform, output string) error {
+	binary, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	arm, err := goarm(platform)
+	if err != nil {
+		return err
+	}
+	envs := []string{"GOOS=" + platform.OS, "GOARCH=" + platform.Architecture,
+		"GO111MODULE=on", "GOFLAGS=-mod=mod", "CGO_ENABLED=0"}
+	if arm != "" {
+		envs = append(envs, "GOARM="+arm)
+	}
+	if source.Runtime != uselessv1.WasmRuntime {
+		return b.run(envs, "go", "build", "-o", binary, "./"+source.Dir)
+	}
+
+	hostDir := path.Join(source.Dir, WasmHostDir)
+	module := filepath.Join(b.BuildDir, filepath.FromSlash(hostDir), wasmModuleFile)
+	if err := b.run([]string{"GOOS=wasip1", "GOARCH=wasm", "GO111MODULE=on", "GOFLAGS=-mod=mod"},
+		"go", "build", "-o", module, "./"+source.Dir); err != nil {
+		return err
+	}
+	return b.run(envs, "go", "build", "-o", binary, "./"+hostDir)
+}
+
+// BuildOCI compiles the function and assembles its image in the layout, the
+// same as the SupervisorDockerfile does, the image is an index of the
+// images of the platforms if there are more than one platforms. The image is
+// tagged as name in the layout. The build module must have been prepared.
+func (b *Builder) BuildOCI(source *Source, layout *oci.Layout, client *oci.Client,
+	platforms []oci.Platform, name string) (oci.Descriptor, error) {
+
+	images := []*oci.Image{}
+	for _, platform := range platforms {
+		img, err := b.assembleOCI(source, layout, client, platform)
+		if err != nil {
+			return oci.Descriptor{}, err
+		}
+		images = append(images, img)
+	}
+	desc := images[0].Descriptor
+	if len(images) > 1 {
+		var err error
+		if desc, err = layout.AssembleIndex(images); err != nil {
+			return oci.Descriptor{}, fmt.Errorf("assemble index: %v", err)
+		}
+	}
+	return desc, layout.Tag(desc, name)
+}
+
+// assembleOCI compiles the function for the platform, and then assembles
+// the image of the platform.
+func (b *Builder) assembleOCI(source *Source, layout *oci.Layout, client *oci.Client, platform oci.Platform) (*oci.Image, error) {
+	baseRef, err := oci.ParseReference(SupervisorBaseImage)
+	if err != nil {
+		return nil, err
+	}
+	var base *oci.Image
+	if desc, err := layout.Resolve(baseRef.String(), &platform); err == nil {
+		if base, err = layout.Image(desc); err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Fprintf(b.log(), "Pulling base image: %s for %s\n", baseRef, platform)
+		if base, err = client.Pull(baseRef, platform, layout); err != nil {
+			return nil, fmt.Errorf("pull base image: %v", err)
+		}
+	}
+
+	binaryPath := filepath.Join(filepath.Dir(b.BuildDir),
+		"function-"+strings.Replace(platform.String(), "/", "-", -1))
+	if err := b.Compile(source, platform, binaryPath); err != nil {
+		return nil, err
+	}
+	binary, err := ioutil.ReadFile(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := oci.NewLayer([]oci.File{{Path: "/app/function", Mode: 0755, Content: binary}})
+	if err != nil {
+		return nil, fmt.Errorf("pack layer: %v", err)
+	}
+	img, err := layout.Assemble(base, platform, []*oci.Layer{layer}, b.CreatedBy,
+		func(config *oci.ImageConfig) {
+			config.SetEnv("LISTEN_ADDR", ":80")
+			config.Entrypoint = nil
+			config.Cmd = []string{"/bin/sh", "-c", supervisorCmd}
+		})
+	if err != nil {
+		return nil, fmt.Errorf("assemble image: %v", err)
+	}
+	return img, nil
+}
+
+func (b *Builder) log() io.Writer {
+	if b.Log == nil {
+		return os.Stderr
+	}
+	return b.Log
+}
+
+// run runs the command in the build module with the extra environment
+// variables.
+func (b *Builder) run(envs []string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = b.BuildDir
+	cmd.Env = append(os.Environ(), envs...)
+	cmd.Stdout = b.log()
+	cmd.Stderr = b.log()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
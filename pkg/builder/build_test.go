@@ -0,0 +1,95 @@
+package builder
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/damnever/useless/pkg/oci"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	testCases := []struct {
+		platforms string
+		expected  []oci.Platform
+		goarm     []string
+	}{
+		{
+			platforms: "linux/amd64",
+			expected:  []oci.Platform{{OS: "linux", Architecture: "amd64"}},
+			goarm:     []string{""},
+		},
+		{
+			platforms: "linux/amd64, linux/arm/v7,linux/arm64/v8,linux/amd64",
+			expected: []oci.Platform{
+				{OS: "linux", Architecture: "amd64"},
+				{OS: "linux", Architecture: "arm", Variant: "v7"},
+				{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			},
+			goarm: []string{"", "7", ""},
+		},
+		{platforms: ""},
+		{platforms: "linux"},
+		{platforms: "linux/arm/v7/x"},
+		{platforms: "windows/amd64"},
+		{platforms: "linux/mips"},
+		{platforms: "linux/arm/v8"},
+		{platforms: "linux/amd64/v2"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.platforms, func(t *testing.T) {
+			platforms, err := ParsePlatforms(tc.platforms)
+			if tc.expected == nil {
+				if err == nil {
+					t.Errorf("expected error, got %v", platforms)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(platforms, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, platforms)
+			}
+			for i, platform := range platforms {
+				if arm, _ := goarm(platform); arm != tc.goarm[i] {
+					t.Errorf("expected GOARM %q of %s, got %q", tc.goarm[i], platform, arm)
+				}
+			}
+		})
+	}
+}
+
+func TestSupervisorDockerfile(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("..", "..", SupervisorDockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != supervisorDockerfile {
+		t.Errorf("expected the same as %s:\n%s\ngot:\n%s", SupervisorDockerfile, content, supervisorDockerfile)
+	}
+}
+
+func TestImageTagReleasedRuntime(t *testing.T) {
+	files := map[string][]byte{"go.mod": []byte("module useless.local/hello\n")}
+	platforms := []oci.Platform{{OS: GOOS, Architecture: DefaultGOARCH}}
+	tag, err := (&Builder{RuntimeVersion: "v0.3.0"}).ImageTag(files, platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := (&Builder{RuntimeVersion: "v0.3.1"}).ImageTag(files, platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag == other {
+		t.Errorf("expected a new tag for another runtime version, got %s", tag)
+	}
+	repo, err := (&Builder{RepoDir: filepath.Join("..", "..")}).ImageTag(files, platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo == tag {
+		t.Errorf("expected a new tag for the runtime of the repository, got %s", repo)
+	}
+}
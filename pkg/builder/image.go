@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/damnever/useless/pkg/oci"
+)
+
+// imageTagLen is the number of the hex digits of the hash in the tag.
+const imageTagLen = 20
+
+// ImageRepo returns the repository of the function image.
+func ImageRepo(funcName, registry string) string {
+	return fmt.Sprintf("%s/%s", registry, strings.ToLower(funcName))
+}
+
+// ImageTag hashes everything which goes into the image: the platforms, the
+// build module, the Go version, the runtime and the Dockerfile, so the same
+// tag means the same image.
+func (b *Builder) ImageTag(files map[string][]byte, platforms []oci.Platform) (string, error) {
+	out, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("go version: %v", err)
+	}
+	// e.g. go version go1.12.9 linux/amd64, the host platform is irrelevant.
+	goVersion := strings.TrimSpace(string(out))
+	if fields := strings.Fields(goVersion); len(fields) >= 3 {
+		goVersion = fields[2]
+	}
+	runtimeFiles, err := b.runtimeFiles()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	names := []string{}
+	for _, platform := range platforms {
+		names = append(names, platform.String())
+	}
+	sort.Strings(names)
+	fmt.Fprintf(h, "platforms %s\n", strings.Join(names, ","))
+	fmt.Fprintf(h, "go %s\n", goVersion)
+	hashFiles(h, "source", files)
+	hashFiles(h, "runtime", runtimeFiles)
+	return hex.EncodeToString(h.Sum(nil))[:imageTagLen], nil
+}
+
+// runtimeFiles returns the files of the runtime, see Builder.Prepare. The
+// released runtime is identified by its version.
+func (b *Builder) runtimeFiles() (map[string][]byte, error) {
+	if b.RepoDir == "" {
+		return map[string][]byte{
+			"version":            []byte(b.RuntimeVersion),
+			SupervisorDockerfile: []byte(supervisorDockerfile),
+		}, nil
+	}
+
+	runtimeFiles := map[string][]byte{}
+	paths, err := filepath.Glob(filepath.Join(b.RepoDir, "runtime", "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, filepath.Join(b.RepoDir, "go.mod"), filepath.Join(b.RepoDir, "go.sum"),
+		filepath.Join(b.RepoDir, SupervisorDockerfile))
+	for _, fpath := range paths {
+		if strings.HasSuffix(fpath, "_test.go") {
+			continue
+		}
+		content, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return nil, fmt.Errorf("read the runtime, %s must be the root of the repository: %v", b.RepoDir, err)
+		}
+		rel, _ := filepath.Rel(b.RepoDir, fpath)
+		runtimeFiles[filepath.ToSlash(rel)] = content
+	}
+	return runtimeFiles, nil
+}
+
+// hashFiles writes the files in order, the lengths keep the boundaries.
+func hashFiles(h hash.Hash, kind string, files map[string][]byte) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %q %d\n", kind, name, len(files[name]))
+		h.Write(files[name])
+	}
+}
@@ -0,0 +1,425 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	gobuild "go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind is the kind of the function signature, it is stored in the annotation
+// of the Function so the callers know how to encode the input.
+type Kind string
+
+const (
+	// StringFunc is like func([ctx context.Context,] input string) (string, error).
+	StringFunc Kind = "string"
+	// TypedFunc is like func([ctx context.Context,] in In) (Out, error).
+	TypedFunc Kind = "typed JSON"
+	// StreamFunc is like func([ctx context.Context,] r io.Reader, w io.Writer) error.
+	StreamFunc Kind = "streaming"
+)
+
+const supportedSignatures = `
+	func([ctx context.Context,] input string) (output string, err error)
+	func([ctx context.Context,] in In) (out Out, err error)
+	func([ctx context.Context,] r io.Reader, w io.Writer) error`
+
+// ImportSpec is an import of the generated main package.
+type ImportSpec struct {
+	Name string
+	Path string
+}
+
+// Signature describes the function to be served by the supervisor.
+type Signature struct {
+	Name    string
+	Kind    Kind
+	Context bool
+	// In and Out are the type expressions of the typed function, which can
+	// be used by the generated main package along with the Imports.
+	In      string
+	Out     string
+	Imports []ImportSpec
+}
+
+// Adapter returns the expression which can be passed to NewSupervisor.
+func (s *Signature) Adapter() string {
+	if s.Context {
+		return s.Name
+	}
+	switch s.Kind {
+	case StringFunc:
+		return fmt.Sprintf(`func(_ context.Context, input string) (string, error) {
+		return %s(input)
+	}`, s.Name)
+	case StreamFunc:
+		return fmt.Sprintf(`func(_ context.Context, r io.Reader, w io.Writer) error {
+		return %s(r, w)
+	}`, s.Name)
+	default:
+		return fmt.Sprintf(`func(_ context.Context, in %s) (%s, error) {
+		return %s(in)
+	}`, s.In, s.Out, s.Name)
+	}
+}
+
+// AdapterImports returns the imports required by the Adapter.
+func (s *Signature) AdapterImports() []ImportSpec {
+	if s.Context {
+		return nil
+	}
+	imports := []ImportSpec{{Name: "context", Path: "context"}}
+	if s.Kind == StreamFunc {
+		imports = append(imports, ImportSpec{Name: "io", Path: "io"})
+	}
+	return append(imports, s.Imports...)
+}
+
+// diagnostic is an error which points at the offending source.
+type diagnostic struct {
+	pos token.Position
+	msg string
+}
+
+func (d *diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.pos, d.msg)
+}
+
+func diagnosef(fset *token.FileSet, pos token.Pos, format string, a ...interface{}) error {
+	return &diagnostic{pos: fset.Position(pos), msg: fmt.Sprintf(format, a...)}
+}
+
+// maxDiagnostics is the maximum number of the type errors to report.
+const maxDiagnostics = 10
+
+// diagnostics is the type errors of the function package.
+type diagnostics []error
+
+func (ds diagnostics) Error() string {
+	msgs := []string{}
+	for _, d := range ds {
+		msgs = append(msgs, d.Error())
+	}
+	if len(ds) >= maxDiagnostics {
+		msgs = append(msgs, "too many errors")
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// parseSignature type checks the files of the function package, the imports
+// are resolved in dir, then it finds the function named name and classifies
+// its signature.
+func parseSignature(fset *token.FileSet, files []*ast.File, name, dir string) (*Signature, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files")
+	}
+	switch name {
+	case "main", "init":
+		return nil, fmt.Errorf("%s can not be used as the function, it is reserved", name)
+	}
+
+	var decl *ast.FuncDecl
+	funcs := []string{}
+	for _, file := range files {
+		for _, d := range file.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if fd.Recv != nil {
+				if fd.Name.Name == name {
+					return nil, diagnosef(fset, fd.Name.Pos(), "%s is a method, a function is required", name)
+				}
+				continue
+			}
+			switch fd.Name.Name {
+			case name:
+				decl = fd
+			case "main":
+				return nil, diagnosef(fset, fd.Name.Pos(), "func main is reserved by the supervisor")
+			}
+			funcs = append(funcs, fd.Name.Name)
+		}
+	}
+	if decl == nil {
+		sort.Strings(funcs)
+		return nil, diagnosef(fset, files[0].Package, "function %s not found, the functions are: [%s]",
+			name, strings.Join(funcs, ", "))
+	}
+
+	// The whole package is type checked, the errors would break the build
+	// anyway, it is better to point them out now, except the imports which
+	// may be resolved by the generated build module later.
+	pkg := types.NewPackage("main", files[0].Name.Name)
+	info := &types.Info{Defs: map[*ast.Ident]types.Object{}}
+	var errs diagnostics
+	imp := newSourceImporter(fset, dir)
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			terr, ok := err.(types.Error)
+			if ok && !terr.Soft && len(errs) < maxDiagnostics && !imp.onFailedImport(files, terr.Pos) {
+				errs = append(errs, &diagnostic{pos: fset.Position(terr.Pos), msg: terr.Msg})
+			}
+		},
+	}
+	_ = types.NewChecker(&conf, fset, pkg, info).Files(files)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	sig := info.Defs[decl.Name].Type().(*types.Signature)
+	return classifySignature(fset, decl, pkg, sig)
+}
+
+func classifySignature(fset *token.FileSet, decl *ast.FuncDecl, pkg *types.Package, sig *types.Signature) (*Signature, error) {
+	s := &Signature{Name: decl.Name.Name}
+	unsupported := func() error {
+		return diagnosef(fset, decl.Type.Pos(), "unsupported signature %s of %s, want one of:%s",
+			types.TypeString(sig, types.RelativeTo(pkg)), s.Name, supportedSignatures)
+	}
+	if sig.Variadic() {
+		return nil, unsupported()
+	}
+
+	params := []types.Type{}
+	for i := 0; i < sig.Params().Len(); i++ {
+		params = append(params, sig.Params().At(i).Type())
+	}
+	results := []types.Type{}
+	for i := 0; i < sig.Results().Len(); i++ {
+		results = append(results, sig.Results().At(i).Type())
+	}
+	if len(params) > 0 && isNamed(params[0], "context", "Context") {
+		s.Context = true
+		params = params[1:]
+	}
+	if len(results) == 0 || !types.Identical(results[len(results)-1], types.Universe.Lookup("error").Type()) {
+		return nil, diagnosef(fset, decl.Type.Pos(), "the last result of %s must be an error", s.Name)
+	}
+
+	switch {
+	case len(params) == 2 && len(results) == 1 &&
+		isNamed(params[0], "io", "Reader") && isNamed(params[1], "io", "Writer"):
+		s.Kind = StreamFunc
+	case len(params) == 1 && len(results) == 2 &&
+		types.Identical(params[0], types.Typ[types.String]) && types.Identical(results[0], types.Typ[types.String]):
+		s.Kind = StringFunc
+	case len(params) == 1 && len(results) == 2:
+		s.Kind = TypedFunc
+		if err := checkJSONType(params[0], true); err != nil {
+			return nil, diagnosef(fset, decl.Type.Params.Pos(), "input of %s: %v", s.Name, err)
+		}
+		if err := checkJSONType(results[0], false); err != nil {
+			return nil, diagnosef(fset, decl.Type.Results.Pos(), "output of %s: %v", s.Name, err)
+		}
+		if !s.Context && (!isResolved(params[0]) || !isResolved(results[0])) {
+			return nil, diagnosef(fset, decl.Type.Pos(), "can not resolve the input or output types of %s, "+
+				"make sure the imports are available, or take a context.Context", s.Name)
+		}
+		s.In, s.Out, s.Imports = typeExprs(pkg, params[0], results[0])
+	default:
+		return nil, unsupported()
+	}
+	return s, nil
+}
+
+func isNamed(t types.Type, pkgPath, name string) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == pkgPath && obj.Name() == name
+}
+
+// isResolved reports whether t is free of the invalid types, which come
+// from the imports can not be resolved.
+func isResolved(t types.Type) bool {
+	return isResolvedType(t, map[types.Type]bool{})
+}
+
+func isResolvedType(t types.Type, seen map[types.Type]bool) bool {
+	if seen[t] { // The recursive types.
+		return true
+	}
+	seen[t] = true
+	switch t := t.(type) {
+	case *types.Basic:
+		return t != types.Typ[types.Invalid]
+	case *types.Pointer:
+		return isResolvedType(t.Elem(), seen)
+	case *types.Slice:
+		return isResolvedType(t.Elem(), seen)
+	case *types.Array:
+		return isResolvedType(t.Elem(), seen)
+	case *types.Chan:
+		return isResolvedType(t.Elem(), seen)
+	case *types.Map:
+		return isResolvedType(t.Key(), seen) && isResolvedType(t.Elem(), seen)
+	case *types.Named:
+		return isResolvedType(t.Underlying(), seen)
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if !isResolvedType(t.Field(i).Type(), seen) {
+				return false
+			}
+		}
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			if !isResolvedType(t.At(i).Type(), seen) {
+				return false
+			}
+		}
+	case *types.Signature:
+		return isResolvedType(t.Params(), seen) && isResolvedType(t.Results(), seen)
+	case *types.Interface:
+		for i := 0; i < t.NumMethods(); i++ {
+			if !isResolvedType(t.Method(i).Type(), seen) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sourceImporter imports the packages from their sources, the same as the
+// "source" importer of go/importer, but with its own copy of the build
+// context whose Dir is the root of the function module, go list resolves
+// the imports of the module from there.
+type sourceImporter struct {
+	ctxt     gobuild.Context
+	fset     *token.FileSet
+	packages map[string]*types.Package
+	// failed is the import paths which can not be imported.
+	failed map[string]bool
+}
+
+func newSourceImporter(fset *token.FileSet, dir string) *sourceImporter {
+	ctxt := gobuild.Default
+	ctxt.Dir = dir
+	// The cgo files would need the cgo command to be type checked.
+	ctxt.CgoEnabled = false
+	return &sourceImporter{
+		ctxt:     ctxt,
+		fset:     fset,
+		packages: map[string]*types.Package{},
+		failed:   map[string]bool{},
+	}
+}
+
+// onFailedImport reports whether pos is on an import spec of the files which
+// has failed to be imported.
+func (imp *sourceImporter) onFailedImport(files []*ast.File, pos token.Pos) bool {
+	for _, file := range files {
+		for _, spec := range file.Imports {
+			if pos < spec.Pos() || pos >= spec.End() {
+				continue
+			}
+			path, err := strconv.Unquote(spec.Path.Value)
+			return err == nil && imp.failed[path]
+		}
+	}
+	return false
+}
+
+func (imp *sourceImporter) Import(path string) (*types.Package, error) {
+	return imp.ImportFrom(path, imp.ctxt.Dir, 0)
+}
+
+func (imp *sourceImporter) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	pkg, err := imp.importFrom(path, srcDir, mode)
+	if err != nil {
+		imp.failed[path] = true
+	}
+	return pkg, err
+}
+
+func (imp *sourceImporter) importFrom(path, srcDir string, _ types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	bp, err := imp.ctxt.Import(path, srcDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pkg, ok := imp.packages[bp.ImportPath]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through package %q", bp.ImportPath)
+		}
+		return pkg, nil
+	}
+	imp.packages[bp.ImportPath] = nil
+
+	files := make([]*ast.File, 0, len(bp.GoFiles))
+	for _, name := range bp.GoFiles {
+		file, err := parser.ParseFile(imp.fset, filepath.Join(bp.Dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	var firstErr error
+	conf := types.Config{
+		Importer:         imp,
+		IgnoreFuncBodies: true,
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); firstErr == nil && ok && !terr.Soft {
+				firstErr = err
+			}
+		},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, imp.fset, files, nil)
+	if firstErr != nil {
+		return nil, fmt.Errorf("type checking package %q: %v", bp.ImportPath, firstErr)
+	}
+	imp.packages[bp.ImportPath] = pkg
+	return pkg, nil
+}
+
+// checkJSONType reports the types which encoding/json can not deal with.
+func checkJSONType(t types.Type, decoding bool) error {
+	switch u := t.Underlying().(type) {
+	case *types.Chan, *types.Signature:
+		return fmt.Errorf("%s can not be encoded as JSON", t)
+	case *types.Basic:
+		if u.Info()&types.IsComplex != 0 || u.Kind() == types.UnsafePointer {
+			return fmt.Errorf("%s can not be encoded as JSON", t)
+		}
+	case *types.Interface:
+		if decoding && !u.Empty() {
+			return fmt.Errorf("can not decode JSON into the non-empty interface %s", t)
+		}
+	}
+	return nil
+}
+
+// typeExprs returns the type expressions of in and out, and the imports
+// they need, the imports are renamed to avoid the conflicts.
+func typeExprs(pkg *types.Package, in, out types.Type) (string, string, []ImportSpec) {
+	names := map[string]string{}
+	imports := []ImportSpec{}
+	qualifier := func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		name, ok := names[p.Path()]
+		if !ok {
+			name = fmt.Sprintf("%s%d", p.Name(), len(names))
+			names[p.Path()] = name
+			imports = append(imports, ImportSpec{Name: name, Path: p.Path()})
+		}
+		return name
+	}
+	var inExpr, outExpr bytes.Buffer
+	types.WriteType(&inExpr, in, qualifier)
+	types.WriteType(&outExpr, out, qualifier)
+	return inExpr.String(), outExpr.String(), imports
+}
@@ -0,0 +1,240 @@
+package builder
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseSnippet parses the source of package fn, and parses the signature of
+// the function F, the imports are resolved from the file path.
+func parseSnippet(t *testing.T, src string) (*Signature, error) {
+	t.Helper()
+	dir := t.TempDir()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filepath.Join(dir, "fn.go"), "package fn\n\n"+src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseSignature(fset, []*ast.File{file}, "F", dir)
+}
+
+func TestClassifySignature(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src      string
+		expected *Signature
+	}{
+		{
+			name:     "string",
+			src:      `func F(input string) (string, error) { return input, nil }`,
+			expected: &Signature{Name: "F", Kind: StringFunc},
+		},
+		{
+			name: "string with context",
+			src: `import "context"
+
+func F(ctx context.Context, input string) (string, error) { return input, nil }`,
+			expected: &Signature{Name: "F", Kind: StringFunc, Context: true},
+		},
+		{
+			name: "typed",
+			src: `type In struct{ A int }
+
+func F(in In) (*In, error) { return &in, nil }`,
+			expected: &Signature{Name: "F", Kind: TypedFunc, In: "In", Out: "*In", Imports: []ImportSpec{}},
+		},
+		{
+			name: "typed with imports",
+			src: `import "time"
+
+func F(d time.Duration) (map[string]time.Time, error) { return nil, nil }`,
+			expected: &Signature{
+				Name: "F", Kind: TypedFunc, In: "time0.Duration", Out: "map[string]time0.Time",
+				Imports: []ImportSpec{{Name: "time0", Path: "time"}},
+			},
+		},
+		{
+			name: "typed with context",
+			src: `import "context"
+
+func F(ctx context.Context, nums []int) (int, error) { return len(nums), nil }`,
+			expected: &Signature{Name: "F", Kind: TypedFunc, Context: true, In: "[]int", Out: "int", Imports: []ImportSpec{}},
+		},
+		{
+			name: "failed import",
+			src: `import (
+	"context"
+
+	"example.com/missing"
+)
+
+func F(ctx context.Context, input string) (string, error) { return missing.Do(input) }`,
+			expected: &Signature{Name: "F", Kind: StringFunc, Context: true},
+		},
+		{
+			name: "stream",
+			src: `import "io"
+
+func F(r io.Reader, w io.Writer) error { return nil }`,
+			expected: &Signature{Name: "F", Kind: StreamFunc},
+		},
+		{
+			name: "stream with context",
+			src: `import (
+	"context"
+	"io"
+)
+
+func F(ctx context.Context, r io.Reader, w io.Writer) error { return nil }`,
+			expected: &Signature{Name: "F", Kind: StreamFunc, Context: true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := parseSnippet(t, tc.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sig, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, sig)
+			}
+		})
+	}
+}
+
+func TestClassifySignatureInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "unsupported",
+			src:  `func F(a, b string) error { return nil }`,
+			err:  "fn.go:3:1: unsupported signature func(a string, b string) error of F, want one of:" + supportedSignatures,
+		},
+		{
+			name: "variadic",
+			src:  `func F(inputs ...string) (string, error) { return "", nil }`,
+			err:  "fn.go:3:1: unsupported signature func(inputs ...string) (string, error) of F",
+		},
+		{
+			name: "no error",
+			src:  `func F(input string) string { return input }`,
+			err:  "fn.go:3:1: the last result of F must be an error",
+		},
+		{
+			name: "channel input",
+			src:  `func F(c chan int) (int, error) { return 0, nil }`,
+			err:  "fn.go:3:7: input of F: chan int can not be encoded as JSON",
+		},
+		{
+			name: "interface input",
+			src:  `func F(s interface{ String() string }) (int, error) { return 0, nil }`,
+			err:  "fn.go:3:7: input of F: can not decode JSON into the non-empty interface",
+		},
+		{
+			name: "function output",
+			src:  `func F(n int) (func(), error) { return nil, nil }`,
+			err:  "fn.go:3:15: output of F: func() can not be encoded as JSON",
+		},
+		{
+			name: "method",
+			src: `type T struct{}
+
+func (T) F(input string) (string, error) { return input, nil }`,
+			err: "fn.go:5:10: F is a method, a function is required",
+		},
+		{
+			name: "not found",
+			src:  `func G(input string) (string, error) { return input, nil }`,
+			err:  "fn.go:1:1: function F not found, the functions are: [G]",
+		},
+		{
+			name: "main",
+			src: `func main() {}
+
+func F(input string) (string, error) { return input, nil }`,
+			err: "fn.go:3:6: func main is reserved by the supervisor",
+		},
+		{
+			name: "type error",
+			src:  `func F(input string) (string, error) { return undefined, nil }`,
+			err:  "fn.go:3:47: undefined: undefined",
+		},
+		{
+			// The imports which can not be resolved are left to the build
+			// module, the other errors are reported.
+			name: "type error with a failed import",
+			src: `import _ "example.com/missing"
+
+func F(input string) (string, error) { return undefined, nil }`,
+			err: "fn.go:5:47: undefined: undefined",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSnippet(t, tc.src)
+			if err == nil || !strings.Contains(err.Error(), string(filepath.Separator)+tc.err) {
+				t.Errorf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		signature *Signature
+		adapter   string
+		imports   []ImportSpec
+	}{
+		{
+			name:      "context",
+			signature: &Signature{Name: "F", Kind: TypedFunc, Context: true, In: "In", Out: "Out"},
+			adapter:   "F",
+		},
+		{
+			name:      "string",
+			signature: &Signature{Name: "F", Kind: StringFunc},
+			adapter: `func(_ context.Context, input string) (string, error) {
+		return F(input)
+	}`,
+			imports: []ImportSpec{{Name: "context", Path: "context"}},
+		},
+		{
+			name:      "stream",
+			signature: &Signature{Name: "F", Kind: StreamFunc},
+			adapter: `func(_ context.Context, r io.Reader, w io.Writer) error {
+		return F(r, w)
+	}`,
+			imports: []ImportSpec{{Name: "context", Path: "context"}, {Name: "io", Path: "io"}},
+		},
+		{
+			name: "typed",
+			signature: &Signature{
+				Name: "F", Kind: TypedFunc, In: "time0.Duration", Out: "[]In",
+				Imports: []ImportSpec{{Name: "time0", Path: "time"}},
+			},
+			adapter: `func(_ context.Context, in time0.Duration) ([]In, error) {
+		return F(in)
+	}`,
+			imports: []ImportSpec{{Name: "context", Path: "context"}, {Name: "time0", Path: "time"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if adapter := tc.signature.Adapter(); adapter != tc.adapter {
+				t.Errorf("expected the adapter:\n%s\ngot:\n%s", tc.adapter, adapter)
+			}
+			if imports := tc.signature.AdapterImports(); !reflect.DeepEqual(imports, tc.imports) {
+				t.Errorf("expected the imports %+v, got %+v", tc.imports, imports)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	gobuild "go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+const (
+	// MainFile is the name of the generated file which holds func main.
+	MainFile = "zz_useless_main.go"
+	// WasmHostDir is the generated package in the function directory, which
+	// runs the WebAssembly module of the function in the wasm runtime.
+	WasmHostDir = "zz_useless_host"
+	// MaxSourceSize limits the size of the source tree, which is stored in
+	// the Function resource.
+	MaxSourceSize = 512 << 10
+	// UselessModule is the module of the runtime, the functions inside it,
+	// e.g. the examples, are not treated as the module projects.
+	UselessModule = "github.com/damnever/useless"
+)
+
+// Source is the source tree of the function.
+type Source struct {
+	Sig *Signature
+	// Module is the module path if the function is in a Go module.
+	Module string
+	// Dir is the slash separated directory of the function package relative
+	// to the root of the Tree.
+	Dir string
+	// Tree is the original files keyed by the slash separated paths.
+	Tree map[string][]byte
+	// Files are the sources of the function package rewritten into package
+	// main, keyed by the file names.
+	Files map[string][]byte
+	// FuncFile is the name of the file which declares the function.
+	FuncFile string
+	// Runtime is where the function runs, the function is compiled to
+	// WebAssembly for the wasm runtime.
+	Runtime uselessv1.FunctionRuntime
+}
+
+// Content returns the source of the file which declares the function.
+func (s *Source) Content() string {
+	return string(s.Tree[path.Join(s.Dir, s.FuncFile)])
+}
+
+// Spec returns the source tree stored in the Function resource.
+func (s *Source) Spec() *uselessv1.FunctionSource {
+	files := map[string]string{}
+	for name, content := range s.Tree {
+		files[name] = string(content)
+	}
+	return &uselessv1.FunctionSource{Module: s.Module, Dir: s.Dir, Function: s.Sig.Name, Files: files}
+}
+
+// LoadSource loads the function from a single Go file or from all the Go
+// files of a directory which are built for any of the image platforms, and
+// then rewrites the package clauses into package main, the imports, the
+// comments and the build constraints are kept as they are.
+//
+// If the function is in a Go module, the module tree is loaded as well, so
+// the function can import the other packages of the module.
+func LoadSource(fpath, name string) (*Source, error) {
+	fpath, err := filepath.Abs(fpath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	dir, paths := fpath, []string{fpath}
+	if info.IsDir() {
+		if paths, err = packageFiles(dir); err != nil {
+			return nil, err
+		}
+	} else {
+		dir = filepath.Dir(fpath)
+	}
+
+	source := &Source{Tree: map[string][]byte{}, Files: map[string][]byte{}}
+	root := dir
+	if modRoot, module, err := findModule(dir); err != nil {
+		return nil, err
+	} else if modRoot != "" && module != UselessModule {
+		root, source.Module = modRoot, module
+		if err := loadTree(root, dir, source.Tree); err != nil {
+			return nil, err
+		}
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	source.Dir = filepath.ToSlash(rel)
+
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	for _, p := range paths {
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(fset, p, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 && file.Name.Name != files[0].Name.Name {
+			return nil, diagnosef(fset, file.Name.Pos(), "found package %s, but %s in the other files",
+				file.Name.Name, files[0].Name.Name)
+		}
+		if filepath.Base(p) == MainFile {
+			return nil, diagnosef(fset, file.Package, "file name %s is reserved by the supervisor", MainFile)
+		}
+		files = append(files, file)
+		source.Tree[path.Join(source.Dir, filepath.Base(p))] = src
+	}
+	// The signature is checked with the files of the default platform, the
+	// files of the other platforms may redeclare the same names.
+	checked := files
+	if info.IsDir() {
+		checked = []*ast.File{}
+		for i, file := range files {
+			if ok, err := matchFile(dir, filepath.Base(paths[i]), DefaultGOARCH); err != nil {
+				return nil, err
+			} else if ok {
+				checked = append(checked, file)
+			}
+		}
+	}
+
+	size := 0
+	for _, content := range source.Tree {
+		size += len(content)
+	}
+	if size > MaxSourceSize {
+		return nil, fmt.Errorf("the source tree in %s is too large: %d > %d bytes", root, size, MaxSourceSize)
+	}
+
+	if source.Sig, err = parseSignature(fset, checked, name, root); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		filename := fset.Position(file.Package).Filename
+		if declaresFunc(file, name) {
+			source.FuncFile = filepath.Base(filename)
+		}
+		file.Name.Name = "main"
+		var buf bytes.Buffer
+		if err := format.Node(&buf, fset, file); err != nil {
+			return nil, fmt.Errorf("rewriting %s: %v", filename, err)
+		}
+		source.Files[filepath.Base(filename)] = buf.Bytes()
+	}
+	return source, nil
+}
+
+// uninterpretablePackages are the packages of the standard library which
+// can not be imported by the interpreted functions.
+var uninterpretablePackages = []string{"C", "os/exec", "plugin", "syscall", "unsafe"}
+
+// CheckInterpretable checks whether the function can be run by the
+// interpreter, which knows the standard library and the runtime only, the
+// other packages of the module are interpreted as well.
+func (s *Source) CheckInterpretable() error {
+	names := []string{}
+	for name := range s.Tree {
+		if strings.HasSuffix(name, ".go") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	fset := token.NewFileSet()
+	for _, name := range names {
+		file, err := parser.ParseFile(fset, name, s.Tree[name], parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, spec := range file.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return err
+			}
+			switch {
+			case containsString(uninterpretablePackages, importPath):
+				return diagnosef(fset, spec.Pos(), "%s can not be imported by the interpreted functions", importPath)
+			case !strings.Contains(strings.Split(importPath, "/")[0], "."):
+				// The standard library.
+			case importPath == UselessModule+"/runtime":
+			case s.Module != "" && (importPath == s.Module || strings.HasPrefix(importPath, s.Module+"/")):
+			default:
+				return diagnosef(fset, spec.Pos(), "%s can not be imported by the interpreted functions, "+
+					"only the standard library, the runtime and the packages of the module can", importPath)
+			}
+		}
+	}
+	return nil
+}
+
+// SourceFromSpec loads the function from the source stored in the Function
+// resource, the tree is written into dir, which should be empty, and then it
+// is loaded by LoadSource, so it is the same as the one loaded by the cli.
+// The functions without the source tree are built from FuncContent.
+func SourceFromSpec(spec *uselessv1.FunctionSpec, dir string) (*Source, error) {
+	tree, funcDir, name := map[string][]byte{}, ".", ""
+	if spec.Source != nil {
+		for name, content := range spec.Source.Files {
+			tree[name] = []byte(content)
+		}
+		funcDir, name = spec.Source.Dir, spec.Source.Function
+	} else if spec.FuncContent != "" {
+		tree[strings.ToLower(spec.FuncName)+".go"] = []byte(spec.FuncContent)
+	}
+	if len(tree) == 0 {
+		return nil, fmt.Errorf("no source of function %s", spec.FuncName)
+	}
+	for name := range tree {
+		if clean := path.Clean(name); path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("invalid source file path %q", name)
+		}
+	}
+	if err := WriteFiles(dir, tree); err != nil {
+		return nil, err
+	}
+	funcPath := filepath.Join(dir, filepath.FromSlash(funcDir))
+	if name == "" {
+		var err error
+		if name, err = findFunc(funcPath, spec.FuncName); err != nil {
+			return nil, err
+		}
+	}
+	source, err := LoadSource(funcPath, name)
+	if err != nil {
+		return nil, err
+	}
+	source.Runtime = spec.Runtime
+	return source, nil
+}
+
+// findFunc finds the Go function whose lower cased name is funcName, the
+// older Functions do not record the name of the Go function.
+func findFunc(dir, funcName string) (string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return "", err
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, d := range file.Decls {
+				if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil && strings.EqualFold(fd.Name.Name, funcName) {
+					return fd.Name.Name, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("no function named like %s in the source", funcName)
+}
+
+func declaresFunc(file *ast.File, name string) bool {
+	for _, d := range file.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// packageFiles returns the Go files in dir which are built for any of the
+// image platforms, the tests are excluded.
+func packageFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		for _, goarch := range GOARCHes {
+			if ok, err := matchFile(dir, name, goarch); err != nil {
+				return nil, err
+			} else if ok {
+				paths = append(paths, filepath.Join(dir, name))
+				break
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// matchFile tells whether the file is built for the architecture.
+func matchFile(dir, name, goarch string) (bool, error) {
+	ctx := gobuild.Default
+	ctx.GOOS, ctx.GOARCH = GOOS, goarch
+	return ctx.MatchFile(dir, name)
+}
+
+// findModule looks for the go.mod in dir and its parents, it returns the
+// root directory and the path of the module.
+func findModule(dir string) (string, string, error) {
+	for {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			module := ModulePath(content)
+			if module == "" {
+				return "", "", fmt.Errorf("no module path in %s", filepath.Join(dir, "go.mod"))
+			}
+			return dir, module, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// ModulePath returns the module path declared in the go.mod content.
+func ModulePath(gomod []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(gomod))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// loadTree loads go.mod, go.sum and the Go files of the module, except the
+// tests, the function package which is loaded by the caller, the vendor
+// directory, the nested modules and the hidden files.
+func loadTree(root, funcDir string, tree map[string][]byte) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if p == root {
+				return nil
+			}
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+				name == "vendor" || name == "testdata" {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "_test.go") {
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") && filepath.Dir(p) == funcDir {
+			return nil
+		}
+		if !strings.HasSuffix(name, ".go") && name != "go.mod" && name != "go.sum" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = content
+		return nil
+	})
+}
@@ -0,0 +1,391 @@
+package builder
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
+)
+
+func TestCheckInterpretable(t *testing.T) {
+	testCases := []struct {
+		name   string
+		module string
+		file   string
+		err    string
+	}{
+		{name: "standard library", file: `import ("context"; "strings")`},
+		{name: "runtime", file: `import "github.com/damnever/useless/runtime"`},
+		{name: "module package", module: "example.com/m", file: `import "example.com/m/util"`},
+		{name: "unsafe", file: `import "unsafe"`, err: "unsafe can not be imported"},
+		{name: "os/exec", file: `import "os/exec"`, err: "os/exec can not be imported"},
+		{name: "third party", module: "example.com/m", file: `import "github.com/pkg/errors"`, err: "only the standard library"},
+		{name: "module prefix", module: "example.com/m", file: `import "example.com/mm"`, err: "only the standard library"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &Source{
+				Module: tc.module,
+				Tree: map[string][]byte{
+					"f.go":      []byte("package f\n\n" + tc.file + "\n"),
+					"README.md": []byte("import \"unsafe\"\n"),
+				},
+			}
+			err := source.CheckInterpretable()
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestModuleFilesWasm(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "echo.go")
+	content := "package echo\n\nimport (\n\t\"context\"\n\t\"io\"\n)\n\n" +
+		"func Echo(ctx context.Context, r io.Reader, w io.Writer) error {\n\t_, err := io.Copy(w, r)\n\treturn err\n}\n"
+	if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := LoadSource(fpath, "Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ModuleFiles(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files[path.Join(source.Dir, WasmHostDir, "main.go")]; ok {
+		t.Error("expected no host package in the native runtime")
+	}
+
+	source.Runtime = uselessv1.WasmRuntime
+	files, err = ModuleFiles(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main := string(files[path.Join(source.Dir, MainFile)]); !strings.Contains(main, "uselessruntime.ServeWasm(") {
+		t.Errorf("expected the main to serve the function by ServeWasm, got:\n%s", main)
+	}
+	host := string(files[path.Join(source.Dir, WasmHostDir, "main.go")])
+	for _, s := range []string{"//go:embed " + wasmModuleFile, "Stream:      true,", `NewSupervisor("Echo"`} {
+		if !strings.Contains(host, s) {
+			t.Errorf("expected the host to contain %q, got:\n%s", s, host)
+		}
+	}
+}
+
+// writeTree writes the files into a temporary directory, and returns it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	tree := map[string][]byte{}
+	for name, content := range files {
+		tree[name] = []byte(content)
+	}
+	if err := WriteFiles(dir, tree); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+const helloSource = `package hello
+
+func Hello(input string) (string, error) { return "hello " + input, nil }
+`
+
+func TestLoadSource(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    map[string]string
+		path     string
+		function string
+		expected []string
+		funcFile string
+		// contains is the content expected in the rewritten files.
+		contains map[string][]string
+	}{
+		{
+			name: "package in comments and raw strings",
+			files: map[string]string{"hello.go": `// Package hello says hello, it is not the package clause:
+// package hello
+package hello
+
+const doc = ` + "`" + `
+package fake
+` + "`" + `
+
+func Hello(input string) (string, error) { return doc + input, nil }
+`},
+			path:     "hello.go",
+			function: "Hello",
+			expected: []string{"hello.go"},
+			funcFile: "hello.go",
+			contains: map[string][]string{"hello.go": {"// package hello\npackage main\n", "\npackage fake\n"}},
+		},
+		{
+			name: "build constraints",
+			files: map[string]string{
+				"hello.go": helloSource,
+				// The files of the other image platforms are kept, the
+				// ones of the default platform are type checked.
+				"arch_amd64.go": "package hello\n\nconst arch = \"amd64\"\n",
+				"arch_arm64.go": "package hello\n\nconst arch = \"arm64\"\n",
+				"ignored.go":    "//go:build ignore\n\npackage main\n\nfunc main() {}\n",
+				"windows.go":    "//go:build windows\n\npackage hello\n\nconst arch = \"windows\"\n",
+				"hello_test.go": "package hello_test\n",
+			},
+			path:     ".",
+			function: "Hello",
+			expected: []string{"arch_amd64.go", "arch_arm64.go", "hello.go"},
+			funcFile: "hello.go",
+		},
+		{
+			name: "multiple files",
+			files: map[string]string{
+				"hello.go": `package hello
+
+func Hello(input string) (string, error) { return greet(input), nil }
+`,
+				"greet.go": "package hello\n\nfunc greet(name string) string { return \"hello \" + name }\n",
+			},
+			path:     ".",
+			function: "Hello",
+			expected: []string{"greet.go", "hello.go"},
+			funcFile: "hello.go",
+			contains: map[string][]string{"greet.go": {"package main\n\nfunc greet("}},
+		},
+		{
+			name:     "unexported",
+			files:    map[string]string{"hello.go": "package hello\n\nfunc hello(input string) (string, error) { return input, nil }\n"},
+			path:     "hello.go",
+			function: "hello",
+			expected: []string{"hello.go"},
+			funcFile: "hello.go",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeTree(t, tc.files)
+			source, err := LoadSource(filepath.Join(dir, tc.path), tc.function)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := sortedKeys(source.Files); !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("expected the files %v, got %v", tc.expected, names)
+			}
+			if names := sortedKeys(source.Tree); !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("expected the tree %v, got %v", tc.expected, names)
+			}
+			if source.FuncFile != tc.funcFile || source.Sig.Name != tc.function {
+				t.Errorf("expected %s in %s, got %s in %s", tc.function, tc.funcFile, source.Sig.Name, source.FuncFile)
+			}
+			for name, content := range source.Files {
+				if !strings.Contains(string(content), "package main\n") {
+					t.Errorf("expected %s rewritten into package main, got:\n%s", name, content)
+				}
+				if string(source.Tree[name]) != tc.files[name] {
+					t.Errorf("expected the original %s in the tree, got:\n%s", name, source.Tree[name])
+				}
+			}
+			for name, contains := range tc.contains {
+				for _, s := range contains {
+					if content := string(source.Files[name]); !strings.Contains(content, s) {
+						t.Errorf("expected %q in %s, got:\n%s", s, name, content)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSourceInvalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    map[string]string
+		path     string
+		function string
+		err      string
+	}{
+		{
+			name:     "missing function",
+			files:    map[string]string{"hello.go": helloSource},
+			path:     "hello.go",
+			function: "Bye",
+			err:      "hello.go:1:1: function Bye not found, the functions are: [Hello]",
+		},
+		{
+			name:     "unexported function",
+			files:    map[string]string{"hello.go": helloSource},
+			path:     "hello.go",
+			function: "hello",
+			err:      "hello.go:1:1: function hello not found, the functions are: [Hello]",
+		},
+		{
+			name: "reserved main file",
+			files: map[string]string{
+				"hello.go": helloSource,
+				MainFile:   "package hello\n",
+			},
+			path:     ".",
+			function: "Hello",
+			err:      MainFile + ":1:1: file name " + MainFile + " is reserved by the supervisor",
+		},
+		{
+			name: "mixed packages",
+			files: map[string]string{
+				"hello.go": helloSource,
+				"other.go": "package other\n",
+			},
+			path:     ".",
+			function: "Hello",
+			err:      "other.go:1:9: found package other, but hello in the other files",
+		},
+		{
+			name:     "no Go files",
+			files:    map[string]string{"hello_test.go": "package hello\n", "README.md": "# hello\n"},
+			path:     ".",
+			function: "Hello",
+			err:      "no Go files in ",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeTree(t, tc.files)
+			_, err := LoadSource(filepath.Join(dir, tc.path), tc.function)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestFindModule(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod":             "module example.com/m\n\ngo 1.21\n",
+		"cmd/fn/fn.go":       "package fn\n",
+		"broken/go.mod":      "go 1.21\n",
+		"broken/fn/fn.go":    "package fn\n",
+		"quoted/go.mod":      "module \"example.com/quoted\"\n",
+		"quoted/fn/fn.go":    "package fn\n",
+		"nomodule/README.md": "\n",
+	})
+	testCases := []struct {
+		dir    string
+		root   string
+		module string
+		err    string
+	}{
+		{dir: ".", root: ".", module: "example.com/m"},
+		{dir: "cmd/fn", root: ".", module: "example.com/m"},
+		{dir: "quoted/fn", root: "quoted", module: "example.com/quoted"},
+		{dir: "broken/fn", err: "no module path in "},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.dir, func(t *testing.T) {
+			root, module, err := findModule(filepath.Join(dir, tc.dir))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root != filepath.Join(dir, tc.root) || module != tc.module {
+				t.Errorf("expected %s in %s, got %s in %s", tc.module, tc.root, module, root)
+			}
+		})
+	}
+
+	// No go.mod up to the root of the file system.
+	if root, module, err := findModule(t.TempDir()); err != nil || root != "" || module != "" {
+		t.Errorf("expected no module, got %q, %q, %v", root, module, err)
+	}
+}
+
+func TestLoadTree(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod":                  "module example.com/m\n",
+		"go.sum":                  "\n",
+		"README.md":               "# m\n",
+		"util/util.go":            "package util\n",
+		"util/util_test.go":       "package util\n",
+		"util/.hidden.go":         "package util\n",
+		"cmd/fn/fn.go":            "package fn\n",
+		"cmd/fn/sub/sub.go":       "package sub\n",
+		"vendor/example.com/v.go": "package v\n",
+		"nested/go.mod":           "module example.com/nested\n",
+		"nested/n.go":             "package nested\n",
+		".git/hooks.go":           "package hooks\n",
+		"_old/old.go":             "package old\n",
+		"testdata/data.go":        "package data\n",
+	})
+	tree := map[string][]byte{}
+	if err := loadTree(dir, filepath.Join(dir, "cmd", "fn"), tree); err != nil {
+		t.Fatal(err)
+	}
+	// The function package is loaded by LoadSource.
+	expected := []string{"cmd/fn/sub/sub.go", "go.mod", "go.sum", "util/util.go"}
+	if names := sortedKeys(tree); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestLoadSourceModule(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod":       "module example.com/m\n\ngo 1.21\n",
+		"util/util.go": "package util\n\nfunc Greet(name string) string { return \"hello \" + name }\n",
+		"cmd/fn/fn.go": `package fn
+
+import "example.com/m/util"
+
+func Hello(input string) (string, error) { return util.Greet(input), nil }
+`,
+	})
+	source, err := LoadSource(filepath.Join(dir, "cmd", "fn"), "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Module != "example.com/m" || source.Dir != "cmd/fn" {
+		t.Errorf("expected cmd/fn of example.com/m, got %s of %s", source.Dir, source.Module)
+	}
+	expected := []string{"cmd/fn/fn.go", "go.mod", "util/util.go"}
+	if names := sortedKeys(source.Tree); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the tree %v, got %v", expected, names)
+	}
+}
+
+func TestLoadSourceTooLarge(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod":       "module example.com/m\n",
+		"data/data.go": "package data\n\nconst Data = `" + strings.Repeat("x", MaxSourceSize) + "`\n",
+		"hello.go":     helloSource,
+	})
+	_, err := LoadSource(filepath.Join(dir, "hello.go"), "Hello")
+	if err == nil || !strings.Contains(err.Error(), "is too large") {
+		t.Errorf("expected the source tree too large, got %v", err)
+	}
+}
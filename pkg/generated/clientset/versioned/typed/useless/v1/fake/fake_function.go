@@ -19,6 +19,8 @@ limitations under the License.
 package fake
 
 import (
+	"context"
+
 	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	labels "k8s.io/apimachinery/pkg/labels"
@@ -34,12 +36,12 @@ type FakeFunctions struct {
 	ns   string
 }
 
-var functionsResource = schema.GroupVersionResource{Group: "useless", Version: "v1", Resource: "functions"}
+var functionsResource = schema.GroupVersionResource{Group: "alphabetical.useless", Version: "v1", Resource: "functions"}
 
-var functionsKind = schema.GroupVersionKind{Group: "useless", Version: "v1", Kind: "Function"}
+var functionsKind = schema.GroupVersionKind{Group: "alphabetical.useless", Version: "v1", Kind: "Function"}
 
 // Get takes name of the function, and returns the corresponding function object, and an error if there is any.
-func (c *FakeFunctions) Get(name string, options v1.GetOptions) (result *uselessv1.Function, err error) {
+func (c *FakeFunctions) Get(ctx context.Context, name string, options v1.GetOptions) (result *uselessv1.Function, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewGetAction(functionsResource, c.ns, name), &uselessv1.Function{})
 
@@ -50,7 +52,7 @@ func (c *FakeFunctions) Get(name string, options v1.GetOptions) (result *useless
 }
 
 // List takes label and field selectors, and returns the list of Functions that match those selectors.
-func (c *FakeFunctions) List(opts v1.ListOptions) (result *uselessv1.FunctionList, err error) {
+func (c *FakeFunctions) List(ctx context.Context, opts v1.ListOptions) (result *uselessv1.FunctionList, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewListAction(functionsResource, functionsKind, c.ns, opts), &uselessv1.FunctionList{})
 
@@ -72,14 +74,14 @@ func (c *FakeFunctions) List(opts v1.ListOptions) (result *uselessv1.FunctionLis
 }
 
 // Watch returns a watch.Interface that watches the requested functions.
-func (c *FakeFunctions) Watch(opts v1.ListOptions) (watch.Interface, error) {
+func (c *FakeFunctions) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
 	return c.Fake.
 		InvokesWatch(testing.NewWatchAction(functionsResource, c.ns, opts))
 
 }
 
 // Create takes the representation of a function and creates it.  Returns the server's representation of the function, and an error, if there is any.
-func (c *FakeFunctions) Create(function *uselessv1.Function) (result *uselessv1.Function, err error) {
+func (c *FakeFunctions) Create(ctx context.Context, function *uselessv1.Function, opts v1.CreateOptions) (result *uselessv1.Function, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewCreateAction(functionsResource, c.ns, function), &uselessv1.Function{})
 
@@ -90,7 +92,7 @@ func (c *FakeFunctions) Create(function *uselessv1.Function) (result *uselessv1.
 }
 
 // Update takes the representation of a function and updates it. Returns the server's representation of the function, and an error, if there is any.
-func (c *FakeFunctions) Update(function *uselessv1.Function) (result *uselessv1.Function, err error) {
+func (c *FakeFunctions) Update(ctx context.Context, function *uselessv1.Function, opts v1.UpdateOptions) (result *uselessv1.Function, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewUpdateAction(functionsResource, c.ns, function), &uselessv1.Function{})
 
@@ -100,24 +102,36 @@ func (c *FakeFunctions) Update(function *uselessv1.Function) (result *uselessv1.
 	return obj.(*uselessv1.Function), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeFunctions) UpdateStatus(ctx context.Context, function *uselessv1.Function, opts v1.UpdateOptions) (*uselessv1.Function, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(functionsResource, "status", c.ns, function), &uselessv1.Function{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*uselessv1.Function), err
+}
+
 // Delete takes name of the function and deletes it. Returns an error if one occurs.
-func (c *FakeFunctions) Delete(name string, options *v1.DeleteOptions) error {
+func (c *FakeFunctions) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
-		Invokes(testing.NewDeleteAction(functionsResource, c.ns, name), &uselessv1.Function{})
+		Invokes(testing.NewDeleteActionWithOptions(functionsResource, c.ns, name, opts), &uselessv1.Function{})
 
 	return err
 }
 
 // DeleteCollection deletes a collection of objects.
-func (c *FakeFunctions) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
-	action := testing.NewDeleteCollectionAction(functionsResource, c.ns, listOptions)
+func (c *FakeFunctions) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(functionsResource, c.ns, listOpts)
 
 	_, err := c.Fake.Invokes(action, &uselessv1.FunctionList{})
 	return err
 }
 
 // Patch applies the patch and returns the patched function.
-func (c *FakeFunctions) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *uselessv1.Function, err error) {
+func (c *FakeFunctions) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *uselessv1.Function, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewPatchSubresourceAction(functionsResource, c.ns, name, pt, data, subresources...), &uselessv1.Function{})
 
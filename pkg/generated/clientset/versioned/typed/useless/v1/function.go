@@ -19,6 +19,7 @@ limitations under the License.
 package v1
 
 import (
+	"context"
 	"time"
 
 	v1 "github.com/damnever/useless/pkg/apis/useless/v1"
@@ -37,14 +38,15 @@ type FunctionsGetter interface {
 
 // FunctionInterface has methods to work with Function resources.
 type FunctionInterface interface {
-	Create(*v1.Function) (*v1.Function, error)
-	Update(*v1.Function) (*v1.Function, error)
-	Delete(name string, options *metav1.DeleteOptions) error
-	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
-	Get(name string, options metav1.GetOptions) (*v1.Function, error)
-	List(opts metav1.ListOptions) (*v1.FunctionList, error)
-	Watch(opts metav1.ListOptions) (watch.Interface, error)
-	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.Function, err error)
+	Create(ctx context.Context, function *v1.Function, opts metav1.CreateOptions) (*v1.Function, error)
+	Update(ctx context.Context, function *v1.Function, opts metav1.UpdateOptions) (*v1.Function, error)
+	UpdateStatus(ctx context.Context, function *v1.Function, opts metav1.UpdateOptions) (*v1.Function, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.Function, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*v1.FunctionList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *v1.Function, err error)
 	FunctionExpansion
 }
 
@@ -63,20 +65,20 @@ func newFunctions(c *UselessV1Client, namespace string) *functions {
 }
 
 // Get takes name of the function, and returns the corresponding function object, and an error if there is any.
-func (c *functions) Get(name string, options metav1.GetOptions) (result *v1.Function, err error) {
+func (c *functions) Get(ctx context.Context, name string, options metav1.GetOptions) (result *v1.Function, err error) {
 	result = &v1.Function{}
 	err = c.client.Get().
 		Namespace(c.ns).
 		Resource("functions").
 		Name(name).
 		VersionedParams(&options, scheme.ParameterCodec).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // List takes label and field selectors, and returns the list of Functions that match those selectors.
-func (c *functions) List(opts metav1.ListOptions) (result *v1.FunctionList, err error) {
+func (c *functions) List(ctx context.Context, opts metav1.ListOptions) (result *v1.FunctionList, err error) {
 	var timeout time.Duration
 	if opts.TimeoutSeconds != nil {
 		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
@@ -87,13 +89,13 @@ func (c *functions) List(opts metav1.ListOptions) (result *v1.FunctionList, err
 		Resource("functions").
 		VersionedParams(&opts, scheme.ParameterCodec).
 		Timeout(timeout).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // Watch returns a watch.Interface that watches the requested functions.
-func (c *functions) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+func (c *functions) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
 	var timeout time.Duration
 	if opts.TimeoutSeconds != nil {
 		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
@@ -104,71 +106,90 @@ func (c *functions) Watch(opts metav1.ListOptions) (watch.Interface, error) {
 		Resource("functions").
 		VersionedParams(&opts, scheme.ParameterCodec).
 		Timeout(timeout).
-		Watch()
+		Watch(ctx)
 }
 
 // Create takes the representation of a function and creates it.  Returns the server's representation of the function, and an error, if there is any.
-func (c *functions) Create(function *v1.Function) (result *v1.Function, err error) {
+func (c *functions) Create(ctx context.Context, function *v1.Function, opts metav1.CreateOptions) (result *v1.Function, err error) {
 	result = &v1.Function{}
 	err = c.client.Post().
 		Namespace(c.ns).
 		Resource("functions").
+		VersionedParams(&opts, scheme.ParameterCodec).
 		Body(function).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // Update takes the representation of a function and updates it. Returns the server's representation of the function, and an error, if there is any.
-func (c *functions) Update(function *v1.Function) (result *v1.Function, err error) {
+func (c *functions) Update(ctx context.Context, function *v1.Function, opts metav1.UpdateOptions) (result *v1.Function, err error) {
+	result = &v1.Function{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("functions").
+		Name(function.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(function).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *functions) UpdateStatus(ctx context.Context, function *v1.Function, opts metav1.UpdateOptions) (result *v1.Function, err error) {
 	result = &v1.Function{}
 	err = c.client.Put().
 		Namespace(c.ns).
 		Resource("functions").
 		Name(function.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
 		Body(function).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // Delete takes name of the function and deletes it. Returns an error if one occurs.
-func (c *functions) Delete(name string, options *metav1.DeleteOptions) error {
+func (c *functions) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
 	return c.client.Delete().
 		Namespace(c.ns).
 		Resource("functions").
 		Name(name).
-		Body(options).
-		Do().
+		Body(&opts).
+		Do(ctx).
 		Error()
 }
 
 // DeleteCollection deletes a collection of objects.
-func (c *functions) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+func (c *functions) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
 	var timeout time.Duration
-	if listOptions.TimeoutSeconds != nil {
-		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
 	}
 	return c.client.Delete().
 		Namespace(c.ns).
 		Resource("functions").
-		VersionedParams(&listOptions, scheme.ParameterCodec).
+		VersionedParams(&listOpts, scheme.ParameterCodec).
 		Timeout(timeout).
-		Body(options).
-		Do().
+		Body(&opts).
+		Do(ctx).
 		Error()
 }
 
 // Patch applies the patch and returns the patched function.
-func (c *functions) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.Function, err error) {
+func (c *functions) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *v1.Function, err error) {
 	result = &v1.Function{}
 	err = c.client.Patch(pt).
 		Namespace(c.ns).
 		Resource("functions").
-		SubResource(subresources...).
 		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
 		Body(data).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
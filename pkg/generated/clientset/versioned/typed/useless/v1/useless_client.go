@@ -19,6 +19,8 @@ limitations under the License.
 package v1
 
 import (
+	"net/http"
+
 	v1 "github.com/damnever/useless/pkg/apis/useless/v1"
 	"github.com/damnever/useless/pkg/generated/clientset/versioned/scheme"
 	rest "k8s.io/client-go/rest"
@@ -29,7 +31,7 @@ type UselessV1Interface interface {
 	FunctionsGetter
 }
 
-// UselessV1Client is used to interact with features provided by the useless group.
+// UselessV1Client is used to interact with features provided by the alphabetical.useless group.
 type UselessV1Client struct {
 	restClient rest.Interface
 }
@@ -39,12 +41,28 @@ func (c *UselessV1Client) Functions(namespace string) FunctionInterface {
 }
 
 // NewForConfig creates a new UselessV1Client for the given config.
+// NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
+// where httpClient was generated with rest.HTTPClientFor(c).
 func NewForConfig(c *rest.Config) (*UselessV1Client, error) {
 	config := *c
 	if err := setConfigDefaults(&config); err != nil {
 		return nil, err
 	}
-	client, err := rest.RESTClientFor(&config)
+	httpClient, err := rest.HTTPClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return NewForConfigAndClient(&config, httpClient)
+}
+
+// NewForConfigAndClient creates a new UselessV1Client for the given config and http client.
+// Note the http client provided takes precedence over the configured transport values.
+func NewForConfigAndClient(c *rest.Config, h *http.Client) (*UselessV1Client, error) {
+	config := *c
+	if err := setConfigDefaults(&config); err != nil {
+		return nil, err
+	}
+	client, err := rest.RESTClientForConfigAndClient(&config, h)
 	if err != nil {
 		return nil, err
 	}
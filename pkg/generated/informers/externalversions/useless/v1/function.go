@@ -19,6 +19,7 @@ limitations under the License.
 package v1
 
 import (
+	"context"
 	time "time"
 
 	uselessv1 "github.com/damnever/useless/pkg/apis/useless/v1"
@@ -61,13 +62,13 @@ func NewFilteredFunctionInformer(client versioned.Interface, namespace string, r
 				if tweakListOptions != nil {
 					tweakListOptions(&options)
 				}
-				return client.UselessV1().Functions(namespace).List(options)
+				return client.UselessV1().Functions(namespace).List(context.TODO(), options)
 			},
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 				if tweakListOptions != nil {
 					tweakListOptions(&options)
 				}
-				return client.UselessV1().Functions(namespace).Watch(options)
+				return client.UselessV1().Functions(namespace).Watch(context.TODO(), options)
 			},
 		},
 		&uselessv1.Function{},
@@ -0,0 +1,193 @@
+// Package interpreter runs the functions from their sources by an embedded Go
+// interpreter, so they are served by the generic supervisor image instead of
+// the images built for them.
+package interpreter
+
+//go:generate yaegi extract -name interpreter github.com/damnever/useless/runtime
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	gobuild "go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+// Symbols are the packages which can be imported by the functions besides the
+// standard library, it is the runtime only, which is filled by the generated
+// code.
+var Symbols = map[string]map[string]reflect.Value{}
+
+const (
+	// exportFile declares the exported alias of the function, the functions
+	// are not always exported. It is the same as the generated main file of
+	// the compiled functions, so it is reserved already.
+	exportFile = "zz_useless_main.go"
+	exportName = "ZZUselessFunction"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Source is the source tree of the function, it is the JSON form of the
+// FunctionSource in the Function resource.
+type Source struct {
+	// Module is the module path in go.mod, it is empty if the function is
+	// not a Go module.
+	Module string `json:"module,omitempty"`
+	// Dir is the slash separated directory of the function package relative
+	// to the root of the tree.
+	Dir string `json:"dir,omitempty"`
+	// Function is the name of the Go function.
+	Function string `json:"function,omitempty"`
+	// Files are the file contents keyed by the slash separated paths
+	// relative to the root of the tree.
+	Files map[string]string `json:"files"`
+}
+
+// Load interprets the function package, and returns the name of the Go
+// function and the function which can be passed to runtime.NewSupervisor.
+// funcName is the lower cased name of the function, it is used to find the
+// Go function if the source does not record its name.
+//
+// The function package can import the standard library, the runtime and the
+// other packages of its module only.
+func Load(source *Source, funcName string) (string, interface{}, error) {
+	gopath, err := ioutil.TempDir("", "useless-interpreter-")
+	if err != nil {
+		return "", nil, err
+	}
+	// The sources are read once they are imported.
+	defer os.RemoveAll(gopath)
+
+	root := source.Module
+	if root == "" {
+		root = "useless.local/" + strings.ToLower(funcName)
+	}
+	for name, content := range source.Files {
+		if clean := path.Clean(name); path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return "", nil, fmt.Errorf("invalid source file path %q", name)
+		}
+		fpath := filepath.Join(gopath, "src", filepath.FromSlash(root), filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return "", nil, err
+		}
+		if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+			return "", nil, err
+		}
+	}
+	importPath := path.Join(root, source.Dir)
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(importPath))
+
+	pkgName, name, err := findFunc(dir, source.Function, funcName)
+	if err != nil {
+		return "", nil, err
+	}
+	export := fmt.Sprintf("package %s\n\nvar %s = %s\n", pkgName, exportName, name)
+	if err := ioutil.WriteFile(filepath.Join(dir, exportFile), []byte(export), 0644); err != nil {
+		return "", nil, err
+	}
+
+	i := interp.New(interp.Options{GoPath: gopath})
+	i.Use(stdlib.Symbols)
+	i.Use(Symbols)
+	if _, err := i.Eval(fmt.Sprintf("import function %q", importPath)); err != nil {
+		return "", nil, fmt.Errorf("interpret %s: %v", importPath, err)
+	}
+	v, err := i.Eval("function." + exportName)
+	if err != nil {
+		return "", nil, fmt.Errorf("interpret %s: %v", name, err)
+	}
+	function, err := adapt(v)
+	if err != nil {
+		return "", nil, fmt.Errorf("function %s: %v", name, err)
+	}
+	return name, function, nil
+}
+
+// findFunc returns the package name and the name of the function, which is
+// name if it is not empty, or the one whose lower cased name is funcName.
+func findFunc(dir, name, funcName string) (string, string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		ok, err := gobuild.Default.MatchFile(dir, info.Name())
+		return err == nil && ok && !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return "", "", err
+	}
+	for pkgName, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, d := range file.Decls {
+				fd, ok := d.(*ast.FuncDecl)
+				if !ok || fd.Recv != nil {
+					continue
+				}
+				if fd.Name.Name == name || (name == "" && strings.EqualFold(fd.Name.Name, funcName)) {
+					return pkgName, fd.Name.Name, nil
+				}
+			}
+		}
+	}
+	if name == "" {
+		name = "named like " + funcName
+	}
+	return "", "", fmt.Errorf("no function %s in the source", name)
+}
+
+// adapt returns the function in the forms accepted by runtime.NewSupervisor,
+// the functions which do not take a context.Context are wrapped.
+func adapt(v reflect.Value) (interface{}, error) {
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s is not a function", t)
+	}
+	in, out := []reflect.Type{}, []reflect.Type{}
+	for i := 0; i < t.NumIn(); i++ {
+		in = append(in, t.In(i))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		out = append(out, t.Out(i))
+	}
+	withContext := len(in) > 0 && in[0] == contextType
+	if withContext {
+		in = in[1:]
+	}
+	if t.IsVariadic() || len(in) < 1 || len(in) > 2 || len(out) < 1 || len(out) > 2 || out[len(out)-1] != errorType {
+		return nil, fmt.Errorf("unsupported signature %s", t)
+	}
+	if withContext {
+		return v.Interface(), nil
+	}
+
+	switch fn := v.Interface().(type) {
+	case func(string) (string, error):
+		return func(_ context.Context, input string) (string, error) {
+			return fn(input)
+		}, nil
+	case func(io.Reader, io.Writer) error:
+		return func(_ context.Context, r io.Reader, w io.Writer) error {
+			return fn(r, w)
+		}, nil
+	}
+	if len(in) != 1 || len(out) != 2 {
+		return nil, fmt.Errorf("unsupported signature %s", t)
+	}
+	typed := reflect.FuncOf([]reflect.Type{contextType, in[0]}, out, false)
+	return reflect.MakeFunc(typed, func(args []reflect.Value) []reflect.Value {
+		return v.Call(args[1:])
+	}).Interface(), nil
+}
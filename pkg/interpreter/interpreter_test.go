@@ -0,0 +1,171 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadStringFunction(t *testing.T) {
+	source := &Source{
+		Dir: ".",
+		Files: map[string]string{"hello.go": `package hello
+
+import "strings"
+
+func Hello(input string) (string, error) {
+	return "hello " + strings.TrimSpace(input), nil
+}
+`},
+	}
+	name, function, err := Load(source, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Hello" {
+		t.Errorf("expected the function Hello, got %s", name)
+	}
+	fn, ok := function.(func(context.Context, string) (string, error))
+	if !ok {
+		t.Fatalf("expected the string function, got %T", function)
+	}
+	output, err := fn(context.Background(), " world\n")
+	if err != nil || output != "hello world" {
+		t.Errorf(`expected "hello world", got %q, %v`, output, err)
+	}
+}
+
+func TestLoadModule(t *testing.T) {
+	source := &Source{
+		Module:   "example.com/echo",
+		Dir:      "cmd/echo",
+		Function: "Echo",
+		Files: map[string]string{
+			"go.mod": "module example.com/echo\n",
+			"upper/upper.go": `package upper
+
+import "strings"
+
+func Upper(s string) string { return strings.ToUpper(s) }
+`,
+			"cmd/echo/echo.go": `package echo
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"example.com/echo/upper"
+)
+
+func Echo(ctx context.Context, r io.Reader, w io.Writer) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, upper.Upper(string(data)))
+	return err
+}
+`,
+		},
+	}
+	name, function, err := Load(source, "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Echo" {
+		t.Errorf("expected the function Echo, got %s", name)
+	}
+	fn, ok := function.(func(context.Context, io.Reader, io.Writer) error)
+	if !ok {
+		t.Fatalf("expected the stream function, got %T", function)
+	}
+	var buf bytes.Buffer
+	if err := fn(context.Background(), strings.NewReader("echo"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ECHO" {
+		t.Errorf(`expected "ECHO", got %q`, buf.String())
+	}
+}
+
+func TestLoadTypedFunction(t *testing.T) {
+	source := &Source{
+		Dir: ".",
+		Files: map[string]string{"add.go": `package add
+
+func add(nums []int) (int, error) {
+	sum := 0
+	for _, n := range nums {
+		sum += n
+	}
+	return sum, nil
+}
+`},
+	}
+	name, function, err := Load(source, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "add" {
+		t.Errorf("expected the function add, got %s", name)
+	}
+	// The function which does not take a context.Context is wrapped.
+	v := reflect.ValueOf(function)
+	if v.Kind() != reflect.Func || v.Type().NumIn() != 2 || v.Type().In(0) != contextType {
+		t.Fatalf("expected the typed function with a context, got %T", function)
+	}
+	out := v.Call([]reflect.Value{reflect.ValueOf(context.Background()), reflect.ValueOf([]int{1, 2, 3})})
+	if sum := out[0].Interface(); sum != 6 || !out[1].IsNil() {
+		t.Errorf("expected 6, got %v, %v", sum, out[1].Interface())
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		source *Source
+		err    string
+	}{
+		{
+			name: "no function",
+			source: &Source{Dir: ".", Files: map[string]string{"a.go": `package a
+
+func Other(s string) (string, error) { return s, nil }
+`}},
+			err: "no function named like test",
+		},
+		{
+			name: "unsupported signature",
+			source: &Source{Dir: ".", Files: map[string]string{"a.go": `package a
+
+func Test(a, b, c string) error { return nil }
+`}},
+			err: "unsupported signature",
+		},
+		{
+			name: "not compilable",
+			source: &Source{Dir: ".", Files: map[string]string{"a.go": `package a
+
+func Test(s string) (string, error) { return undefined, nil }
+`}},
+			err: "undefined",
+		},
+		{
+			name:   "invalid path",
+			source: &Source{Dir: ".", Files: map[string]string{"../a.go": "package a\n"}},
+			err:    `invalid source file path "../a.go"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := Load(tc.source, "test")
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
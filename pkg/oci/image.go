@@ -0,0 +1,113 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+)
+
+// Image is an image stored in a layout.
+type Image struct {
+	// Descriptor points to the manifest.
+	Descriptor Descriptor
+	Manifest   Manifest
+	Config     Config
+}
+
+// Assemble writes a new image into the layout, which appends the layers to
+// the base image, base is nil for an image from scratch. configure changes
+// the image config, e.g. the command and the environment variables.
+func (l *Layout) Assemble(base *Image, platform Platform, layers []*Layer, createdBy string,
+	configure func(*ImageConfig)) (*Image, error) {
+
+	config := Config{RootFS: RootFS{Type: "layers"}}
+	manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeImageManifest}
+	if base != nil {
+		if !base.Config.Platform().Match(platform) {
+			return nil, fmt.Errorf("the platform of the base image is %s, not %s", base.Config.Platform(), platform)
+		}
+		if platform.Variant == "" {
+			platform.Variant = base.Config.Variant
+		}
+		config = base.Config
+		// Do not share the slices with the base.
+		config.RootFS.DiffIDs = append([]Digest(nil), base.Config.RootFS.DiffIDs...)
+		config.History = append([]History(nil), base.Config.History...)
+		config.Config.Env = append([]string(nil), base.Config.Config.Env...)
+		manifest.Layers = append([]Descriptor(nil), base.Manifest.Layers...)
+	}
+	config.OS, config.Architecture, config.Variant = platform.OS, platform.Architecture, platform.Variant
+
+	for _, layer := range layers {
+		if _, err := l.WriteBlob(layer.Descriptor.MediaType, layer.data); err != nil {
+			return nil, err
+		}
+		manifest.Layers = append(manifest.Layers, layer.Descriptor)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, layer.DiffID)
+		config.History = append(config.History, History{CreatedBy: createdBy})
+	}
+	if configure != nil {
+		configure(&config.Config)
+	}
+
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Config, err = l.WriteBlob(MediaTypeImageConfig, configData); err != nil {
+		return nil, err
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := l.WriteBlob(MediaTypeImageManifest, manifestData)
+	if err != nil {
+		return nil, err
+	}
+	desc.Platform = &platform
+	return &Image{Descriptor: desc, Manifest: manifest, Config: config}, nil
+}
+
+// AssembleIndex writes the index of the images for the different
+// platforms into the layout.
+func (l *Layout) AssembleIndex(images []*Image) (Descriptor, error) {
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex}
+	for _, image := range images {
+		desc := image.Descriptor
+		if desc.Platform == nil {
+			platform := image.Config.Platform()
+			desc.Platform = &platform
+		}
+		desc.Annotations = nil
+		index.Manifests = append(index.Manifests, desc)
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	return l.WriteBlob(MediaTypeImageIndex, data)
+}
+
+// digestVerifier checks the content written into it matches the digest.
+type digestVerifier struct {
+	digest Digest
+	hash   hash.Hash
+}
+
+func newDigestVerifier(digest Digest) *digestVerifier {
+	return &digestVerifier{digest: digest, hash: sha256.New()}
+}
+
+func (v *digestVerifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+func (v *digestVerifier) verify() error {
+	if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.digest.Hex() {
+		return fmt.Errorf("digest mismatch, want %s, got sha256:%s", v.digest, actual)
+	}
+	return nil
+}
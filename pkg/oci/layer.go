@@ -0,0 +1,101 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// File is a regular file in a layer.
+type File struct {
+	// Path is the slash separated absolute path in the image.
+	Path    string
+	Mode    os.FileMode
+	Content []byte
+}
+
+// Layer is a gzipped tar layer kept in memory until it is written into a
+// layout.
+type Layer struct {
+	Descriptor Descriptor
+	// DiffID is the digest of the uncompressed tar.
+	DiffID Digest
+	data   []byte
+}
+
+// NewLayer packs the files into a layer, the parent directories are added
+// as well. The modification times are zero, so the same files always make
+// the same layer.
+func NewLayer(files []File) (*Layer, error) {
+	files = append([]File(nil), files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	diffID := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(gw, diffID))
+	dirs := map[string]bool{}
+	for _, file := range files {
+		name := strings.TrimPrefix(path.Clean("/"+file.Path), "/")
+		for _, dir := range parentDirs(name) {
+			if dirs[dir] {
+				continue
+			}
+			dirs[dir] = true
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     dir + "/",
+				Mode:     0755,
+				Format:   tar.FormatPAX,
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     int64(file.Mode.Perm()),
+			Size:     int64(len(file.Content)),
+			Format:   tar.FormatPAX,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.Content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	data := compressed.Bytes()
+	return &Layer{
+		Descriptor: Descriptor{
+			MediaType: MediaTypeImageLayerGzip,
+			Digest:    DigestOf(data),
+			Size:      int64(len(data)),
+		},
+		DiffID: Digest("sha256:" + hex.EncodeToString(diffID.Sum(nil))),
+		data:   data,
+	}, nil
+}
+
+// parentDirs returns the parent directories of the slash separated path
+// from the top, e.g. a, a/b for a/b/c.
+func parentDirs(name string) []string {
+	dirs := []string{}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs
+}
@@ -0,0 +1,327 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	layoutFile    = "oci-layout"
+	indexFile     = "index.json"
+	layoutVersion = `{"imageLayoutVersion":"1.0.0"}`
+)
+
+// ErrNotFound is returned if the image is not in the layout.
+var ErrNotFound = errors.New("not found")
+
+// Layout is an OCI image layout on disk, the images are named by the
+// org.opencontainers.image.ref.name annotation in index.json.
+type Layout struct {
+	root string
+}
+
+// OpenLayout opens the layout, it is created if it does not exist.
+func OpenLayout(root string) (*Layout, error) {
+	if err := os.MkdirAll(filepath.Join(root, "blobs", "sha256"), 0755); err != nil {
+		return nil, err
+	}
+	l := &Layout{root: root}
+	if _, err := os.Stat(filepath.Join(root, layoutFile)); os.IsNotExist(err) {
+		if err := writeFileAtomic(filepath.Join(root, layoutFile), []byte(layoutVersion)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, indexFile)); os.IsNotExist(err) {
+		if err := l.writeIndex(&Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: []Descriptor{}}); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Root returns the directory of the layout.
+func (l *Layout) Root() string {
+	return l.root
+}
+
+func (l *Layout) blobPath(digest Digest) string {
+	return filepath.Join(l.root, "blobs", "sha256", digest.Hex())
+}
+
+// HasBlob tells whether the blob is in the layout.
+func (l *Layout) HasBlob(digest Digest) bool {
+	if digest.Validate() != nil {
+		return false
+	}
+	_, err := os.Stat(l.blobPath(digest))
+	return err == nil
+}
+
+// OpenBlob opens the blob for reading.
+func (l *Layout) OpenBlob(digest Digest) (*os.File, error) {
+	if err := digest.Validate(); err != nil {
+		return nil, err
+	}
+	return os.Open(l.blobPath(digest))
+}
+
+// ReadBlob reads the whole blob, it is for the manifests and the configs.
+func (l *Layout) ReadBlob(digest Digest) ([]byte, error) {
+	f, err := l.OpenBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// WriteBlob writes the data and returns its descriptor.
+func (l *Layout) WriteBlob(mediaType string, data []byte) (Descriptor, error) {
+	desc := Descriptor{MediaType: mediaType, Digest: DigestOf(data), Size: int64(len(data))}
+	if l.HasBlob(desc.Digest) {
+		return desc, nil
+	}
+	return desc, writeFileAtomic(l.blobPath(desc.Digest), data)
+}
+
+// CopyBlob writes the blob from r, the content must match the digest.
+func (l *Layout) CopyBlob(digest Digest, r io.Reader) error {
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Join(l.root, "blobs"), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	verifier := newDigestVerifier(digest)
+	if _, err := io.Copy(tmp, io.TeeReader(r, verifier)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := verifier.verify(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.blobPath(digest))
+}
+
+// Index returns the index.json of the layout.
+func (l *Layout) Index() (*Index, error) {
+	data, err := ioutil.ReadFile(filepath.Join(l.root, indexFile))
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", indexFile, err)
+	}
+	return index, nil
+}
+
+func (l *Layout) writeIndex(index *Index) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(l.root, indexFile), data)
+}
+
+// Tag names the manifest or the index in index.json, the previous one with
+// the same name and platform is replaced, so the same name can be used by
+// the images of the different platforms.
+func (l *Layout) Tag(desc Descriptor, name string) error {
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	manifests := index.Manifests[:0]
+	for _, m := range index.Manifests {
+		if m.Annotations[AnnotationRefName] != name || !samePlatform(m.Platform, desc.Platform) {
+			manifests = append(manifests, m)
+		}
+	}
+	desc.Annotations = map[string]string{AnnotationRefName: name}
+	index.Manifests = append(manifests, desc)
+	return l.writeIndex(index)
+}
+
+// Resolve returns the descriptor of the named manifest or index, platform
+// is nil for any platform, the error is ErrNotFound if there is no such one.
+func (l *Layout) Resolve(name string, platform *Platform) (Descriptor, error) {
+	index, err := l.Index()
+	if err != nil {
+		return Descriptor{}, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[AnnotationRefName] != name {
+			continue
+		}
+		if platform == nil || (desc.Platform != nil && desc.Platform.Match(*platform)) {
+			desc.Annotations = nil
+			return desc, nil
+		}
+	}
+	return Descriptor{}, ErrNotFound
+}
+
+func samePlatform(a, b *Platform) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Image reads the image of the manifest.
+func (l *Layout) Image(desc Descriptor) (*Image, error) {
+	data, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{Descriptor: desc}
+	if err := json.Unmarshal(data, &image.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", desc.Digest, err)
+	}
+	if data, err = l.ReadBlob(image.Manifest.Config.Digest); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &image.Config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", image.Manifest.Config.Digest, err)
+	}
+	return image, nil
+}
+
+// WriteTar writes the named image and its blobs as a tarball of a layout,
+// which is known as the OCI archive.
+func (l *Layout) WriteTar(w io.Writer, name string) error {
+	desc, err := l.Resolve(name, nil)
+	if err != nil {
+		return err
+	}
+	blobs, manifests, err := l.references(desc)
+	if err != nil {
+		return err
+	}
+	desc.Annotations = map[string]string{AnnotationRefName: name}
+	index, err := json.MarshalIndent(&Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageIndex,
+		Manifests:     []Descriptor{desc},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	writeEntry := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	}
+	if err := writeEntry(layoutFile, int64(len(layoutVersion)), bytes.NewReader([]byte(layoutVersion))); err != nil {
+		return err
+	}
+	if err := writeEntry(indexFile, int64(len(index)), bytes.NewReader(index)); err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		blobs = append(blobs, m.Digest)
+	}
+	for _, digest := range blobs {
+		f, err := l.OpenBlob(digest)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			err = writeEntry("blobs/sha256/"+digest.Hex(), info.Size(), f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// references returns the digests of the configs and the layers reachable
+// from the manifest or the index, and the manifests and the indexes
+// including itself, the children come before their parents.
+func (l *Layout) references(desc Descriptor) ([]Digest, []Descriptor, error) {
+	blobs, manifests := []Digest{}, []Descriptor{}
+	seen := map[Digest]bool{}
+	var walk func(desc Descriptor) error
+	walk = func(desc Descriptor) error {
+		if seen[desc.Digest] {
+			return nil
+		}
+		seen[desc.Digest] = true
+		switch desc.MediaType {
+		case MediaTypeImageIndex:
+			data, err := l.ReadBlob(desc.Digest)
+			if err != nil {
+				return err
+			}
+			var index Index
+			if err := json.Unmarshal(data, &index); err != nil {
+				return fmt.Errorf("invalid index %s: %v", desc.Digest, err)
+			}
+			for _, m := range index.Manifests {
+				if err := walk(m); err != nil {
+					return err
+				}
+			}
+		case MediaTypeImageManifest:
+			image, err := l.Image(desc)
+			if err != nil {
+				return err
+			}
+			for _, blob := range append([]Descriptor{image.Manifest.Config}, image.Manifest.Layers...) {
+				if !seen[blob.Digest] {
+					seen[blob.Digest] = true
+					blobs = append(blobs, blob.Digest)
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported manifest type %q", desc.MediaType)
+		}
+		manifests = append(manifests, Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size})
+		return nil
+	}
+	if err := walk(desc); err != nil {
+		return nil, nil, err
+	}
+	return blobs, manifests, nil
+}
+
+// writeFileAtomic writes the file by renaming a temporary file, so the
+// readers never see a partial file.
+func writeFileAtomic(fpath string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fpath), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fpath)
+}
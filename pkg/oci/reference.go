@@ -0,0 +1,98 @@
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	dockerHub         = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// Reference is an image reference like registry/repository:tag@digest.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     Digest
+}
+
+// ParseReference parses the reference the way Docker does, e.g. alpine:3.7
+// is docker.io/library/alpine:3.7, the tag defaults to latest if there is
+// neither the tag nor the digest.
+func ParseReference(s string) (Reference, error) {
+	ref := Reference{}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = Digest(name[i+1:])
+		if err := ref.Digest.Validate(); err != nil {
+			return ref, fmt.Errorf("invalid reference %q: %v", s, err)
+		}
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+		if !tagPattern.MatchString(ref.Tag) {
+			return ref, fmt.Errorf("invalid reference %q: invalid tag", s)
+		}
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	ref.Registry = dockerHub
+	if i := strings.Index(name, "/"); i >= 0 {
+		if host := name[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry = host
+			name = name[i+1:]
+		}
+	}
+	if ref.Registry == dockerHub && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if !repositoryPattern.MatchString(name) {
+		return ref, fmt.Errorf("invalid reference %q: invalid repository", s)
+	}
+	ref.Repository = name
+	return ref, nil
+}
+
+// Name returns the reference without the tag and the digest.
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + string(r.Digest)
+	}
+	return s
+}
+
+// identifier returns the digest or the tag used in the manifest URLs.
+func (r Reference) identifier() string {
+	if r.Digest != "" {
+		return string(r.Digest)
+	}
+	return r.Tag
+}
+
+// host returns the host of the registry API.
+func (r Reference) host() string {
+	if r.Registry == dockerHub {
+		return dockerHubRegistry
+	}
+	return r.Registry
+}
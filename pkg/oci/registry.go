@@ -0,0 +1,505 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxManifestSize limits the manifests and the indexes read from the
+// registries.
+const maxManifestSize = 4 << 20
+
+var manifestMediaTypes = []string{
+	MediaTypeImageManifest,
+	MediaTypeImageIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}
+
+// Client talks to the registries by the distribution API.
+type Client struct {
+	HTTPClient *http.Client
+	// Credentials returns the username and the password of the registry,
+	// the anonymous access is used if the username is empty.
+	Credentials func(registry string) (username, password string)
+	// PlainHTTP uses http instead of https, the registries on the loopback
+	// addresses always use http.
+	PlainHTTP bool
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// NewClient returns a client which uses the credentials of `docker login`.
+func NewClient() *Client {
+	return &Client{HTTPClient: http.DefaultClient, Credentials: DockerCredentials}
+}
+
+// Pull downloads the image into the layout and names it as the reference
+// along with the platform, the manifest of the platform is chosen if the
+// reference is an index.
+func (c *Client) Pull(ref Reference, platform Platform, layout *Layout) (*Image, error) {
+	data, mediaType, err := c.getManifest(ref, ref.identifier())
+	if err != nil {
+		return nil, err
+	}
+	if mediaType == MediaTypeImageIndex || mediaType == MediaTypeDockerManifestList {
+		var index Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("invalid index of %s: %v", ref, err)
+		}
+		var desc *Descriptor
+		for i, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.Match(platform) {
+				desc = &index.Manifests[i]
+				break
+			}
+		}
+		if desc == nil {
+			return nil, fmt.Errorf("%s has no image for %s", ref, platform)
+		}
+		if data, mediaType, err = c.getManifest(ref, string(desc.Digest)); err != nil {
+			return nil, err
+		}
+	}
+	if mediaType != MediaTypeImageManifest && mediaType != MediaTypeDockerManifest {
+		return nil, fmt.Errorf("unsupported manifest type %q of %s", mediaType, ref)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s: %v", ref, err)
+	}
+	// The Docker manifest has the same content as the OCI one.
+	manifest.MediaType = MediaTypeImageManifest
+	manifest.Config.MediaType = MediaTypeImageConfig
+	for i, layer := range manifest.Layers {
+		switch layer.MediaType {
+		case MediaTypeImageLayerGzip, MediaTypeDockerLayerGzip:
+			manifest.Layers[i].MediaType = MediaTypeImageLayerGzip
+		default:
+			return nil, fmt.Errorf("unsupported layer type %q of %s", layer.MediaType, ref)
+		}
+	}
+	for _, desc := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+		if layout.HasBlob(desc.Digest) {
+			continue
+		}
+		if err := c.pullBlob(ref, desc.Digest, layout); err != nil {
+			return nil, err
+		}
+	}
+
+	if data, err = json.Marshal(manifest); err != nil {
+		return nil, err
+	}
+	desc, err := layout.WriteBlob(MediaTypeImageManifest, data)
+	if err != nil {
+		return nil, err
+	}
+	image, err := layout.Image(desc)
+	if err != nil {
+		return nil, err
+	}
+	actual := image.Config.Platform()
+	if !actual.Match(platform) {
+		return nil, fmt.Errorf("the platform of %s is %s, not %s", ref, actual, platform)
+	}
+	image.Descriptor.Platform = &actual
+	if err := layout.Tag(image.Descriptor, ref.String()); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// Push uploads the image or the index from the layout, the blobs which
+// exist in the registry are skipped, it returns the digest of the manifest.
+func (c *Client) Push(layout *Layout, desc Descriptor, ref Reference) (Digest, error) {
+	blobs, manifests, err := layout.references(desc)
+	if err != nil {
+		return "", err
+	}
+	for _, digest := range blobs {
+		if err := c.pushBlob(layout, ref, digest); err != nil {
+			return "", err
+		}
+	}
+	// The manifests are in the order that the children come first, the
+	// registry rejects the index whose manifests are unknown.
+	for _, m := range manifests {
+		data, err := layout.ReadBlob(m.Digest)
+		if err != nil {
+			return "", err
+		}
+		identifier := string(m.Digest)
+		if m.Digest == desc.Digest {
+			identifier = ref.identifier()
+		}
+		if err := c.putManifest(ref, identifier, m.MediaType, data); err != nil {
+			return "", err
+		}
+	}
+	return desc.Digest, nil
+}
+
+func (c *Client) getManifest(ref Reference, identifier string) ([]byte, string, error) {
+	req, err := c.newRequest(ref, http.MethodGet, "/manifests/"+identifier, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	resp, err := c.do(req, ref, "pull")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp, "get manifest "+ref.String())
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.HasPrefix(identifier, "sha256:") && DigestOf(data) != Digest(identifier) {
+		return nil, "", fmt.Errorf("digest mismatch of the manifest %s", identifier)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return data, strings.TrimSpace(mediaType), nil
+}
+
+func (c *Client) putManifest(ref Reference, identifier, mediaType string, data []byte) error {
+	req, err := c.newRequest(ref, http.MethodPut, "/manifests/"+identifier, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req, ref, "pull,push")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return responseError(resp, "put manifest "+ref.Name()+":"+identifier)
+	}
+	return nil
+}
+
+func (c *Client) pullBlob(ref Reference, digest Digest, layout *Layout) error {
+	req, err := c.newRequest(ref, http.MethodGet, "/blobs/"+string(digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, ref, "pull")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "get blob "+string(digest))
+	}
+	return layout.CopyBlob(digest, resp.Body)
+}
+
+func (c *Client) pushBlob(layout *Layout, ref Reference, digest Digest) error {
+	req, err := c.newRequest(ref, http.MethodHead, "/blobs/"+string(digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, ref, "pull,push")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	// The monolithic upload: POST for the location, then PUT the content.
+	if req, err = c.newRequest(ref, http.MethodPost, "/blobs/uploads/", nil); err != nil {
+		return err
+	}
+	if resp, err = c.do(req, ref, "pull,push"); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		defer resp.Body.Close()
+		return responseError(resp, "start upload "+string(digest))
+	}
+	resp.Body.Close()
+	location, err := req.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location: %v", err)
+	}
+	query := location.Query()
+	query.Set("digest", string(digest))
+	location.RawQuery = query.Encode()
+
+	f, err := layout.OpenBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err = http.NewRequest(http.MethodPut, location.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if resp, err = c.do(req, ref, "pull,push"); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "upload blob "+string(digest))
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ref Reference, method, path string, body []byte) (*http.Request, error) {
+	u := c.scheme(ref) + "://" + ref.host() + "/v2/" + ref.Repository + path
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	return http.NewRequest(method, u, r)
+}
+
+func (c *Client) scheme(ref Reference) string {
+	if c.PlainHTTP || isLoopback(ref.Registry) {
+		return "http"
+	}
+	return "https"
+}
+
+func isLoopback(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// do sends the request with the authorization of the actions on the
+// repository, the token is requested once the registry asks for it.
+func (c *Client) do(req *http.Request, ref Reference, actions string) (*http.Response, error) {
+	scope := "repository:" + ref.Repository + ":" + actions
+	key := ref.Registry + " " + scope
+	c.mu.Lock()
+	auth := c.tokens[key]
+	c.mu.Unlock()
+	if auth == "" {
+		var err error
+		if auth, err = c.authorize(ref, scope); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.tokens == nil {
+			c.tokens = map[string]string{}
+		}
+		c.tokens[key] = auth
+		c.mu.Unlock()
+	}
+	if auth != "-" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", req.Method, req.URL, err)
+	}
+	return resp, nil
+}
+
+// authorize pings the registry and follows the challenge, it returns the
+// Authorization header, or - if the registry needs no authorization.
+func (c *Client) authorize(ref Reference, scope string) (string, error) {
+	resp, err := c.httpClient().Get(c.scheme(ref) + "://" + ref.host() + "/v2/")
+	if err != nil {
+		return "", fmt.Errorf("ping registry %s: %v", ref.Registry, err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "-", nil
+	}
+
+	var username, password string
+	if c.Credentials != nil {
+		username, password = c.Credentials(ref.Registry)
+	}
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("registry %s requires the credentials", ref.Registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported authentication %q of registry %s", scheme, ref.Registry)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm %q of registry %s", params["realm"], ref.Registry)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	if resp, err = c.httpClient().Do(req); err != nil {
+		return "", fmt.Errorf("get token of registry %s: %v", ref.Registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, "get token of registry "+ref.Registry)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token of registry %s: %v", ref.Registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// parseChallenge parses the WWW-Authenticate header like:
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return header, params
+	}
+	scheme, rest := header[:i], header[i+1:]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// responseError reads the errors of the distribution API from the response.
+func responseError(resp *http.Response, action string) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) == nil && len(body.Errors) > 0 {
+		msgs := []string{}
+		for _, e := range body.Errors {
+			msgs = append(msgs, e.Code+": "+e.Message)
+		}
+		return fmt.Errorf("%s: %s (status %d)", action, strings.Join(msgs, "; "), resp.StatusCode)
+	}
+	return fmt.Errorf("%s: status %d", action, resp.StatusCode)
+}
+
+// DockerCredentials reads the credentials saved by `docker login` in the
+// config.json, the credential helpers are not supported.
+func DockerCredentials(registry string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(data, &config) != nil {
+		return "", ""
+	}
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	if registry == dockerHub {
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io")
+	}
+	for _, key := range keys {
+		auth, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", ""
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", ""
+		}
+		return parts[0], parts[1]
+	}
+	return "", ""
+}
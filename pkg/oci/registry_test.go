@@ -0,0 +1,329 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "secret-token"
+
+// fakeRegistry serves the minimal distribution API used by Client.Push, it
+// requires the bearer token issued by its /token realm.
+type fakeRegistry struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[Digest][]byte
+	manifests map[string][]byte // By the repository and the tag or the digest.
+	types     map[string]string
+	uploads   []Digest
+	scopes    []string
+	uploadID  int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		t:         t,
+		blobs:     map[Digest][]byte{},
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+func (r *fakeRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		if req.URL.Query().Get("service") != "fake" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+		json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const repo = "/v2/test/app"
+	path := req.URL.Path
+	switch {
+	case req.Method == http.MethodHead && strings.HasPrefix(path, repo+"/blobs/"):
+		if _, ok := r.blobs[Digest(strings.TrimPrefix(path, repo+"/blobs/"))]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case req.Method == http.MethodGet && strings.HasPrefix(path, repo+"/blobs/"):
+		data, ok := r.blobs[Digest(strings.TrimPrefix(path, repo+"/blobs/"))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case req.Method == http.MethodGet && strings.HasPrefix(path, repo+"/manifests/"):
+		identifier := strings.TrimPrefix(path, repo+"/manifests/")
+		data, ok := r.manifests[identifier]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[identifier])
+		w.Write(data)
+	case req.Method == http.MethodPost && path == repo+"/blobs/uploads/":
+		r.uploadID++
+		w.Header().Set("Location", fmt.Sprintf("%s/blobs/uploads/%d?state=x", repo, r.uploadID))
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && strings.HasPrefix(path, repo+"/blobs/uploads/"):
+		if req.URL.Query().Get("state") != "x" {
+			r.t.Errorf("the query of the upload location is dropped: %s", req.URL)
+		}
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		digest := Digest(req.URL.Query().Get("digest"))
+		if DigestOf(data) != digest {
+			r.t.Errorf("uploaded blob has digest %s, not %s", DigestOf(data), digest)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = data
+		r.uploads = append(r.uploads, digest)
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodPut && strings.HasPrefix(path, repo+"/manifests/"):
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var manifest struct {
+			Config    Descriptor   `json:"config"`
+			Layers    []Descriptor `json:"layers"`
+			Manifests []Descriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The registry rejects the manifests which reference the unknown content.
+		for _, desc := range append(manifest.Layers, manifest.Config) {
+			if _, ok := r.blobs[desc.Digest]; desc.Digest != "" && !ok {
+				r.t.Errorf("manifest is pushed before its blob %s", desc.Digest)
+			}
+		}
+		for _, desc := range manifest.Manifests {
+			if _, ok := r.manifests[string(desc.Digest)]; !ok {
+				r.t.Errorf("index is pushed before its manifest %s", desc.Digest)
+			}
+		}
+		identifier := strings.TrimPrefix(path, repo+"/manifests/")
+		if strings.HasPrefix(identifier, "sha256:") && DigestOf(data) != Digest(identifier) {
+			r.t.Errorf("manifest has digest %s, not %s", DigestOf(data), identifier)
+		}
+		r.manifests[identifier] = data
+		r.manifests[string(DigestOf(data))] = data
+		r.types[identifier] = req.Header.Get("Content-Type")
+		r.types[string(DigestOf(data))] = req.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		r.t.Errorf("unexpected request %s %s", req.Method, req.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newTestImages assembles the images of the platforms from the same layers.
+func newTestImages(t *testing.T, layout *Layout, archs ...string) ([]*Image, *Layer, *Layer) {
+	base, err := NewLayer([]File{{Path: "/etc/base", Mode: 0644, Content: []byte("base")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewLayer([]File{{Path: "/app/main", Mode: 0755, Content: []byte("app")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var images []*Image
+	for _, arch := range archs {
+		image, err := layout.Assemble(nil, Platform{OS: "linux", Architecture: arch}, []*Layer{base, app}, "test",
+			func(c *ImageConfig) { c.Cmd = []string{"/app/main"} })
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, image)
+	}
+	return images, base, app
+}
+
+func TestPush(t *testing.T) {
+	registry := newFakeRegistry(t)
+	defer registry.server.Close()
+
+	layout, err := OpenLayout(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, base, app := newTestImages(t, layout, "amd64", "arm64")
+	desc, err := layout.AssembleIndex(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The base layer exists in the registry, it must not be uploaded again.
+	data, err := layout.ReadBlob(base.Descriptor.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.blobs[base.Descriptor.Digest] = data
+
+	ref, err := ParseReference(strings.TrimPrefix(registry.server.URL, "http://") + "/test/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{HTTPClient: registry.server.Client()}
+	digest, err := client.Push(layout, desc, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != desc.Digest {
+		t.Errorf("push returns digest %s, want %s", digest, desc.Digest)
+	}
+
+	want := map[Digest]bool{app.Descriptor.Digest: true}
+	for _, image := range images {
+		want[image.Manifest.Config.Digest] = true
+	}
+	uploaded := map[Digest]bool{}
+	for _, digest := range registry.uploads {
+		if uploaded[digest] {
+			t.Errorf("blob %s is uploaded twice", digest)
+		}
+		uploaded[digest] = true
+	}
+	if len(uploaded) != len(want) {
+		t.Errorf("uploaded blobs %v, want %v", registry.uploads, want)
+	}
+	for digest := range want {
+		if !uploaded[digest] {
+			t.Errorf("blob %s is not uploaded", digest)
+		}
+	}
+
+	index, ok := registry.manifests["v1"]
+	if !ok {
+		t.Fatalf("index is not tagged as v1")
+	}
+	if DigestOf(index) != desc.Digest {
+		t.Errorf("tagged manifest has digest %s, want %s", DigestOf(index), desc.Digest)
+	}
+	if typ := registry.types["v1"]; typ != MediaTypeImageIndex {
+		t.Errorf("tagged manifest has type %q, want %q", typ, MediaTypeImageIndex)
+	}
+	for _, image := range images {
+		if _, ok := registry.manifests[string(image.Descriptor.Digest)]; !ok {
+			t.Errorf("manifest %s is not pushed by digest", image.Descriptor.Digest)
+		}
+		if typ := registry.types[string(image.Descriptor.Digest)]; typ != MediaTypeImageManifest {
+			t.Errorf("manifest %s has type %q, want %q", image.Descriptor.Digest, typ, MediaTypeImageManifest)
+		}
+	}
+
+	if len(registry.scopes) == 0 {
+		t.Fatalf("no token is requested")
+	}
+	for _, scope := range registry.scopes {
+		if scope != "repository:test/app:pull,push" {
+			t.Errorf("token is requested for scope %q", scope)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	registry := newFakeRegistry(t)
+	defer registry.server.Close()
+	client := &Client{HTTPClient: registry.server.Client()}
+	host := strings.TrimPrefix(registry.server.URL, "http://")
+
+	// Push the index of the images of the platforms as multi.
+	src, err := OpenLayout(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, _, _ := newTestImages(t, src, "amd64", "arm64")
+	desc, err := src.AssembleIndex(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ParseReference(host + "/test/app:multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Push(src, desc, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		ref      string
+		platform Platform
+		expected *Image
+	}{
+		{
+			name:     "manifest",
+			ref:      "/test/app@" + string(images[0].Descriptor.Digest),
+			platform: Platform{OS: "linux", Architecture: "amd64"},
+			expected: images[0],
+		},
+		{name: "index", ref: "/test/app:multi", platform: Platform{OS: "linux", Architecture: "arm64"}, expected: images[1]},
+		{name: "no such platform", ref: "/test/app:multi", platform: Platform{OS: "linux", Architecture: "s390x"}},
+		{name: "not found", ref: "/test/app:nope"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			layout, err := OpenLayout(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref, err := ParseReference(host + tc.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			image, err := client.Pull(ref, tc.platform, layout)
+			if tc.expected == nil {
+				if err == nil {
+					t.Errorf("expected error, got %s", image.Descriptor.Digest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Descriptor.Digest != tc.expected.Descriptor.Digest {
+				t.Errorf("pulled manifest %s, want %s", image.Descriptor.Digest, tc.expected.Descriptor.Digest)
+			}
+			if image.Config.Architecture != tc.expected.Config.Architecture {
+				t.Errorf("pulled %s image, want %s", image.Config.Architecture, tc.expected.Config.Architecture)
+			}
+			for _, layer := range image.Manifest.Layers {
+				if !layout.HasBlob(layer.Digest) {
+					t.Errorf("layer %s is not pulled", layer.Digest)
+				}
+			}
+			platform := image.Config.Platform()
+			if desc, err := layout.Resolve(ref.String(), &platform); err != nil || desc.Digest != image.Descriptor.Digest {
+				t.Errorf("pulled image is not named as %s: %v", ref, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package oci assembles the container images without the Docker daemon, the
+// images are stored in the OCI image layouts and pushed to the registries by
+// the distribution API.
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	MediaTypeImageManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageConfig    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	// The Docker media types are accepted when pulling, they are converted
+	// into the OCI ones which have the same content.
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayerGzip    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// AnnotationRefName is the annotation of the image name in index.json.
+const AnnotationRefName = "org.opencontainers.image.ref.name"
+
+// Digest is the content address like sha256:<hex>, only sha256 is supported.
+type Digest string
+
+// DigestOf returns the sha256 digest of the data.
+func DigestOf(data []byte) Digest {
+	sum := sha256.Sum256(data)
+	return Digest("sha256:" + hex.EncodeToString(sum[:]))
+}
+
+// Validate checks the digest is a well formed sha256 digest, the digests
+// from the registries are used as the file names.
+func (d Digest) Validate() error {
+	hexPart := strings.TrimPrefix(string(d), "sha256:")
+	if len(hexPart) != sha256.Size*2 || hexPart == string(d) {
+		return fmt.Errorf("invalid digest %q", d)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return fmt.Errorf("invalid digest %q", d)
+	}
+	return nil
+}
+
+// Hex returns the hex part of the digest.
+func (d Digest) Hex() string {
+	return strings.TrimPrefix(string(d), "sha256:")
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Match tells whether the image of p runs on the wanted platform, the
+// variant matches any if it is not wanted.
+func (p Platform) Match(want Platform) bool {
+	return p.OS == want.OS && p.Architecture == want.Architecture &&
+		(want.Variant == "" || p.Variant == want.Variant)
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// Descriptor points to the content of a blob.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      Digest            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Index is the index.json of the layouts and the multi-platform images.
+type Index struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Config is the image configuration, the fields not used here are dropped.
+type Config struct {
+	Architecture string      `json:"architecture"`
+	OS           string      `json:"os"`
+	Variant      string      `json:"variant,omitempty"`
+	Config       ImageConfig `json:"config"`
+	RootFS       RootFS      `json:"rootfs"`
+	History      []History   `json:"history,omitempty"`
+}
+
+// Platform returns the platform of the image.
+func (c *Config) Platform() Platform {
+	return Platform{OS: c.OS, Architecture: c.Architecture, Variant: c.Variant}
+}
+
+// ImageConfig is the default parameters to run the containers.
+type ImageConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// SetEnv sets the environment variable, it replaces the existing one.
+func (c *ImageConfig) SetEnv(name, value string) {
+	for i, env := range c.Env {
+		if strings.HasPrefix(env, name+"=") {
+			c.Env[i] = name + "=" + value
+			return
+		}
+	}
+	c.Env = append(c.Env, name+"="+value)
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []Digest `json:"diff_ids"`
+}
+
+type History struct {
+	CreatedBy  string `json:"created_by,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
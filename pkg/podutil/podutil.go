@@ -0,0 +1,20 @@
+// Package podutil holds the helpers of the pods which run the functions.
+package podutil
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IsPodReady tells whether the pod is able to serve requests: it is ready,
+// it has an IP, and it is not being terminated.
+func IsPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
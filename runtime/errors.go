@@ -0,0 +1,120 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code classifies the errors returned by the functions.
+type Code string
+
+const (
+	// CodeInvalidInput means the input is malformed or not acceptable.
+	CodeInvalidInput Code = "InvalidInput"
+	// CodeNotFound means something the input refers to does not exist.
+	CodeNotFound Code = "NotFound"
+	// CodeTimeout means the invocation did not finish in time.
+	CodeTimeout Code = "Timeout"
+	// CodeInternal means the function is broken, it is used for the
+	// plain errors as well.
+	CodeInternal Code = "Internal"
+	// CodeUnavailable means the function or its dependencies can not
+	// serve for now, e.g. it is shutting down.
+	CodeUnavailable Code = "Unavailable"
+)
+
+// HTTPStatus returns the HTTP status code of the code.
+func (c Code) HTTPStatus() int {
+	switch c {
+	case CodeInvalidInput:
+		return http.StatusBadRequest
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is the error which functions can return to tell the clients what
+// went wrong, it is encoded as the "error" field of the response.
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	// Retryable tells the clients whether the same invocation may succeed
+	// if they try again.
+	Retryable bool                   `json:"retryable"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Errorf returns an Error with the code and the formatted message, the
+// timeout and unavailable errors are retryable by default.
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:      code,
+		Message:   fmt.Sprintf(format, args...),
+		Retryable: code == CodeTimeout || code == CodeUnavailable,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// WithDetails sets the key value pair into the details of the error.
+func (e *Error) WithDetails(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// WithRetryable overrides whether the error is retryable.
+func (e *Error) WithRetryable(retryable bool) *Error {
+	e.Retryable = retryable
+	return e
+}
+
+// AsError converts err into an Error, the plain errors become internal
+// errors, and the context errors become timeout or unavailable errors. The
+// wrapped errors are unwrapped as well.
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, context.DeadlineExceeded):
+		return Errorf(CodeTimeout, "invocation timed out")
+	case errors.Is(err, context.Canceled):
+		return Errorf(CodeUnavailable, "invocation cancelled")
+	}
+	return &Error{Code: CodeInternal, Message: err.Error()}
+}
+
+// invocationError converts the error returned by the invocation, the
+// function may return a plain error after the context is done.
+func invocationError(ctx context.Context, err error) *Error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		var e *Error
+		if !errors.As(err, &e) {
+			err = ctxErr
+		}
+	}
+	return AsError(err)
+}
+
+// recoverError turns the panic of the function into an internal error.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = Errorf(CodeInternal, "function panicked: %v", r)
+	}
+}
@@ -0,0 +1,47 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// The functions are declared without the build constraints, they are served
+// by the Supervisor natively, and by ServeWasm in the WebAssembly modules.
+
+// Function is the plain function which takes the "input" field of the
+// request as a string, NewSupervisor accepts the typed functions as well.
+type Function func(ctx context.Context, input string) (output string, err error)
+
+// StreamFunction reads the request body from r and streams the response
+// body into w, the response is flushed after every write.
+type StreamFunction func(ctx context.Context, r io.Reader, w io.Writer) error
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// typedFunction checks whether the function is like:
+//
+//	func(ctx context.Context, in In) (out Out, err error)
+func typedFunction(function interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(function)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return reflect.Value{}, fmt.Errorf("%T is not a function", function)
+	}
+	t := v.Type()
+	if t.NumIn() != 2 || t.In(0) != contextType || t.NumOut() != 2 || t.Out(1) != errorType {
+		return reflect.Value{}, fmt.Errorf("%s is not like func(context.Context, In) (Out, error)", t)
+	}
+	return v, nil
+}
+
+const (
+	// wasmErrorExitCode is the exit code of the WebAssembly modules whose
+	// functions fail, the error is the last line of the stderr, which starts
+	// with wasmErrorPrefix and is followed by the JSON of the Error.
+	wasmErrorExitCode = 3
+	wasmErrorPrefix   = "useless-error: "
+)
@@ -0,0 +1,140 @@
+//go:build !wasip1
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// handler invokes the function of the Supervisor, which is a Function, a
+// StreamFunction or a typed function like:
+//
+//	func(ctx context.Context, in In) (out Out, err error)
+//
+// The request body of the typed function is decoded into In directly, and
+// Out is encoded as the response body. The WebAssembly functions are run by
+// the wasmHost, the streaming ones are served as the StreamFunctions.
+type handler struct {
+	function Function
+	stream   StreamFunction
+	typed    reflect.Value
+	in       reflect.Type
+	// wasm runs the WebAssembly module, which writes the JSON response body.
+	wasm StreamFunction
+}
+
+func newHandler(function interface{}) (*handler, error) {
+	switch fn := function.(type) {
+	case Function:
+		return &handler{function: fn}, nil
+	case func(context.Context, string) (string, error):
+		return &handler{function: fn}, nil
+	case StreamFunction:
+		return &handler{stream: fn}, nil
+	case func(context.Context, io.Reader, io.Writer) error:
+		return &handler{stream: fn}, nil
+	case *WasmFunction:
+		host, err := newWasmHost(fn)
+		if err != nil {
+			return nil, err
+		}
+		if fn.Stream {
+			return &handler{stream: host.run}, nil
+		}
+		return &handler{wasm: host.run}, nil
+	}
+
+	v, err := typedFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	return &handler{typed: v, in: v.Type().In(1)}, nil
+}
+
+func (h *handler) invoke(ctx context.Context, c echo.Context) (_ interface{}, err error) {
+	defer recoverError(&err)
+	if h.function != nil {
+		var req struct {
+			Meta  string `json:"meta"`
+			Input string `json:"input"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return nil, Errorf(CodeInvalidInput, "malformed request: %v", err)
+		}
+		return h.function(ctx, req.Input)
+	}
+	if h.wasm != nil {
+		var output bytes.Buffer
+		if err := h.wasm(ctx, c.Request().Body, &output); err != nil {
+			return nil, err
+		}
+		if !json.Valid(output.Bytes()) {
+			return nil, Errorf(CodeInternal, "malformed output of the WebAssembly module")
+		}
+		return json.RawMessage(output.Bytes()), nil
+	}
+
+	in := reflect.New(h.in)
+	// An empty body stands for the zero value of In.
+	if err := json.NewDecoder(c.Request().Body).Decode(in.Interface()); err != nil && err != io.EOF {
+		return nil, Errorf(CodeInvalidInput, "decoding input as %s: %v", h.in, err)
+	}
+	out := h.typed.Call([]reflect.Value{reflect.ValueOf(ctx), in.Elem()})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+	return out[0].Interface(), nil
+}
+
+// respond writes the output of the function. The output of a typed function
+// or a WebAssembly module is the response body as is, while the one of a
+// Function is wrapped as {"output": output}.
+func (h *handler) respond(c echo.Context, output interface{}) error {
+	if h.function != nil {
+		return c.JSON(http.StatusOK, echo.Map{
+			"output": output,
+		})
+	}
+	return c.JSON(http.StatusOK, output)
+}
+
+func (h *handler) serveStream(ctx context.Context, c echo.Context) error {
+	resp := c.Response()
+	if resp.Header().Get(echo.HeaderContentType) == "" {
+		resp.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	}
+	err := h.runStream(ctx, c.Request().Body, flushWriter{resp})
+	if err == nil {
+		return nil
+	}
+	if resp.Committed {
+		// Too late to tell the client, the status has been sent.
+		c.Logger().Errorf("streaming: %v", err)
+		return nil
+	}
+	return writeError(c, invocationError(ctx, err))
+}
+
+func (h *handler) runStream(ctx context.Context, r io.Reader, w io.Writer) (err error) {
+	defer recoverError(&err)
+	return h.stream(ctx, r, w)
+}
+
+type flushWriter struct {
+	resp *echo.Response
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.resp.Write(p)
+	if err == nil {
+		w.resp.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,193 @@
+//go:build !wasip1
+
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func TestTypedHandler(t *testing.T) {
+	add := func(_ context.Context, p point) (point, error) {
+		return point{X: p.X + p.Y, Y: p.Y}, nil
+	}
+	raw := func(_ context.Context, p point) (json.RawMessage, error) {
+		return json.RawMessage(`{"sum":` + strconv.Itoa(p.X+p.Y) + `}`), nil
+	}
+
+	testCases := []struct {
+		name     string
+		function interface{}
+		body     string
+		status   int
+		expected string
+	}{
+		{
+			name:     "decoded",
+			function: add,
+			body:     `{"x":1,"y":2}`,
+			status:   http.StatusOK,
+			expected: `{"x":3,"y":2}`,
+		},
+		{
+			name:     "empty body",
+			function: add,
+			body:     "",
+			status:   http.StatusOK,
+			expected: `{"x":0,"y":0}`,
+		},
+		{
+			name:     "malformed",
+			function: add,
+			body:     `{"x":"1"}`,
+			status:   http.StatusBadRequest,
+			expected: `{"error":{"code":"InvalidInput","message":"decoding input as runtime.point: json: cannot unmarshal string into Go struct field point.x of type int","retryable":false}}`,
+		},
+		{
+			name:     "raw output",
+			function: raw,
+			body:     `{"x":1,"y":2}`,
+			status:   http.StatusOK,
+			expected: `{"sum":3}`,
+		},
+		{
+			name: "scalar",
+			function: func(_ context.Context, nums []int) (int, error) {
+				return len(nums), nil
+			},
+			body:     `[1,2,3]`,
+			status:   http.StatusOK,
+			expected: `3`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSupervisor("test", tc.function)
+			defer s.Close()
+			rec := serve(s, http.MethodPost, "/", tc.body)
+			if rec.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, rec.Code)
+			}
+			// The output is not wrapped like the one of a Function.
+			if body := strings.TrimSpace(rec.Body.String()); body != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, body)
+			}
+		})
+	}
+}
+
+func TestTypedHandlerInvalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		function interface{}
+	}{
+		{name: "not a function", function: "hello"},
+		{name: "no context", function: func(p point) (point, error) { return p, nil }},
+		{name: "no error", function: func(_ context.Context, p point) point { return p }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("expected NewSupervisor to panic")
+				}
+			}()
+			NewSupervisor("test", tc.function)
+		})
+	}
+}
+
+func TestStreamHandlerFlush(t *testing.T) {
+	release := make(chan struct{})
+	s := NewSupervisor("test", func(_ context.Context, r io.Reader, w io.Writer) error {
+		if _, err := io.WriteString(w, "first\n"); err != nil {
+			return err
+		}
+		<-release
+		_, err := io.WriteString(w, "second\n")
+		return err
+	})
+	defer s.Close()
+	server := httptest.NewServer(s.e)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "text/plain", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("expected the default content type, got %s", ct)
+	}
+	// The first line arrives before the function returns.
+	br := bufio.NewReader(resp.Body)
+	if line, err := br.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf(`expected "first\n" flushed, got %q, %v`, line, err)
+	}
+	close(release)
+	if rest, err := ioutil.ReadAll(br); err != nil || string(rest) != "second\n" {
+		t.Errorf(`expected "second\n", got %q, %v`, rest, err)
+	}
+}
+
+func TestStreamHandlerError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		partial  string
+		status   int
+		expected string
+	}{
+		{
+			name:     "before writing",
+			status:   http.StatusNotFound,
+			expected: `{"error":{"code":"NotFound","message":"no such thing","retryable":false}}`,
+		},
+		{
+			// The status has been sent, the error is only logged.
+			name:     "after a partial write",
+			partial:  "partial",
+			status:   http.StatusOK,
+			expected: "partial",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSupervisor("test", func(_ context.Context, r io.Reader, w io.Writer) error {
+				if tc.partial != "" {
+					if _, err := io.WriteString(w, tc.partial); err != nil {
+						return err
+					}
+				}
+				return Errorf(CodeNotFound, "no such thing")
+			})
+			defer s.Close()
+			var logs bytes.Buffer
+			s.e.Logger.SetOutput(&logs)
+
+			rec := serve(s, http.MethodPost, "/", "")
+			if rec.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, rec.Code)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, body)
+			}
+			if logged := strings.Contains(logs.String(), "no such thing"); logged != (tc.partial != "") {
+				t.Errorf("expected the error logged only after a partial write, got %q", logs.String())
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
+//go:build !wasip1
+
 package runtime
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -12,75 +16,196 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 )
 
-const defaultTimeout = 60 * time.Second
+const (
+	defaultTimeout      = 60 * time.Second
+	defaultDrainTimeout = 30 * time.Second
+)
+
+const (
+	// StatsPath is the path which serves the Stats.
+	StatsPath = "/stats"
+	// HealthzPath is the path of the liveness probe.
+	HealthzPath = "/healthz"
+	// ReadyzPath is the path of the readiness probe, it fails while the
+	// warmup is running and during shutdown.
+	ReadyzPath = "/readyz"
+)
+
+// Stats is used by the controller to autoscale the function.
+type Stats struct {
+	// InFlight is the number of the running invocations.
+	InFlight int64 `json:"inflight"`
+	// Requests is the total number of invocations.
+	Requests int64 `json:"requests"`
+}
+
+// Option configures the Supervisor.
+type Option func(s *Supervisor)
+
+// WithWarmup sets the function which runs before the Supervisor becomes
+// ready, e.g. to fill the caches or to set up connections.
+func WithWarmup(warmup func(ctx context.Context) error) Option {
+	return func(s *Supervisor) {
+		s.warmup = warmup
+	}
+}
 
-type Function func(ctx context.Context, input string) (output string, err error)
+// WithDrainTimeout sets how long the in-flight invocations can run after
+// the shutdown starts, they are cancelled at the deadline.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(s *Supervisor) {
+		s.drainTimeout = timeout
+	}
+}
 
 type Supervisor struct {
-	name     string
-	function Function
-	e        *echo.Echo
+	name         string
+	handler      *handler
+	warmup       func(ctx context.Context) error
+	drainTimeout time.Duration
+	e            *echo.Echo
+	// ctx is the parent of the invocations' contexts.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	ready    int32
+	inflight int64
+	requests int64
 }
 
-func NewSupervisor(name string, function Function) *Supervisor {
+// NewSupervisor returns a Supervisor which serves the function, it is either
+// a Function, a StreamFunction or func(context.Context, In) (Out, error),
+// where In and Out are anything which can be decoded from and encoded into
+// JSON, or a *WasmFunction which is any of them compiled to WebAssembly, it
+// panics if the function is none of them.
+func NewSupervisor(name string, function interface{}, opts ...Option) *Supervisor {
+	h, err := newHandler(function)
+	if err != nil {
+		panic(fmt.Sprintf("useless: function %s: %v", name, err))
+	}
 	s := &Supervisor{
-		name:     name,
-		function: function,
-		e:        echo.New(),
+		name:         name,
+		handler:      h,
+		drainTimeout: defaultDrainTimeout,
+		e:            echo.New(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(s)
 	}
 	s.e.HideBanner = true
 	// e.Use(middleware.Logger())
 	s.e.Use(middleware.Recover())
 	s.e.POST("/", s.handle)
 	s.e.GET("/meta", s.meta)
+	s.e.GET(StatsPath, s.stats)
+	s.e.GET(HealthzPath, s.healthz)
+	s.e.GET(ReadyzPath, s.readyz)
 	return s
 }
 
-func (s Supervisor) Run(laddr string) error {
-	errc := make(chan error, 1)
+// Run serves until it receives SIGINT or SIGTERM, then it stops accepting new
+// requests and waits for the in-flight invocations to finish.
+func (s *Supervisor) Run(laddr string) error {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	return s.run(laddr, sigc)
+}
+
+// run serves until a signal is received from sigc.
+func (s *Supervisor) run(laddr string, sigc <-chan os.Signal) error {
+	errc := make(chan error, 2)
 	go func() {
 		errc <- s.e.Start(laddr)
 	}()
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		if s.warmup != nil {
+			ctx, cancel := context.WithTimeout(context.TODO(), defaultTimeout)
+			defer cancel()
+			if err := s.warmup(ctx); err != nil {
+				errc <- fmt.Errorf("warmup: %v", err)
+				return
+			}
+		}
+		atomic.StoreInt32(&s.ready, 1)
+	}()
 	select {
 	case <-sigc:
-		return nil
+		atomic.StoreInt32(&s.ready, 0)
+		return s.shutdown()
 	case err := <-errc:
+		atomic.StoreInt32(&s.ready, 0)
 		return err
 	}
 }
 
-func (s Supervisor) Close() error {
+func (s *Supervisor) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+	if err := s.e.Shutdown(ctx); err == nil {
+		return nil
+	}
+
+	// Cancel the invocations only at the deadline, and give them a moment
+	// to respond.
+	s.cancel()
+	for i := 0; i < 100 && atomic.LoadInt64(&s.inflight) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
 	return s.e.Close()
 }
 
-func (s Supervisor) meta(c echo.Context) error {
+func (s *Supervisor) Close() error {
+	s.cancel()
+	return s.e.Close()
+}
+
+func (s *Supervisor) meta(c echo.Context) error {
 	return c.JSON(http.StatusOK, echo.Map{
 		"name": s.name,
 	})
 }
 
-func (s Supervisor) handle(c echo.Context) error {
-	var (
-		req struct {
-			Meta  string `json:"meta"`
-			Input string `json:"input"`
-		}
-		output string
-		err    error
-	)
-	if err = c.Bind(&req); err == nil {
-		ctx, cancel := context.WithTimeout(context.TODO(), defaultTimeout)
-		defer cancel()
-		output, err = s.function(ctx, req.Input)
+func (s *Supervisor) healthz(c echo.Context) error {
+	return c.NoContent(http.StatusOK)
+}
+
+func (s *Supervisor) readyz(c echo.Context) error {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		return c.NoContent(http.StatusServiceUnavailable)
 	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (s *Supervisor) stats(c echo.Context) error {
+	return c.JSON(http.StatusOK, Stats{
+		InFlight: atomic.LoadInt64(&s.inflight),
+		Requests: atomic.LoadInt64(&s.requests),
+	})
+}
+
+func (s *Supervisor) handle(c echo.Context) error {
+	atomic.AddInt64(&s.requests, 1)
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+
+	ctx, cancel := context.WithTimeout(s.ctx, defaultTimeout)
+	defer cancel()
+	if s.handler.stream != nil {
+		return s.handler.serveStream(ctx, c)
+	}
+	output, err := s.handler.invoke(ctx, c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": err.Error(),
-		})
+		return writeError(c, invocationError(ctx, err))
 	}
-	return c.JSON(http.StatusBadRequest, echo.Map{
-		"output": output,
+	return s.handler.respond(c, output)
+}
+
+// writeError writes the error envelope: {"error": {"code": .., "message": ..,
+// "retryable": .., "details": ..}}.
+func writeError(c echo.Context, err *Error) error {
+	return c.JSON(err.Code.HTTPStatus(), echo.Map{
+		"error": err,
 	})
 }
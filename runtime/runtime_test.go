@@ -0,0 +1,371 @@
+//go:build !wasip1
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// serve sends the request to the Supervisor without listening.
+func serve(s *Supervisor, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+func getStats(t *testing.T, s *Supervisor) Stats {
+	rec := serve(s, http.MethodGet, StatsPath, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	var stats Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestStats(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := NewSupervisor("test", func(ctx context.Context, input string) (string, error) {
+		started <- struct{}{}
+		<-release
+		return input, nil
+	})
+	defer s.Close()
+
+	if stats := getStats(t, s); stats != (Stats{}) {
+		t.Errorf("expected no invocation, got %+v", stats)
+	}
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			serve(s, http.MethodPost, "/", `{"input":"x"}`)
+			done <- struct{}{}
+		}()
+		<-started
+	}
+	if stats := getStats(t, s); stats != (Stats{InFlight: 2, Requests: 2}) {
+		t.Errorf("expected 2 running invocations, got %+v", stats)
+	}
+	close(release)
+	<-done
+	<-done
+	if stats := getStats(t, s); stats != (Stats{InFlight: 0, Requests: 2}) {
+		t.Errorf("expected 2 finished invocations, got %+v", stats)
+	}
+	// The probes and the stats are not counted.
+	serve(s, http.MethodGet, HealthzPath, "")
+	if stats := getStats(t, s); stats.Requests != 2 {
+		t.Errorf("expected 2 requests, got %+v", stats)
+	}
+}
+
+func echoFunction(_ context.Context, input string) (string, error) {
+	return input, nil
+}
+
+// waitStatus polls the path until it returns the status.
+func waitStatus(t *testing.T, s *Supervisor, path string, status int) {
+	t.Helper()
+	code := 0
+	for i := 0; i < 200; i++ {
+		if code = serve(s, http.MethodGet, path, "").Code; code == status {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %s to return %d, got %d", path, status, code)
+}
+
+func TestReadyzAfterWarmup(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := NewSupervisor("test", echoFunction, WithWarmup(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	defer s.Close()
+	sigc := make(chan os.Signal, 1)
+	errc := make(chan error, 1)
+	go func() { errc <- s.run("127.0.0.1:0", sigc) }()
+
+	<-started
+	if code := serve(s, http.MethodGet, ReadyzPath, "").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 during the warmup, got %d", code)
+	}
+	// The liveness probe passes during the warmup.
+	if code := serve(s, http.MethodGet, HealthzPath, "").Code; code != http.StatusOK {
+		t.Errorf("expected the healthz 200 during the warmup, got %d", code)
+	}
+	close(release)
+	waitStatus(t, s, ReadyzPath, http.StatusOK)
+
+	sigc <- syscall.SIGTERM
+	if err := <-errc; err != nil {
+		t.Errorf("expected the graceful shutdown, got %v", err)
+	}
+	if code := serve(s, http.MethodGet, ReadyzPath, "").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after the shutdown, got %d", code)
+	}
+}
+
+func TestWarmupError(t *testing.T) {
+	s := NewSupervisor("test", echoFunction, WithWarmup(func(ctx context.Context) error {
+		return errors.New("no database")
+	}))
+	defer s.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.run("127.0.0.1:0", make(chan os.Signal)) }()
+	select {
+	case err := <-errc:
+		if err == nil || !strings.Contains(err.Error(), "warmup: no database") {
+			t.Errorf("expected the warmup error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to end once the warmup fails")
+	}
+	if code := serve(s, http.MethodGet, ReadyzPath, "").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after the warmup failed, got %d", code)
+	}
+}
+
+// listen starts the Supervisor on a local port, and returns the URL and the
+// result of run.
+func listen(t *testing.T, s *Supervisor, sigc <-chan os.Signal) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.e.Listener = ln
+	errc := make(chan error, 1)
+	go func() { errc <- s.run("", sigc) }()
+	return "http://" + ln.Addr().String(), errc
+}
+
+func TestShutdownDrain(t *testing.T) {
+	testCases := []struct {
+		name   string
+		finish bool
+		status int
+	}{
+		{name: "finished", finish: true, status: http.StatusOK},
+		{name: "cancelled", finish: false, status: http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			const drainTimeout = 200 * time.Millisecond
+			started, release := make(chan struct{}), make(chan struct{})
+			cancelled := make(chan time.Time, 1)
+			s := NewSupervisor("test", func(ctx context.Context, input string) (string, error) {
+				close(started)
+				select {
+				case <-release:
+					return input, nil
+				case <-ctx.Done():
+					cancelled <- time.Now()
+					return "", ctx.Err()
+				}
+			}, WithDrainTimeout(drainTimeout))
+			defer s.Close()
+			sigc := make(chan os.Signal, 1)
+			url, errc := listen(t, s, sigc)
+			waitStatus(t, s, ReadyzPath, http.StatusOK)
+
+			respc := make(chan *http.Response, 1)
+			go func() {
+				resp, err := http.Post(url, "application/json", strings.NewReader(`{"input":"x"}`))
+				if err != nil {
+					t.Error(err)
+				}
+				respc <- resp
+			}()
+			<-started
+			signalled := time.Now()
+			sigc <- syscall.SIGTERM
+			waitStatus(t, s, ReadyzPath, http.StatusServiceUnavailable)
+			if tc.finish {
+				close(release)
+			}
+
+			resp := <-respc
+			if resp == nil {
+				t.FailNow()
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, resp.StatusCode)
+			}
+			err := <-errc
+			select {
+			case at := <-cancelled:
+				if tc.finish {
+					t.Error("expected the invocation not cancelled inside the drain window")
+				} else if elapsed := at.Sub(signalled); elapsed < drainTimeout {
+					t.Errorf("expected the invocation cancelled at the deadline, got after %v", elapsed)
+				}
+			default:
+				if !tc.finish {
+					t.Error("expected the invocation cancelled at the deadline")
+				}
+			}
+			if tc.finish && err != nil {
+				t.Errorf("expected the graceful shutdown, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCodeHTTPStatus(t *testing.T) {
+	testCases := []struct {
+		code   Code
+		status int
+	}{
+		{CodeInvalidInput, http.StatusBadRequest},
+		{CodeNotFound, http.StatusNotFound},
+		{CodeTimeout, http.StatusGatewayTimeout},
+		{CodeInternal, http.StatusInternalServerError},
+		{CodeUnavailable, http.StatusServiceUnavailable},
+		{Code("Unknown"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		if status := tc.code.HTTPStatus(); status != tc.status {
+			t.Errorf("expected %s to be %d, got %d", tc.code, tc.status, status)
+		}
+	}
+}
+
+func TestAsError(t *testing.T) {
+	notFound := Errorf(CodeNotFound, "no user %d", 1)
+	testCases := []struct {
+		name     string
+		err      error
+		expected *Error
+	}{
+		{name: "nil", err: nil, expected: nil},
+		{name: "error", err: notFound, expected: notFound},
+		{name: "wrapped error", err: fmt.Errorf("querying: %w", notFound), expected: notFound},
+		{name: "plain", err: errors.New("broken"), expected: &Error{Code: CodeInternal, Message: "broken"}},
+		{
+			name:     "timeout",
+			err:      context.DeadlineExceeded,
+			expected: &Error{Code: CodeTimeout, Message: "invocation timed out", Retryable: true},
+		},
+		{
+			name:     "wrapped timeout",
+			err:      fmt.Errorf("querying: %w", context.DeadlineExceeded),
+			expected: &Error{Code: CodeTimeout, Message: "invocation timed out", Retryable: true},
+		},
+		{
+			name:     "cancelled",
+			err:      context.Canceled,
+			expected: &Error{Code: CodeUnavailable, Message: "invocation cancelled", Retryable: true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if e := AsError(tc.err); !reflect.DeepEqual(e, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, e)
+			}
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		function interface{}
+		status   int
+		expected string
+	}{
+		{
+			name: "error",
+			function: func(context.Context, string) (string, error) {
+				return "", Errorf(CodeNotFound, "no user").WithDetails("id", 1)
+			},
+			status:   http.StatusNotFound,
+			expected: `{"error":{"code":"NotFound","message":"no user","retryable":false,"details":{"id":1}}}`,
+		},
+		{
+			name: "wrapped error",
+			function: func(context.Context, string) (string, error) {
+				return "", fmt.Errorf("querying: %w", Errorf(CodeInvalidInput, "bad id"))
+			},
+			status:   http.StatusBadRequest,
+			expected: `{"error":{"code":"InvalidInput","message":"bad id","retryable":false}}`,
+		},
+		{
+			name: "plain error",
+			function: func(context.Context, string) (string, error) {
+				return "", errors.New("broken")
+			},
+			status:   http.StatusInternalServerError,
+			expected: `{"error":{"code":"Internal","message":"broken","retryable":false}}`,
+		},
+		{
+			name: "timeout",
+			function: func(ctx context.Context, _ string) (string, error) {
+				ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+				defer cancel()
+				<-ctx.Done()
+				return "", fmt.Errorf("querying: %w", ctx.Err())
+			},
+			status:   http.StatusGatewayTimeout,
+			expected: `{"error":{"code":"Timeout","message":"invocation timed out","retryable":true}}`,
+		},
+		{
+			name: "panic",
+			function: func(context.Context, string) (string, error) {
+				panic("oops")
+			},
+			status:   http.StatusInternalServerError,
+			expected: `{"error":{"code":"Internal","message":"function panicked: oops","retryable":false}}`,
+		},
+		{
+			name: "typed panic",
+			function: func(context.Context, struct{ Input string }) (int, error) {
+				panic("oops")
+			},
+			status:   http.StatusInternalServerError,
+			expected: `{"error":{"code":"Internal","message":"function panicked: oops","retryable":false}}`,
+		},
+		{
+			name: "stream panic",
+			function: func(context.Context, io.Reader, io.Writer) error {
+				panic("oops")
+			},
+			status:   http.StatusInternalServerError,
+			expected: `{"error":{"code":"Internal","message":"function panicked: oops","retryable":false}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSupervisor("test", tc.function)
+			defer s.Close()
+			rec := serve(s, http.MethodPost, "/", `{"input":"x"}`)
+			if rec.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, rec.Code)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+;; guest.wasm is the WebAssembly module of the tests of WasmFunction, it is
+;; built by `wat2wasm guest.wat`. It reads up to 1KiB of the stdin, and the
+;; first byte chooses what it does:
+;;
+;;   e  writes an Error the way ServeWasm does, and exits with code 3
+;;   x  exits with code 3 without the Error
+;;   l  loops forever
+;;   g  grows the memory to 64 pages (4MiB), it traps if it can not
+;;
+;; and then it echoes the stdin to the stdout.
+(module
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+
+  (memory (export "memory") 1)
+  ;; The iovec of the stdin is at 0, the number of bytes read is at 8, the
+  ;; iovec of the writes is at 16, the number of bytes written is at 24.
+  (data (i32.const 256) "useless-error: {\"code\":\"NotFound\",\"message\":\"no such thing\"}\n")
+
+  (func (export "_start")
+    (i32.store (i32.const 0) (i32.const 1024))
+    (i32.store (i32.const 4) (i32.const 1024))
+    (drop (call $fd_read (i32.const 0) (i32.const 0) (i32.const 1) (i32.const 8)))
+
+    (if (i32.eq (i32.load8_u (i32.const 1024)) (i32.const 101)) ;; e
+      (then
+        (i32.store (i32.const 16) (i32.const 256))
+        (i32.store (i32.const 20) (i32.const 61))
+        (drop (call $fd_write (i32.const 2) (i32.const 16) (i32.const 1) (i32.const 24)))
+        (call $proc_exit (i32.const 3))))
+    (if (i32.eq (i32.load8_u (i32.const 1024)) (i32.const 120)) ;; x
+      (then
+        (call $proc_exit (i32.const 3))))
+    (if (i32.eq (i32.load8_u (i32.const 1024)) (i32.const 108)) ;; l
+      (then
+        (loop $forever (br $forever))))
+    (if (i32.eq (i32.load8_u (i32.const 1024)) (i32.const 103)) ;; g
+      (then
+        (if (i32.eq (memory.grow (i32.const 63)) (i32.const -1))
+          (then unreachable))))
+
+    (i32.store (i32.const 16) (i32.const 1024))
+    (i32.store (i32.const 20) (i32.load (i32.const 8)))
+    (drop (call $fd_write (i32.const 1) (i32.const 16) (i32.const 1) (i32.const 24)))))
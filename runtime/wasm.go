@@ -0,0 +1,158 @@
+//go:build !wasip1
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	wasmPageSize    = 64 << 10
+	wasmMaxPages    = 1 << 16
+	wasmMemoryLimit = 64 << 20
+	// wasmStderrTail is how much of the stderr is kept to find the error of
+	// the invocation, the whole stderr goes to the logs.
+	wasmStderrTail = 64 << 10
+)
+
+// WasmFunction is a function compiled to WebAssembly with GOOS=wasip1, whose
+// main calls ServeWasm. The module is instantiated in a sandbox for every
+// invocation, which can not access the filesystem, the network or the
+// environment of the Supervisor: the request body is its stdin, and the
+// response body is its stdout.
+type WasmFunction struct {
+	// Module is the binary of the WebAssembly module.
+	Module []byte
+	// Stream tells whether the function is a StreamFunction, the others
+	// write the JSON response body, the same as the Supervisor does.
+	Stream bool
+	// MemoryLimit is the maximum memory of an invocation in bytes, it is
+	// rounded down to the WebAssembly pages, defaults to 64MiB.
+	MemoryLimit uint64
+	// Timeout is the maximum execution time of an invocation, it can not be
+	// longer than the default timeout of the Supervisor.
+	Timeout time.Duration
+}
+
+// wasmHost runs the WebAssembly module which is compiled once.
+type wasmHost struct {
+	function *WasmFunction
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+func newWasmHost(function *WasmFunction) (*wasmHost, error) {
+	limit := function.MemoryLimit
+	if limit == 0 {
+		limit = wasmMemoryLimit
+	}
+	pages := limit / wasmPageSize
+	if pages == 0 || pages > wasmMaxPages {
+		return nil, fmt.Errorf("memory limit %d is out of range [%d, %d]", limit, wasmPageSize, wasmMaxPages*wasmPageSize)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(pages)).
+		// The invocations are stopped once their contexts are done.
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("instantiate WASI: %v", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, function.Module)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("compile WebAssembly module: %v", err)
+	}
+	return &wasmHost{function: function, runtime: runtime, compiled: compiled}, nil
+}
+
+// run instantiates the module with r as its stdin and w as its stdout, the
+// module runs until its main returns.
+func (h *wasmHost) run(ctx context.Context, r io.Reader, w io.Writer) error {
+	if h.function.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.function.Timeout)
+		defer cancel()
+	}
+	stderr := &tailBuffer{max: wasmStderrTail}
+	config := wazero.NewModuleConfig().
+		// The instances are anonymous, so they can run concurrently.
+		WithName("").
+		WithArgs("function").
+		WithStdin(r).
+		WithStdout(w).
+		WithStderr(io.MultiWriter(os.Stderr, stderr)).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	module, err := h.runtime.InstantiateModule(ctx, h.compiled, config)
+	if module != nil {
+		module.Close(ctx)
+	}
+	if err == nil {
+		return nil
+	}
+	exitErr, ok := err.(*sys.ExitError)
+	if !ok {
+		// Traps, e.g. the memory limit is exceeded.
+		return Errorf(CodeInternal, "the WebAssembly module failed: %v", err)
+	}
+	switch exitErr.ExitCode() {
+	case 0:
+		return nil
+	case sys.ExitCodeDeadlineExceeded:
+		return context.DeadlineExceeded
+	case sys.ExitCodeContextCanceled:
+		return context.Canceled
+	case wasmErrorExitCode:
+		if e := parseWasmError(stderr.buf); e != nil {
+			return e
+		}
+	}
+	return Errorf(CodeInternal, "the WebAssembly module exited with code %d", exitErr.ExitCode())
+}
+
+// parseWasmError finds the Error written by ServeWasm in the stderr.
+func parseWasmError(stderr []byte) *Error {
+	i := bytes.LastIndex(stderr, []byte(wasmErrorPrefix))
+	if i < 0 {
+		return nil
+	}
+	line := stderr[i+len(wasmErrorPrefix):]
+	if j := bytes.IndexByte(line, '\n'); j >= 0 {
+		line = line[:j]
+	}
+	var e Error
+	if err := json.Unmarshal(line, &e); err != nil || e.Code == "" {
+		return nil
+	}
+	return &e
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,83 @@
+//go:build wasip1
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+// ServeWasm invokes the function once, it is the main of the function which
+// is compiled to WebAssembly and run by the Supervisor as a WasmFunction. The
+// function is one of the ones accepted by NewSupervisor, the request body is
+// read from the stdin, and the response body is written to the stdout.
+//
+// The prints of the functions other than the StreamFunctions go to the
+// stderr, the stdout is reserved for the output.
+func ServeWasm(function interface{}) {
+	// The deadline is enforced by the Supervisor.
+	err := serveWasm(context.Background(), function)
+	if err == nil {
+		return
+	}
+	data, _ := json.Marshal(AsError(err))
+	fmt.Fprintf(os.Stderr, "\n%s%s\n", wasmErrorPrefix, data)
+	os.Exit(wasmErrorExitCode)
+}
+
+func serveWasm(ctx context.Context, function interface{}) (err error) {
+	defer recoverError(&err)
+	switch fn := function.(type) {
+	case Function:
+		return serveWasmFunction(ctx, fn)
+	case func(context.Context, string) (string, error):
+		return serveWasmFunction(ctx, fn)
+	case StreamFunction:
+		return fn(ctx, os.Stdin, os.Stdout)
+	case func(context.Context, io.Reader, io.Writer) error:
+		return fn(ctx, os.Stdin, os.Stdout)
+	}
+
+	v, err := typedFunction(function)
+	if err != nil {
+		return err
+	}
+	in := reflect.New(v.Type().In(1))
+	// An empty input stands for the zero value of In.
+	if err := json.NewDecoder(os.Stdin).Decode(in.Interface()); err != nil && err != io.EOF {
+		return Errorf(CodeInvalidInput, "decoding input as %s: %v", v.Type().In(1), err)
+	}
+	stdout := redirectStdout()
+	out := v.Call([]reflect.Value{reflect.ValueOf(ctx), in.Elem()})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return err
+	}
+	return json.NewEncoder(stdout).Encode(out[0].Interface())
+}
+
+func serveWasmFunction(ctx context.Context, fn Function) error {
+	var req struct {
+		Meta  string `json:"meta"`
+		Input string `json:"input"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		return Errorf(CodeInvalidInput, "malformed request: %v", err)
+	}
+	stdout := redirectStdout()
+	output, err := fn(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(stdout).Encode(map[string]string{"output": output})
+}
+
+// redirectStdout sends the prints to the stderr, and returns the stdout.
+func redirectStdout() *os.File {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	return stdout
+}
@@ -0,0 +1,190 @@
+//go:build !wasip1
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+func TestParseWasmError(t *testing.T) {
+	cases := []struct {
+		name   string
+		stderr string
+		want   *Error
+	}{
+		{
+			name:   "error",
+			stderr: "print\n" + wasmErrorPrefix + `{"code":"NotFound","message":"no such thing"}` + "\n",
+			want:   &Error{Code: CodeNotFound, Message: "no such thing"},
+		},
+		{
+			name:   "without newline",
+			stderr: wasmErrorPrefix + `{"code":"InvalidInput","message":"bad","retryable":true}`,
+			want:   &Error{Code: CodeInvalidInput, Message: "bad", Retryable: true},
+		},
+		{
+			name: "last one wins",
+			stderr: wasmErrorPrefix + `{"code":"NotFound","message":"printed"}` + "\n" +
+				wasmErrorPrefix + `{"code":"Internal","message":"returned"}` + "\n",
+			want: &Error{Code: CodeInternal, Message: "returned"},
+		},
+		{
+			name:   "details",
+			stderr: wasmErrorPrefix + `{"code":"NotFound","message":"m","details":{"id":"1"}}`,
+			want:   &Error{Code: CodeNotFound, Message: "m", Details: map[string]interface{}{"id": "1"}},
+		},
+		{name: "no error", stderr: "panic: oops\n"},
+		{name: "empty", stderr: ""},
+		{name: "malformed", stderr: wasmErrorPrefix + `{"code":` + "\n"},
+		{name: "no code", stderr: wasmErrorPrefix + `{"message":"m"}` + "\n"},
+		{name: "truncated", stderr: "useless-err"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := parseWasmError([]byte(c.stderr))
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 8}
+	for _, s := range []string{"abc", "defgh", "ij", "", "klmnopqrstuvwxyz"} {
+		n, err := b.Write([]byte(s))
+		if n != len(s) || err != nil {
+			t.Fatalf("write %q: got (%d, %v), want (%d, nil)", s, n, err, len(s))
+		}
+	}
+	if got := string(b.buf); got != "stuvwxyz" {
+		t.Errorf("got %q, want %q", got, "stuvwxyz")
+	}
+
+	b = &tailBuffer{max: 8}
+	b.Write([]byte("abc"))
+	b.Write([]byte("de"))
+	if got := string(b.buf); got != "abcde" {
+		t.Errorf("got %q, want %q", got, "abcde")
+	}
+}
+
+// testdata/guest.wasm is built from testdata/guest.wat, the first byte of
+// the input chooses what it does.
+func newTestWasmHost(t *testing.T, function WasmFunction) *wasmHost {
+	module, err := ioutil.ReadFile("testdata/guest.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	function.Module = module
+	host, err := newWasmHost(&function)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { host.runtime.Close(context.Background()) })
+	return host
+}
+
+func TestWasmHost(t *testing.T) {
+	host := newTestWasmHost(t, WasmFunction{})
+
+	var stdout bytes.Buffer
+	if err := host.run(context.Background(), strings.NewReader("hello"), &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "hello" {
+		t.Errorf("stdout: got %q, want %q", stdout.String(), "hello")
+	}
+
+	err := host.run(context.Background(), strings.NewReader("e"), ioutil.Discard)
+	want := &Error{Code: CodeNotFound, Message: "no such thing"}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("error: got %#v, want %#v", err, want)
+	}
+
+	err = host.run(context.Background(), strings.NewReader("x"), ioutil.Discard)
+	if e, ok := err.(*Error); !ok || e.Code != CodeInternal || !strings.Contains(e.Message, "code 3") {
+		t.Errorf("exit without error: got %#v, want an internal error of code 3", err)
+	}
+}
+
+func TestWasmHostMemoryLimit(t *testing.T) {
+	for _, limit := range []uint64{1, (wasmMaxPages + 1) * wasmPageSize} {
+		if _, err := newWasmHost(&WasmFunction{MemoryLimit: limit}); err == nil {
+			t.Errorf("memory limit %d: got no error", limit)
+		}
+	}
+
+	// The guest grows its memory to 4MiB.
+	host := newTestWasmHost(t, WasmFunction{MemoryLimit: 4 << 20})
+	if err := host.run(context.Background(), strings.NewReader("g"), ioutil.Discard); err != nil {
+		t.Errorf("under the limit: %v", err)
+	}
+	host = newTestWasmHost(t, WasmFunction{MemoryLimit: 4<<20 - 1})
+	err := host.run(context.Background(), strings.NewReader("g"), ioutil.Discard)
+	if e, ok := err.(*Error); !ok || e.Code != CodeInternal {
+		t.Errorf("over the limit: got %#v, want an internal error", err)
+	}
+}
+
+func TestWasmHostTimeout(t *testing.T) {
+	host := newTestWasmHost(t, WasmFunction{Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	if err := host.run(context.Background(), strings.NewReader("l"), ioutil.Discard); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the module is stopped after %v", elapsed)
+	}
+
+	host = newTestWasmHost(t, WasmFunction{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := host.run(ctx, strings.NewReader("l"), ioutil.Discard); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWasmHandler(t *testing.T) {
+	module, err := ioutil.ReadFile("testdata/guest.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newHandler(&WasmFunction{Module: module})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The guest echoes the input, which is the response body as is, the same
+	// as the output of a typed function.
+	body := `{"sum":6}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	output, err := h.invoke(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.respond(c, output); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != body {
+		t.Errorf("body: got %q, want %q", got, body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not json"))
+	_, err = h.invoke(context.Background(), echo.New().NewContext(req, httptest.NewRecorder()))
+	if e, ok := err.(*Error); !ok || e.Code != CodeInternal {
+		t.Errorf("malformed output: got %#v, want an internal error", err)
+	}
+}